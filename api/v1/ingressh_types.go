@@ -20,6 +20,18 @@ type AuthorizedKey struct {
 	// The keys are specified in the same format as lines in the
 	// .ssh/authorized_keys file
 	Key string `json:"key"`
+
+	// ReadOnly grants the user a view-only access: the session mirrors the
+	// output of the target container, but the user's input is never sent
+	// to it. The key is read-only as well if the whole resource is
+	// configured as read-only.
+	// +optional
+	ReadOnly bool `json:"readOnly,omitempty"`
+
+	// Auditor allows the user to replay the sessions recorded for this
+	// resource, connecting to the server as replay@server.
+	// +optional
+	Auditor bool `json:"auditor,omitempty"`
 }
 
 // IngreSshSpec defines the desired state of IngreSsh
@@ -37,6 +49,15 @@ type IngreSshSpec struct {
 	// +optional
 	Session string `json:"session,omitempty"`
 
+	// Aliases are the names of this route to use as the login part of the
+	// SSH connection string, like `ssh nginx-debug@cluster`. When the login
+	// name matches an alias, only the configuration of this resource is used
+	// for the session, even if the user is authorized by other resources
+	// for the same pods.
+	// +kubebuilder:validation:items:Pattern=`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`
+	// +optional
+	Aliases []string `json:"aliases,omitempty"`
+
 	// Image for the ephemeral container. If not specified the default from the
 	// server configuration is used. The option is relevant for the Debug
 	// type sessions. For the Exec type sessions it has no effect.
@@ -112,6 +133,73 @@ type IngreSshSpec struct {
 	// +optional
 	Containers []string `json:"containers,omitempty"`
 
+	// ReadOnly makes all the sessions of this resource view-only: users
+	// can watch the output of the attached container (f.e. the console of
+	// a debug container or the stdout of a running process), but their
+	// input is dropped. The users can't run their own commands either.
+	// Individual keys can be made read-only with the corresponding field of
+	// the authorized key.
+	// +optional
+	ReadOnly bool `json:"readOnly,omitempty"`
+
+	// PodSelection specifies how to choose the pod when the user's request
+	// matches several of them: the first ready pod (FirstReady), a random
+	// one (Random), the pod with the least number of active SSH sessions
+	// (LeastSessions), or the same pod for the same user as long as it
+	// exists (Sticky). With any policy the ready pods are preferred, and
+	// terminating, pending and crash looping pods are never chosen. The
+	// interactive pod list is ordered accordingly. FirstReady is the default.
+	// +kubebuilder:validation:Enum=FirstReady;Random;LeastSessions;Sticky
+	// +optional
+	PodSelection string `json:"podSelection,omitempty"`
+
+	// Strict makes the non-interactive sessions fail when the user's request
+	// matches several targets, any of them granted by this resource, instead
+	// of choosing one of them with the pod selection policy. The session
+	// exits with a non-zero code and prints the list of the candidate targets
+	// in JSON. The requests matching only the targets of the other resources
+	// are not affected. Clients can request the strict mode for any resource
+	// with the INGRESSH_STRICT=1 environment variable (f.e.
+	// `ssh -o SetEnv=INGRESSH_STRICT=1 cluster ls`).
+	// +optional
+	Strict bool `json:"strict,omitempty"`
+
+	// Record enables recording of the sessions in asciicast v2 format. The
+	// storage of the recordings is configured for the server. Sessions can't
+	// be started if the recording is enabled but the storage is not
+	// configured or not available.
+	// +optional
+	Record bool `json:"record,omitempty"`
+
+	// IdleTimeout closes the session when there is neither input nor output
+	// for the duration, f.e. `30m`. The user is warned before the session
+	// is closed. If not specified, the default from the server configuration
+	// is used. Zero disables the timeout.
+	// +optional
+	IdleTimeout *metav1.Duration `json:"idleTimeout,omitempty"`
+
+	// MaxSessionDuration closes the session when it lasts for the duration,
+	// f.e. `8h`, even if the session is active. The user is warned before
+	// the session is closed. If not specified, the default from the server
+	// configuration is used. Zero disables the limit.
+	// +optional
+	MaxSessionDuration *metav1.Duration `json:"maxSessionDuration,omitempty"`
+
+	// KeepAliveInterval is the interval of the keepalive requests sent to
+	// the SSH client, f.e. `30s`. The session is closed when the client
+	// doesn't answer three requests in a row, so the streams of the dead
+	// connections are released. If not specified, the default from the
+	// server configuration is used. Zero disables the keepalive requests.
+	// +optional
+	KeepAliveInterval *metav1.Duration `json:"keepAliveInterval,omitempty"`
+
+	// SourceRanges is the list of the CIDRs, like 10.0.0.0/8, the users may
+	// connect from. The keys of the users connecting from the other addresses
+	// don't match this resource. Invalid CIDRs match no addresses.
+	// If not specified, the users may connect from any address.
+	// +optional
+	SourceRanges []string `json:"sourceRanges,omitempty"`
+
 	// AuthorizedKeys is a set of public keys to authorize login
 	// The keys are specified in the same format as lines in the
 	// .ssh/authorized_keys file
@@ -120,20 +208,66 @@ type IngreSshSpec struct {
 	AuthorizedKeys []AuthorizedKey `json:"authorizedKeys"`
 }
 
+// Pod selection policies, see IngreSshSpec.PodSelection
+const (
+	PodSelectionFirstReady    = "FirstReady"
+	PodSelectionRandom        = "Random"
+	PodSelectionLeastSessions = "LeastSessions"
+	PodSelectionSticky        = "Sticky"
+)
+
 // IngreSshStatus defines the observed state of IngreSsh
 type IngreSshStatus struct {
-	// A list of pointers to currently running jobs.
+	// A list of pointers to the pods with active SSH sessions, one per
+	// session.
 	// +optional
 	Active []corev1.ObjectReference `json:"active,omitempty"`
 
+	// ActiveSessions is the number of active SSH sessions.
+	// +optional
+	ActiveSessions int32 `json:"activeSessions,omitempty"`
+
 	// Information when was the last time the ssh session was opened.
 	// +optional
 	LastlogTime *metav1.Time `json:"lastlogTime,omitempty"`
+
+	// ValidKeys is the number of the authorized keys which could be parsed.
+	// +optional
+	ValidKeys int32 `json:"validKeys,omitempty"`
+
+	// ObservedGeneration is the generation of the resource reflected in the
+	// SSH server configuration.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Conditions of the resource: Ready when the SSH server accepts the
+	// sessions of the resource, KeysValid when all the authorized keys are
+	// valid.
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
 
+// Condition types and reasons of IngreSshStatus
+const (
+	ConditionReady     = "Ready"
+	ConditionKeysValid = "KeysValid"
+
+	ReasonConfigured  = "Configured"
+	ReasonNoValidKeys = "NoValidKeys"
+	ReasonKeysValid   = "KeysValid"
+	ReasonInvalidKeys = "InvalidKeys"
+)
+
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
 //+kubebuilder:resource:path=ingresshes
+//+kubebuilder:printcolumn:name="Mode",type=string,JSONPath=`.spec.session`
+//+kubebuilder:printcolumn:name="Keys",type=integer,JSONPath=`.status.validKeys`
+//+kubebuilder:printcolumn:name="Active",type=integer,JSONPath=`.status.activeSessions`
+//+kubebuilder:printcolumn:name="Last Login",type=date,JSONPath=`.status.lastlogTime`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // IngreSsh is the Schema for the ingresshes API
 type IngreSsh struct {
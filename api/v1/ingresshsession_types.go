@@ -0,0 +1,101 @@
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// LabelServer is the label of IngreSshSession with the name of the SSH
+// server pod serving the session.
+const LabelServer = "ingress.kuberstein.io/server"
+
+// AnnotationSessionPrefix prefixes the name of the debug container in the
+// pod annotation with the ID of the session that created the container.
+const AnnotationSessionPrefix = "ingress.kuberstein.io/session."
+
+// EnvSessionID is the environment variable of the debug container with the
+// ID of the session that created the container.
+const EnvSessionID = "INGRESSH_SESSION_ID"
+
+// IngreSshSessionSpec describes the active SSH session. The session is
+// recorded by the SSH server when the session starts.
+type IngreSshSessionSpec struct {
+	// SessionID is the ID of the SSH session. The targets of a fan-out
+	// command are sessions of their own, with the ID of the connection
+	// suffixed with the index of the target.
+	SessionID string `json:"sessionId"`
+
+	// ConnectionID is the session ID of the SSH connection, which
+	// correlates the targets of a fan-out command with the audit log.
+	// +optional
+	ConnectionID string `json:"connectionId,omitempty"`
+
+	// User is the login name of the authorized key of the user.
+	// +optional
+	User string `json:"user,omitempty"`
+
+	// Fingerprint is the SHA256 fingerprint of the authorized key.
+	Fingerprint string `json:"fingerprint"`
+
+	// SourceIP is the IP address the user connected from.
+	// +optional
+	SourceIP string `json:"sourceIP,omitempty"`
+
+	// Namespace of the target pod.
+	Namespace string `json:"namespace"`
+
+	// Pod is the name of the target pod.
+	Pod string `json:"pod"`
+
+	// Container is the name of the target container.
+	Container string `json:"container"`
+
+	// Mode is the session mode of the IngreSsh resource: Exec or Debug.
+	Mode string `json:"mode"`
+
+	// ReadOnly is true for the view-only sessions.
+	// +optional
+	ReadOnly bool `json:"readOnly,omitempty"`
+
+	// Command is the command requested by the user.
+	// +optional
+	Command []string `json:"command,omitempty"`
+
+	// StartTime is the time the session has started.
+	StartTime metav1.Time `json:"startTime"`
+
+	// DebugContainer is the name of the ephemeral container the session
+	// is attached to in the Debug mode.
+	// +optional
+	DebugContainer string `json:"debugContainer,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:resource:path=ingresshsessions
+//+kubebuilder:printcolumn:name="User",type=string,JSONPath=`.spec.user`
+//+kubebuilder:printcolumn:name="Source",type=string,JSONPath=`.spec.sourceIP`
+//+kubebuilder:printcolumn:name="Pod",type=string,JSONPath=`.spec.pod`
+//+kubebuilder:printcolumn:name="Container",type=string,JSONPath=`.spec.container`
+//+kubebuilder:printcolumn:name="Mode",type=string,JSONPath=`.spec.mode`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.spec.startTime`
+
+// IngreSshSession is an active SSH session opened with the access granted
+// by the owner IngreSsh. Deleting the object terminates the session.
+type IngreSshSession struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec IngreSshSessionSpec `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// IngreSshSessionList contains a list of IngreSshSession
+type IngreSshSessionList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []IngreSshSession `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&IngreSshSession{}, &IngreSshSessionList{})
+}
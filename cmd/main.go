@@ -5,10 +5,10 @@ import (
 	"flag"
 	"fmt"
 	"net"
+	"net/http"
 	"os"
 
 	"github.com/gliderlabs/ssh"
-	gossh "golang.org/x/crypto/ssh"
 	"golang.org/x/sync/errgroup"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
@@ -16,9 +16,11 @@ import (
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
 	"k8s.io/apimachinery/pkg/runtime"
+	k8stypes "k8s.io/apimachinery/pkg/types"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
@@ -26,9 +28,13 @@ import (
 	//+kubebuilder:scaffold:imports
 
 	ing "kuberstein.io/ingressh/api/v1"
+	"kuberstein.io/ingressh/internal/audit"
+	"kuberstein.io/ingressh/internal/config"
 	"kuberstein.io/ingressh/internal/controller"
 	"kuberstein.io/ingressh/internal/k8s"
+	"kuberstein.io/ingressh/internal/recording"
 	"kuberstein.io/ingressh/internal/server"
+	"kuberstein.io/ingressh/internal/tracing"
 	"kuberstein.io/ingressh/internal/types"
 )
 
@@ -48,11 +54,15 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var configFile string
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
-			"Enabling this will ensure there is only one active controller manager.")
+			"Enabling this will ensure only one replica writes the status of the resources.")
+	flag.StringVar(&configFile, "config", os.Getenv("CONFIG_FILE"),
+		"The YAML configuration file of the server, reloaded on change. "+
+			"The environment variables override the values of the file.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -65,6 +75,10 @@ func main() {
 		Scheme:                 scheme,
 		Metrics: metricsserver.Options{
 			BindAddress: metricsAddr,
+			// The bans of the source IPs, see server.AuthGuard
+			ExtraHandlers: map[string]http.Handler{
+				"/bans": &server.Guard,
+			},
 		},
 		HealthProbeBindAddress: probeAddr,
 		LeaderElection:         enableLeaderElection,
@@ -93,6 +107,22 @@ func main() {
 		setupLog.Error(err, "unable to create controller", "controller", "IngreSsh")
 		os.Exit(1)
 	}
+
+	conf, err := types.LoadServerConf(configFile)
+	if err != nil {
+		setupLog.Error(err, "unable to load the configuration")
+		os.Exit(1)
+	}
+
+	if err = (&controller.IngreSshSessionReconciler{
+		Client:          mgr.GetClient(),
+		APIReader:       mgr.GetAPIReader(),
+		ServerName:      conf.ServerName,
+		ServerNamespace: conf.ServerNamespace,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "IngreSshSession")
+		os.Exit(1)
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
@@ -116,8 +146,45 @@ func main() {
 	})
 
 	setupLog.Info("Starting SSH server...")
+	server.Events.SetRecorder(mgr.GetEventRecorderFor("ingressh"))
+	server.SessionObjects.Init(mgr.GetClient(), conf.ServerName)
+
+	// The host key objects are read before the cache of the manager starts,
+	// and the server may not list the secrets, so the client is not cached
+	var hostKeyClient client.Client
+	if conf.HostKeySecret != "" || conf.HostKeyConfigMap != "" {
+		hostKeyClient, err = client.New(ctrl.GetConfigOrDie(), client.Options{Scheme: scheme})
+		if err != nil {
+			setupLog.Error(err, "unable to create the host key client")
+			os.Exit(1)
+		}
+	}
+	if conf.HostKeySecret != "" {
+		signer, err := server.EnsureHostKeySecret(ctx, hostKeyClient,
+			k8stypes.NamespacedName{Namespace: conf.ServerNamespace, Name: conf.HostKeySecret})
+		if err != nil {
+			setupLog.Error(err, "unable to load the generated host key")
+			os.Exit(1)
+		}
+		server.HostKeys.SetGenerated(signer)
+	}
+	if err := server.Config.Apply(conf); err != nil {
+		setupLog.Error(err, "unable to apply the configuration")
+		os.Exit(1)
+	}
+	if conf.HostKeyConfigMap != "" {
+		server.HostKeys.PublishTo(hostKeyClient,
+			k8stypes.NamespacedName{Namespace: conf.ServerNamespace, Name: conf.HostKeyConfigMap})
+	}
 	eg.Go(func() error {
-		return startSshServer(egCtx)
+		return startSshServer(egCtx, conf)
+	})
+	eg.Go(func() error {
+		// The server keeps running with the configuration it has
+		if err := config.Watch(egCtx, configFile, conf, server.Config.Apply); err != nil {
+			setupLog.Error(err, "unable to reload the configuration on change")
+		}
+		return nil
 	})
 
 	if err := eg.Wait(); err != nil {
@@ -125,37 +192,67 @@ func main() {
 	}
 }
 
-func startSshServer(ctx context.Context) error {
-
-	conf := types.GetServerConf()
+func startSshServer(ctx context.Context, conf *types.ServerConfig) error {
 
 	kube := k8s.ClientImpl{}
 	if err := kube.Init(ctrl.GetConfigOrDie()); err != nil {
 		return fmt.Errorf("unable to create K8s client: %v", err)
 	}
 
-	ln, err := net.Listen("tcp", conf.BindAddress)
+	ln, err := net.Listen("tcp", conf.Listener.BindAddress)
+	if err != nil {
+		return fmt.Errorf("unable to listen socket at %s: %v", conf.Listener.BindAddress, err)
+	}
+	if conf.Listener.ProxyProtocol {
+		// The client addresses of the PROXY protocol headers are the ones
+		// filtered, limited and logged downstream.
+		ln, err = server.ProxyListener(ln, conf.Listener.ProxyTrustedCIDRs)
+		if err != nil {
+			return fmt.Errorf("unable to set up PROXY protocol: %v", err)
+		}
+	}
+	ln = server.FilterListener(ln, &server.Sources)
+
+	auditLog, err := audit.NewLogger(conf.Audit)
 	if err != nil {
-		return fmt.Errorf("unable to listen socket at %s: %v", conf.BindAddress, err)
+		return fmt.Errorf("unable to set up audit log: %v", err)
+	}
+	audit.SetLogger(auditLog)
+	if auditLog != nil {
+		defer auditLog.Close()
 	}
 
-	pemBytes, err := os.ReadFile(conf.HostKeyFile)
+	shutdownTracing, err := tracing.Setup(ctx, conf.Tracing)
 	if err != nil {
-		return fmt.Errorf("unable to read host key file %s: %v", conf.HostKeyFile, err)
+		return fmt.Errorf("unable to set up tracing: %v", err)
 	}
+	defer shutdownTracing(context.Background())
 
-	signer, err := gossh.ParsePrivateKey(pemBytes)
+	recordings, err := recording.NewSink(conf.Recording)
 	if err != nil {
-		return fmt.Errorf("unable to parse private key: %v", err)
+		return fmt.Errorf("unable to set up session recording: %v", err)
 	}
 
 	srv := &ssh.Server{
-		PublicKeyHandler: server.PublicKeyAuthHandler,
-		Handler:          server.GetHandler(&kube, conf),
-		HostSigners:      []ssh.Signer{signer},
+		ConnCallback:             server.ConnHandler,
+		PublicKeyHandler:         server.PublicKeyAuthHandler,
+		ConnectionFailedCallback: server.ConnectionFailedHandler,
+		ServerConfigCallback:     server.ServerConfigCallback,
+		Handler:                  server.GetHandler(&kube, recordings),
+		IdleTimeout:              conf.Listener.IdleTimeout.Duration,
+		MaxTimeout:               conf.Listener.MaxTimeout.Duration,
+		ChannelHandlers: map[string]ssh.ChannelHandler{
+			"session":      ssh.DefaultSessionHandler,
+			"direct-tcpip": server.DirectTcpipHandler,
+		},
+		RequestHandlers: map[string]ssh.RequestHandler{
+			"hostkeys-prove-00@openssh.com": server.HostKeysProveHandler,
+		},
 	}
+	// The host keys are loaded with the configuration, and reloaded with it
+	server.HostKeys.Bind(srv)
 
-	setupLog.Info("Starting ssh ingress server", "address", conf.BindAddress)
+	setupLog.Info("Starting ssh ingress server", "address", conf.Listener.BindAddress)
 
 	go srv.Serve(ln)
 	for {
@@ -0,0 +1,154 @@
+// Package audit emits the structured audit log of the SSH server: the
+// authentication, authorization and session events as JSON lines, separate
+// from the diagnostic logs of the server.
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/syslog"
+	"os"
+	"sync"
+	"time"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+
+	"kuberstein.io/ingressh/internal/types"
+)
+
+var serverLog = ctrllog.Log.WithName("audit")
+
+// Types of the audit events
+const (
+	EventAuthSuccess      = "auth.success"
+	EventAuthFailure      = "auth.failure"
+	EventAuthzDenied      = "authz.denied"
+	EventTargetSelected   = "target.selected"
+	EventContainerCreated = "container.created"
+	EventSessionStart     = "session.start"
+	EventSessionEnd       = "session.end"
+	EventChannelForward   = "channel.forward"
+	EventRecordingReplay  = "recording.replay"
+	EventLimitExceeded    = "limit.exceeded"
+	EventSourceBanned     = "source.banned"
+)
+
+// Event is a record of the audit log. Only the fields relevant to the event
+// type are set.
+type Event struct {
+	Time        time.Time `json:"time"`
+	Type        string    `json:"type"`
+	SessionID   string    `json:"sessionId,omitempty"`
+	User        string    `json:"user,omitempty"`
+	Login       string    `json:"login,omitempty"`
+	Fingerprint string    `json:"fingerprint,omitempty"`
+	SourceIP    string    `json:"sourceIp,omitempty"`
+	Route       string    `json:"route,omitempty"`
+	Namespace   string    `json:"namespace,omitempty"`
+	Pod         string    `json:"pod,omitempty"`
+	Container   string    `json:"container,omitempty"`
+	// AccessContainer is the ephemeral container attached to the Container
+	AccessContainer string   `json:"accessContainer,omitempty"`
+	Recording       string   `json:"recording,omitempty"`
+	Mode            string   `json:"mode,omitempty"`
+	ReadOnly        bool     `json:"readOnly,omitempty"`
+	Command         []string `json:"command,omitempty"`
+	Destination     string   `json:"destination,omitempty"`
+	Reason          string   `json:"reason,omitempty"`
+	Duration        float64  `json:"durationSeconds,omitempty"`
+	ExitCode        *int     `json:"exitCode,omitempty"`
+	// TraceID is the OpenTelemetry trace of the connection, if traced
+	TraceID string `json:"traceId,omitempty"`
+}
+
+// Logger writes the audit events as JSON lines.
+type Logger struct {
+	out   io.WriteCloser
+	mutex sync.Mutex
+}
+
+// auditLog is the audit log of the server, stdout until configured.
+var auditLog = &Logger{out: nopCloser{os.Stdout}}
+
+// NewLogger returns the logger writing into the configured sink, or nil if
+// the audit log is disabled.
+func NewLogger(conf types.AuditConfig) (*Logger, error) {
+
+	var out io.WriteCloser
+	switch conf.Sink {
+	case types.AuditSinkNone:
+		return nil, nil
+
+	case "", types.AuditSinkStdout:
+		out = nopCloser{os.Stdout}
+
+	case types.AuditSinkFile:
+		out = &lumberjack.Logger{
+			Filename:   conf.File,
+			MaxSize:    conf.FileMaxSizeMB,
+			MaxBackups: conf.FileMaxBackups,
+			MaxAge:     conf.FileMaxAgeDays,
+		}
+
+	case types.AuditSinkSyslog:
+		w, err := syslog.Dial(conf.SyslogNetwork, conf.SyslogAddress, syslog.LOG_INFO|syslog.LOG_AUTH, "ingressh")
+		if err != nil {
+			return nil, fmt.Errorf("unable to connect to syslog %s %s: %w", conf.SyslogNetwork, conf.SyslogAddress, err)
+		}
+		out = w
+
+	default:
+		return nil, fmt.Errorf("unknown audit sink %s", conf.Sink)
+	}
+
+	return &Logger{out: out}, nil
+}
+
+// SetLogger replaces the audit log of the server. A nil logger disables
+// the audit log.
+func SetLogger(l *Logger) {
+	auditLog = l
+}
+
+// Log writes the event into the audit log of the server.
+func Log(e Event) {
+	if auditLog != nil {
+		auditLog.Log(e)
+	}
+}
+
+// Log writes the event into the audit log. Audit failures never break the
+// sessions, they are reported in the server log.
+func (l *Logger) Log(e Event) {
+
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		serverLog.Error(err, "Unable to encode audit event", "type", e.Type)
+		return
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if _, err := fmt.Fprintf(l.out, "%s\n", line); err != nil {
+		serverLog.Error(err, "Unable to write audit event", "type", e.Type)
+	}
+}
+
+// Close closes the sink of the audit log.
+func (l *Logger) Close() error {
+	return l.out.Close()
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
@@ -0,0 +1,67 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+type bufferCloser struct {
+	bytes.Buffer
+}
+
+func (b *bufferCloser) Close() error {
+	return nil
+}
+
+func TestLogger(t *testing.T) {
+
+	out := &bufferCloser{}
+	l := &Logger{out: out}
+
+	exitCode := 0
+	l.Log(Event{Type: EventAuthFailure, Login: "alice", SourceIP: "10.0.0.1", Reason: "unknown key"})
+	l.Log(Event{Type: EventSessionEnd, SessionID: "abc", Duration: 1.5, ExitCode: &exitCode})
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %q", lines)
+	}
+
+	tests := []struct {
+		line     string
+		expected map[string]interface{}
+		absent   []string
+	}{
+		{
+			line:     lines[0],
+			expected: map[string]interface{}{"type": "auth.failure", "login": "alice", "sourceIp": "10.0.0.1", "reason": "unknown key"},
+			absent:   []string{"exitCode", "sessionId", "fingerprint"},
+		},
+		{
+			line:     lines[1],
+			expected: map[string]interface{}{"type": "session.end", "sessionId": "abc", "durationSeconds": 1.5, "exitCode": 0.0},
+			absent:   []string{"reason", "login"},
+		},
+	}
+	for _, tt := range tests {
+		fields := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(tt.line), &fields); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := fields["time"]; !ok {
+			t.Errorf("expected time in %s", tt.line)
+		}
+		for k, v := range tt.expected {
+			if fields[k] != v {
+				t.Errorf("expected %s=%v in %s", k, v, tt.line)
+			}
+		}
+		for _, k := range tt.absent {
+			if _, ok := fields[k]; ok {
+				t.Errorf("unexpected %s in %s", k, tt.line)
+			}
+		}
+	}
+}
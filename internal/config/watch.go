@@ -0,0 +1,107 @@
+package config
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+
+	"kuberstein.io/ingressh/internal/types"
+)
+
+// reloadDelay batches the events of the file change, f.e. the several
+// renames of the ConfigMap volume update.
+const reloadDelay = time.Second
+
+// Watch reloads the configuration when the configuration file, if the path
+// is not empty, or the files it refers to change, until ctx is done, and
+// passes the new configuration to apply. So the host keys are rotated by
+// updating their Secret. The invalid configuration is logged and ignored,
+// so the server keeps the last valid one.
+//
+// The directories of the files are watched rather than the files, as the
+// ConfigMap and Secret volumes replace the files by swapping the symbolic
+// link of the directory with the data.
+func Watch(ctx context.Context, path string, conf *types.ServerConfig, apply func(*types.ServerConfig) error) error {
+
+	log := ctrllog.FromContext(ctx).WithName("config")
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("unable to watch the configuration files: %w", err)
+	}
+	defer watcher.Close()
+
+	files := func(conf *types.ServerConfig) []string {
+		if path == "" {
+			return conf.Files()
+		}
+		return append([]string{path}, conf.Files()...)
+	}
+	watch := func(files []string) {
+		for _, file := range files {
+			if err := watcher.Add(filepath.Dir(file)); err != nil {
+				log.Error(err, "Unable to watch the configuration file", "file", file)
+			}
+		}
+	}
+	watch(files(conf))
+	// The digest of the files loaded last, so the events not changing them
+	// are ignored
+	loaded := digest(files(conf))
+
+	reload := time.NewTimer(reloadDelay)
+	reload.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			log.Error(err, "Unable to watch the configuration files")
+		case _, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			reload.Reset(reloadDelay)
+		case <-reload.C:
+			if digest(files(conf)) == loaded {
+				continue
+			}
+			next, err := types.LoadServerConf(path)
+			if err == nil {
+				err = apply(next)
+			}
+			// The files are not reloaded until they change again
+			loaded = digest(files(conf))
+			if err != nil {
+				log.Error(err, "Unable to reload the configuration, keeping the current configuration")
+				continue
+			}
+			conf = next
+			watch(files(conf))
+			loaded = digest(files(conf))
+			log.Info("Configuration is reloaded")
+		}
+	}
+}
+
+// digest returns the digest of the paths and the contents of the files. The
+// missing files are digested as empty.
+func digest(files []string) [sha256.Size]byte {
+	h := sha256.New()
+	for _, file := range files {
+		data, _ := os.ReadFile(file)
+		fmt.Fprintf(h, "%s\x00%d\x00", file, len(data))
+		h.Write(data)
+	}
+	return [sha256.Size]byte(h.Sum(nil))
+}
@@ -0,0 +1,78 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"kuberstein.io/ingressh/internal/types"
+)
+
+func writeFile(t *testing.T, path string, content string) {
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWatch(t *testing.T) {
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	key := filepath.Join(t.TempDir(), "ssh-privatekey")
+	writeFile(t, path, "limits:\n  sessions: 1\n")
+	writeFile(t, key, "key")
+	t.Setenv("HOST_KEY_FILE", key)
+
+	conf, err := types.LoadServerConf(path)
+	if err != nil {
+		t.Fatalf("LoadServerConf() error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	applied := make(chan *types.ServerConfig, 1)
+	done := make(chan error)
+	go func() {
+		done <- Watch(ctx, path, conf, func(conf *types.ServerConfig) error {
+			applied <- conf
+			return nil
+		})
+	}()
+	// Let the watcher start before the changes
+	time.Sleep(100 * time.Millisecond)
+
+	expectApplied := func(what string) *types.ServerConfig {
+		select {
+		case conf := <-applied:
+			return conf
+		case <-time.After(5 * reloadDelay):
+			t.Fatalf("expected the configuration to be reloaded on the change of the %s", what)
+			return nil
+		}
+	}
+
+	// The invalid configuration is not applied
+	writeFile(t, path, "limits:\n  sessions: -1\n")
+	select {
+	case conf := <-applied:
+		t.Fatalf("expected the invalid configuration to be ignored, got %+v", conf.Limits)
+	case <-time.After(2 * reloadDelay):
+	}
+
+	writeFile(t, path, "limits:\n  sessions: 5\n")
+	if conf := expectApplied("configuration file"); conf.Limits.Sessions != 5 {
+		t.Errorf("expected the reloaded limit 5, got %d", conf.Limits.Sessions)
+	}
+
+	// The host keys are reloaded when they are rotated
+	writeFile(t, key, "rotated key")
+	expectApplied("host key")
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Watch() error = %v", err)
+	}
+}
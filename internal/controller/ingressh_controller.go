@@ -4,11 +4,16 @@ import (
 	"context"
 	"fmt"
 
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/runtime"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	ing "kuberstein.io/ingressh/api/v1"
 	"kuberstein.io/ingressh/internal/server"
@@ -19,6 +24,10 @@ import (
 type IngreSshReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
+
+	// elected is closed when the replica becomes the leader, the only one
+	// writing the status. Without the manager the status is always written.
+	elected <-chan struct{}
 }
 
 // finalizerName is the name of the custom finalizer to handle the resource deletion
@@ -27,6 +36,7 @@ const finalizerName = "ingressh.ingress.kuberstein.io/finalizer"
 //+kubebuilder:rbac:groups=ingress.kuberstein.io,resources=ingresshes,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=ingress.kuberstein.io,resources=ingresshes/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=ingress.kuberstein.io,resources=ingresshes/finalizers,verbs=update
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -41,7 +51,7 @@ const finalizerName = "ingressh.ingress.kuberstein.io/finalizer"
 // The job is to:
 // - Load the named IngreSsh
 // - Configure the SSH server accordingly
-// - TODO Get list of active sessions and update the status
+// - Reflect the active sessions and the validity of the keys in the status
 func (r *IngreSshReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	log := log.FromContext(ctx)
 
@@ -58,7 +68,9 @@ func (r *IngreSshReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 	// Init ssh configuration object from the ingress object
 	sshConfig := &types.SshConfig{
 		IngreSshSpec: *&ingreSsh.Spec,
+		Name:         req.Name,
 		Namespace:    req.Namespace,
+		UID:          ingreSsh.UID,
 	}
 
 	// examine DeletionTimestamp to determine if object is under deletion
@@ -97,14 +109,64 @@ func (r *IngreSshReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 
 	server.Routes.Set(sshConfig)
 
-	// TODO Update status?
+	// Every replica configures its own SSH server, but the status is the
+	// same for all of them, so only the leader writes it
+	if !r.isLeader() {
+		return ctrl.Result{}, nil
+	}
+
+	sessions := &ing.IngreSshSessionList{}
+	if err := r.List(ctx, sessions, client.InNamespace(req.Namespace)); err != nil {
+		return ctrl.Result{}, err
+	}
+	status := computeStatus(ingreSsh, ownedSessions(ingreSsh, sessions.Items), server.Sessions.LastLogin(sshConfig.Route()))
+	if !equality.Semantic.DeepEqual(status, ingreSsh.Status) {
+		ingreSsh.Status = status
+		if err := r.Status().Update(ctx, ingreSsh); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
 
 	return ctrl.Result{}, nil
 }
 
+// isLeader returns true if the replica is elected to write the status.
+func (r *IngreSshReconciler) isLeader() bool {
+	if r.elected == nil {
+		return true
+	}
+	select {
+	case <-r.elected:
+		return true
+	default:
+		return false
+	}
+}
+
 // SetupWithManager sets up the controller with the Manager.
+// The status of the resources is updated as well when their sessions start
+// or end, on this replica or on the others.
+//
+// The controller runs on every replica, as it configures the SSH server of
+// the replica, while the status is written by the leader only. The newly
+// elected leader updates the status of all the resources.
 func (r *IngreSshReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	notifier := newSessionNotifier()
+	server.Sessions.OnChange(notifier.Notify)
+
+	r.elected = mgr.Elected()
+	err := mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+		return notifier.NotifyAll(ctx, mgr.GetClient())
+	}))
+	if err != nil {
+		return err
+	}
+
+	needLeaderElection := false
 	return ctrl.NewControllerManagedBy(mgr).
+		WithOptions(controller.Options{NeedLeaderElection: &needLeaderElection}).
 		For(&ing.IngreSsh{}).
+		Watches(&ing.IngreSshSession{}, handler.EnqueueRequestsFromMapFunc(notifier.NotifySession)).
+		WatchesRawSource(source.Channel(notifier.events, &handler.EnqueueRequestForObject{})).
 		Complete(r)
 }
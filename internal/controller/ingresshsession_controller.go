@@ -0,0 +1,122 @@
+package controller
+
+import (
+	"context"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	ing "kuberstein.io/ingressh/api/v1"
+	"kuberstein.io/ingressh/internal/server"
+)
+
+// staleCheckInterval is the period to check whether the server of the
+// session resource of another replica is still running.
+const staleCheckInterval = 5 * time.Minute
+
+// IngreSshSessionReconciler terminates the sessions whose IngreSshSession
+// resources are deleted, and deletes the stale resources left behind by
+// the restarted servers.
+type IngreSshSessionReconciler struct {
+	client.Client
+	// APIReader reads the server pods bypassing the cache, as the pods
+	// are not watched.
+	APIReader client.Reader
+	// ServerName and ServerNamespace identify the pod of this server.
+	ServerName      string
+	ServerNamespace string
+}
+
+//+kubebuilder:rbac:groups=ingress.kuberstein.io,resources=ingresshsessions,verbs=get;list;watch;create;patch;delete
+//+kubebuilder:rbac:groups="",resources=pods,verbs=get
+
+// The job is to:
+// - Terminate the session when its resource is deleted
+// - Delete the resources of the sessions which are not active anymore
+func (r *IngreSshSessionReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	session := &ing.IngreSshSession{}
+	if err := r.Get(ctx, req.NamespacedName, session); err != nil {
+		if apierrors.IsNotFound(err) {
+			if server.Sessions.Terminate(req.NamespacedName) {
+				log.Info("session resource deleted, terminate the session")
+			}
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	if !session.DeletionTimestamp.IsZero() {
+		if server.Sessions.Terminate(req.NamespacedName) {
+			log.Info("session resource is being deleted, terminate the session")
+		}
+		return ctrl.Result{}, nil
+	}
+
+	owner := session.Labels[ing.LabelServer]
+	if owner == "" {
+		return ctrl.Result{}, nil
+	}
+
+	if owner == r.ServerName {
+		if server.Sessions.HasObject(req.NamespacedName) {
+			return ctrl.Result{}, nil
+		}
+		log.Info("delete stale session resource of this server")
+		return ctrl.Result{}, r.deleteStale(ctx, session)
+	}
+
+	// The session belongs to another replica, which may be gone
+	running, err := r.serverRunning(ctx, owner)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if !running {
+		log.Info("delete stale session resource of the gone server", "server", owner)
+		return ctrl.Result{}, r.deleteStale(ctx, session)
+	}
+
+	return ctrl.Result{RequeueAfter: staleCheckInterval}, nil
+}
+
+// serverRunning returns true if the server pod with the name exists.
+func (r *IngreSshSessionReconciler) serverRunning(ctx context.Context, name string) (bool, error) {
+
+	if r.ServerNamespace == "" {
+		// The server is not running in the cluster, so the other servers
+		// could not be checked.
+		return true, nil
+	}
+
+	pod := &corev1.Pod{}
+	err := r.APIReader.Get(ctx, k8stypes.NamespacedName{Namespace: r.ServerNamespace, Name: name}, pod)
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return pod.DeletionTimestamp.IsZero(), nil
+}
+
+// deleteStale deletes the session resource unless it is gone already.
+func (r *IngreSshSessionReconciler) deleteStale(ctx context.Context, session *ing.IngreSshSession) error {
+	return client.IgnoreNotFound(r.Delete(ctx, session))
+}
+
+// SetupWithManager sets up the controller with the Manager. The controller
+// runs on every replica, which terminates its own sessions.
+func (r *IngreSshSessionReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	needLeaderElection := false
+	return ctrl.NewControllerManagedBy(mgr).
+		WithOptions(controller.Options{NeedLeaderElection: &needLeaderElection}).
+		For(&ing.IngreSshSession{}).
+		Complete(r)
+}
@@ -0,0 +1,206 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	ing "kuberstein.io/ingressh/api/v1"
+	"kuberstein.io/ingressh/internal/types"
+)
+
+// statusDebounce delays the status update after a session change, so a
+// burst of sessions results in a single update.
+const statusDebounce = 2 * time.Second
+
+// sessionNotifier turns the session changes of the routes into reconcile
+// requests of the IngreSsh resources, debounced per resource.
+type sessionNotifier struct {
+	events   chan event.GenericEvent
+	pending  map[string]bool
+	debounce time.Duration
+	mutex    sync.Mutex
+}
+
+func newSessionNotifier() *sessionNotifier {
+	return &sessionNotifier{
+		events:   make(chan event.GenericEvent, 100),
+		pending:  make(map[string]bool),
+		debounce: statusDebounce,
+	}
+}
+
+// Notify schedules the reconciliation of the IngreSsh resource of the route,
+// unless it is scheduled already.
+func (n *sessionNotifier) Notify(config *types.SshConfig) {
+	n.notify(config.Namespace, config.Name)
+}
+
+// NotifySession schedules the reconciliation of the IngreSsh resources
+// owning the session resource, so the status reflects the sessions of the
+// other replicas as well.
+func (n *sessionNotifier) NotifySession(ctx context.Context, obj client.Object) []reconcile.Request {
+	for _, owner := range obj.GetOwnerReferences() {
+		if owner.Kind == "IngreSsh" && owner.APIVersion == ing.GroupVersion.String() {
+			n.notify(obj.GetNamespace(), owner.Name)
+		}
+	}
+	return nil
+}
+
+// NotifyAll schedules the reconciliation of all the IngreSsh resources, so
+// the status written by the previous leader is brought up to date.
+func (n *sessionNotifier) NotifyAll(ctx context.Context, c client.Reader) error {
+	list := &ing.IngreSshList{}
+	if err := c.List(ctx, list); err != nil {
+		return err
+	}
+	for _, obj := range list.Items {
+		n.notify(obj.Namespace, obj.Name)
+	}
+	return nil
+}
+
+func (n *sessionNotifier) notify(namespace string, name string) {
+
+	route := namespace + "/" + name
+	obj := &ing.IngreSsh{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}}
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if n.pending[route] {
+		return
+	}
+	n.pending[route] = true
+
+	time.AfterFunc(n.debounce, func() {
+		n.mutex.Lock()
+		delete(n.pending, route)
+		n.mutex.Unlock()
+
+		// The timer never waits for the controller. The queue is full of
+		// the pending requests then, and the status is brought up to date
+		// by the next change of the resource or the periodic resync.
+		select {
+		case n.events <- event.GenericEvent{Object: obj}:
+		default:
+		}
+	})
+}
+
+// ownedSessions returns the active sessions of the resource out of the
+// session resources of its namespace, ordered by the start time. The session
+// resources are created by every replica of the server, so the status written
+// by the leader covers the sessions of all the replicas.
+func ownedSessions(ingreSsh *ing.IngreSsh, sessions []ing.IngreSshSession) []ing.IngreSshSession {
+
+	owned := []ing.IngreSshSession{}
+	for _, s := range sessions {
+		if !s.DeletionTimestamp.IsZero() {
+			continue
+		}
+		for _, owner := range s.OwnerReferences {
+			if owner.UID == ingreSsh.UID {
+				owned = append(owned, s)
+				break
+			}
+		}
+	}
+	sort.Slice(owned, func(i, j int) bool {
+		if !owned[i].Spec.StartTime.Equal(&owned[j].Spec.StartTime) {
+			return owned[i].Spec.StartTime.Before(&owned[j].Spec.StartTime)
+		}
+		return owned[i].Name < owned[j].Name
+	})
+	return owned
+}
+
+// computeStatus returns the observed state of the resource: the validity of
+// the authorized keys and the active sessions of the route, found from the
+// session resources of all the replicas. The last login is the latest of the
+// sessions and the one of the sessions of this replica, which may have ended.
+func computeStatus(ingreSsh *ing.IngreSsh, sessions []ing.IngreSshSession, lastLogin time.Time) ing.IngreSshStatus {
+
+	status := *ingreSsh.Status.DeepCopy()
+	status.ObservedGeneration = ingreSsh.Generation
+
+	// Keys
+	invalid := []string{}
+	status.ValidKeys = 0
+	for i, key := range ingreSsh.Spec.AuthorizedKeys {
+		if _, _, _, _, err := gossh.ParseAuthorizedKey([]byte(key.Key)); err != nil {
+			name := key.User
+			if name == "" {
+				name = fmt.Sprintf("#%d", i+1)
+			}
+			invalid = append(invalid, name)
+			continue
+		}
+		status.ValidKeys++
+	}
+
+	keysValid := metav1.Condition{
+		Type:               ing.ConditionKeysValid,
+		Status:             metav1.ConditionTrue,
+		Reason:             ing.ReasonKeysValid,
+		Message:            "All the authorized keys are valid",
+		ObservedGeneration: ingreSsh.Generation,
+	}
+	if len(invalid) > 0 {
+		keysValid.Status = metav1.ConditionFalse
+		keysValid.Reason = ing.ReasonInvalidKeys
+		keysValid.Message = "Invalid authorized keys of the users: " + strings.Join(invalid, ", ")
+	}
+	meta.SetStatusCondition(&status.Conditions, keysValid)
+
+	ready := metav1.Condition{
+		Type:               ing.ConditionReady,
+		Status:             metav1.ConditionTrue,
+		Reason:             ing.ReasonConfigured,
+		Message:            "The SSH server accepts the sessions",
+		ObservedGeneration: ingreSsh.Generation,
+	}
+	if status.ValidKeys == 0 {
+		ready.Status = metav1.ConditionFalse
+		ready.Reason = ing.ReasonNoValidKeys
+		ready.Message = "No valid authorized keys to accept the sessions"
+	}
+	meta.SetStatusCondition(&status.Conditions, ready)
+
+	// Sessions
+	status.Active = nil
+	for _, s := range sessions {
+		status.Active = append(status.Active, corev1.ObjectReference{
+			APIVersion: "v1",
+			Kind:       "Pod",
+			Namespace:  s.Spec.Namespace,
+			Name:       s.Spec.Pod,
+			FieldPath:  fmt.Sprintf("spec.containers{%s}", s.Spec.Container),
+		})
+		if s.Spec.StartTime.After(lastLogin) {
+			lastLogin = s.Spec.StartTime.Time
+		}
+	}
+	status.ActiveSessions = int32(len(sessions))
+
+	// The status keeps the time with the second precision
+	lastLogin = lastLogin.Truncate(time.Second)
+	if !lastLogin.IsZero() && (status.LastlogTime == nil || status.LastlogTime.Time.Before(lastLogin)) {
+		t := metav1.NewTime(lastLogin)
+		status.LastlogTime = &t
+	}
+
+	return status
+}
@@ -0,0 +1,190 @@
+package controller
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"strings"
+	"testing"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	ing "kuberstein.io/ingressh/api/v1"
+	"kuberstein.io/ingressh/internal/server"
+	"kuberstein.io/ingressh/internal/types"
+)
+
+func testAuthorizedKey(t *testing.T) string {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := gossh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(gossh.MarshalAuthorizedKey(key))
+}
+
+func TestComputeStatus(t *testing.T) {
+
+	ingreSsh := &ing.IngreSsh{
+		ObjectMeta: metav1.ObjectMeta{Name: "route", Namespace: "ns", Generation: 3},
+		Spec: ing.IngreSshSpec{
+			AuthorizedKeys: []ing.AuthorizedKey{
+				{User: "alice", Key: testAuthorizedKey(t)},
+				{User: "bob", Key: "ssh-ed25519 garbage"},
+			},
+		},
+	}
+	started := time.Date(2024, 5, 1, 10, 0, 0, 500, time.UTC)
+	// The session of another replica
+	sessions := []ing.IngreSshSession{{
+		Spec: ing.IngreSshSessionSpec{
+			User:      "alice",
+			Namespace: "ns",
+			Pod:       "pod",
+			Container: "app",
+			StartTime: metav1.NewTime(started),
+		},
+	}}
+
+	status := computeStatus(ingreSsh, sessions, time.Time{})
+
+	if status.ValidKeys != 1 || status.ActiveSessions != 1 || status.ObservedGeneration != 3 {
+		t.Errorf("unexpected status %+v", status)
+	}
+	if len(status.Active) != 1 || status.Active[0].Name != "pod" || status.Active[0].FieldPath != "spec.containers{app}" {
+		t.Errorf("unexpected active sessions %+v", status.Active)
+	}
+	if status.LastlogTime == nil || !status.LastlogTime.Time.Equal(started.Truncate(time.Second)) {
+		t.Errorf("unexpected last login %v", status.LastlogTime)
+	}
+	if !meta.IsStatusConditionTrue(status.Conditions, ing.ConditionReady) {
+		t.Errorf("expected Ready condition")
+	}
+	keysValid := meta.FindStatusCondition(status.Conditions, ing.ConditionKeysValid)
+	if keysValid == nil || keysValid.Status != metav1.ConditionFalse || keysValid.Message != "Invalid authorized keys of the users: bob" {
+		t.Errorf("unexpected KeysValid condition %+v", keysValid)
+	}
+
+	// Sessions have ended, the last login stays
+	ingreSsh.Status = status
+	status = computeStatus(ingreSsh, nil, time.Time{})
+	if status.ActiveSessions != 0 || len(status.Active) != 0 || status.LastlogTime == nil {
+		t.Errorf("unexpected status after sessions %+v", status)
+	}
+}
+
+func TestOwnedSessions(t *testing.T) {
+
+	ingreSsh := &ing.IngreSsh{ObjectMeta: metav1.ObjectMeta{Name: "route", Namespace: "ns", UID: "route-uid"}}
+	session := func(name string, owner k8stypes.UID, started int, deleted bool) ing.IngreSshSession {
+		s := ing.IngreSshSession{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            name,
+				Namespace:       "ns",
+				OwnerReferences: []metav1.OwnerReference{{Kind: "IngreSsh", Name: "route", UID: owner}},
+			},
+			Spec: ing.IngreSshSessionSpec{StartTime: metav1.NewTime(time.Unix(int64(started), 0))},
+		}
+		if deleted {
+			now := metav1.Now()
+			s.DeletionTimestamp = &now
+		}
+		return s
+	}
+
+	owned := ownedSessions(ingreSsh, []ing.IngreSshSession{
+		session("late", "route-uid", 20, false),
+		session("other", "other-uid", 5, false),
+		session("b-early", "route-uid", 10, false),
+		session("a-early", "route-uid", 10, false),
+		session("deleted", "route-uid", 1, true),
+	})
+	names := []string{}
+	for _, s := range owned {
+		names = append(names, s.Name)
+	}
+	if strings.Join(names, ",") != "a-early,b-early,late" {
+		t.Errorf("unexpected sessions %q", names)
+	}
+}
+
+func TestSessionNotifierFull(t *testing.T) {
+
+	n := newSessionNotifier()
+	n.debounce = time.Millisecond
+	n.events = make(chan event.GenericEvent, 1)
+	n.events <- event.GenericEvent{}
+
+	// The request is dropped when the queue is full, the timer doesn't wait
+	n.notify("ns", "route")
+	time.Sleep(50 * time.Millisecond)
+	<-n.events
+	select {
+	case e := <-n.events:
+		t.Errorf("expected the request to be dropped, got %v", e.Object)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// The route is notified again on the next change
+	n.notify("ns", "route")
+	select {
+	case e := <-n.events:
+		if e.Object.GetName() != "route" {
+			t.Errorf("unexpected request %v", e.Object)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("expected the request of the route")
+	}
+}
+
+func TestReconcileStatusLeader(t *testing.T) {
+
+	scheme := runtime.NewScheme()
+	if err := ing.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	ingreSsh := &ing.IngreSsh{
+		ObjectMeta: metav1.ObjectMeta{Name: "route", Namespace: "ns", Finalizers: []string{finalizerName}},
+		Spec:       ing.IngreSshSpec{AuthorizedKeys: []ing.AuthorizedKey{{User: "alice", Key: testAuthorizedKey(t)}}},
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(ingreSsh).WithStatusSubresource(ingreSsh).Build()
+	t.Cleanup(func() { server.Routes.Delete(&types.SshConfig{Name: "route", Namespace: "ns"}) })
+
+	elected := make(chan struct{})
+	r := &IngreSshReconciler{Client: c, Scheme: scheme, elected: elected}
+	req := ctrl.Request{NamespacedName: k8stypes.NamespacedName{Name: "route", Namespace: "ns"}}
+	status := func() ing.IngreSshStatus {
+		obj := &ing.IngreSsh{}
+		if err := c.Get(context.Background(), req.NamespacedName, obj); err != nil {
+			t.Fatal(err)
+		}
+		return obj.Status
+	}
+
+	// The replica which is not the leader leaves the status alone
+	if _, err := r.Reconcile(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	if s := status(); s.ValidKeys != 0 || len(s.Conditions) != 0 {
+		t.Errorf("expected the status not to be written, got %+v", s)
+	}
+
+	close(elected)
+	if _, err := r.Reconcile(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	if s := status(); s.ValidKeys != 1 || !meta.IsStatusConditionTrue(s.Conditions, ing.ConditionReady) {
+		t.Errorf("expected the status written by the leader, got %+v", s)
+	}
+}
@@ -2,18 +2,30 @@ package k8s
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gliderlabs/ssh"
-	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	corev1 "k8s.io/api/core/v1"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/tools/remotecommand"
+	utilexec "k8s.io/client-go/util/exec"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+
+	ing "kuberstein.io/ingressh/api/v1"
+	"kuberstein.io/ingressh/internal/metrics"
+	"kuberstein.io/ingressh/internal/tracing"
 	"kuberstein.io/ingressh/internal/types"
 )
 
@@ -21,26 +33,40 @@ import (
 // targetContainer Linux namespace.
 //
 // After the attachment it waits the container to be in Running state, then
-// returns an updated pod and newly added container name.
+// returns an updated pod, the container name and whether the container has
+// been created.
 //
 // If the container is already attached and running - do nothing.
 // If the container is already attached but completed - attaches a new one
+//
+// The new container gets the sessionID in the environment, and the pod is
+// annotated with the session that created the container.
 func AttachAccessContainer(
+	ctx context.Context,
 	kube *ClientImpl,
 	pod *v1.Pod,
 	targetContainer string,
 	config *types.SshConfig,
-) (*v1.Pod, string, error) {
+	sessionID string,
+) (_ *v1.Pod, _ string, _ bool, err error) {
 
 	const attachNameTmpl = "ssh-access-"
 
+	ctx, span := tracing.Tracer().Start(ctx, "k8s.attach_access_container", trace.WithAttributes(
+		attribute.String("k8s.namespace.name", pod.Namespace),
+		attribute.String("k8s.pod.name", pod.Name),
+		attribute.String("k8s.container.name", targetContainer),
+	))
+	defer func() { tracing.EndSpan(span, err) }()
+	logger := ctrllog.FromContext(ctx)
+
 	// Check if there is already debug container in a good state which could be reused.
 
 	// Sanity check as I'm not sure if it works this way but rely on this in the
 	// reasoning of containers' status.
 	if len(pod.Status.EphemeralContainerStatuses) != len(pod.Spec.EphemeralContainers) {
-		log.Errorf("Can't detect ephemeral containers status: status and spec slices are different")
-		return nil, "", fmt.Errorf("failed to detect container status")
+		logger.Error(nil, "Can't detect ephemeral containers status: status and spec slices are different")
+		return nil, "", false, fmt.Errorf("failed to detect container status")
 	}
 
 	// Find a running access container and the names used.
@@ -54,7 +80,7 @@ func AttachAccessContainer(
 		// Save the used name index for the future reference
 		usedIndex, err := strconv.Atoi(container.Name[len(attachNameTmpl):])
 		if err != nil {
-			log.Warnf("Skip attach container name without proper numeric index: %s", container.Name)
+			logger.Info("Skip attach container name without proper numeric index", "container", container.Name)
 		} else {
 			usedIndexValues = append(usedIndexValues, usedIndex)
 		}
@@ -65,7 +91,8 @@ func AttachAccessContainer(
 
 		status := pod.Status.EphemeralContainerStatuses[i]
 		if status.State.Running != nil {
-			return pod, container.Name, nil
+			span.SetAttributes(attribute.Bool("ingressh.container.reused", true))
+			return pod, container.Name, false, nil
 		}
 	}
 
@@ -82,21 +109,43 @@ func AttachAccessContainer(
 	// Ephemeral container always starts with the command from the
 	// configuration spec, not from the user's input.
 	command := config.Command
-	ephemeralContainer := getEphemeralContainerSpec(command, config, containerName, targetContainer)
+	ephemeralContainer := getEphemeralContainerSpec(command, config, containerName, targetContainer, sessionID)
 
 	pod.Spec.EphemeralContainers = append(pod.Spec.EphemeralContainers, *ephemeralContainer)
-	pod, err := kube.V1().Pods(pod.Namespace).UpdateEphemeralContainers(kube.ctx, pod.Name, pod, metav1.UpdateOptions{})
+	pod, err = kube.V1().Pods(pod.Namespace).UpdateEphemeralContainers(ctx, pod.Name, pod, metav1.UpdateOptions{})
 	if err != nil {
-		return nil, "", fmt.Errorf("could not add ephemeral container: %w", err)
+		return nil, "", false, fmt.Errorf("could not add ephemeral container: %w", err)
 	}
 
+	annotateSession(ctx, kube, pod, containerName, sessionID)
+
 	// Wait for the container to be in the running state
-	err = waitAccessContainer(kube, pod, containerName)
+	err = waitAccessContainer(ctx, kube, pod, containerName)
 	if err != nil {
-		return nil, "", err
+		return nil, "", false, err
 	}
 
-	return pod, containerName, nil
+	return pod, containerName, true, nil
+}
+
+// annotateSession annotates the pod with the ID of the session that created
+// the debug container, so the container could be traced back to the session.
+// Failures are only logged, the annotation is informational.
+func annotateSession(ctx context.Context, kube *ClientImpl, pod *v1.Pod, containerName string, sessionID string) {
+
+	patch, err := json.Marshal(map[string]any{
+		"metadata": map[string]any{
+			"annotations": map[string]string{
+				ing.AnnotationSessionPrefix + containerName: sessionID,
+			},
+		},
+	})
+	if err == nil {
+		_, err = kube.V1().Pods(pod.Namespace).Patch(ctx, pod.Name, k8stypes.MergePatchType, patch, metav1.PatchOptions{})
+	}
+	if err != nil {
+		ctrllog.FromContext(ctx).Error(err, "Unable to annotate the pod with the session", "pod", pod.Name, "container", containerName)
+	}
 }
 
 // getEphemeralContainerSpec prepares resource spec for the new ephemeral
@@ -108,6 +157,7 @@ func getEphemeralContainerSpec(
 	config *types.SshConfig,
 	containerName string,
 	targetContainer string,
+	sessionID string,
 ) *corev1.EphemeralContainer {
 
 	args := config.Args
@@ -130,6 +180,9 @@ func getEphemeralContainerSpec(
 			Command:    command,
 			Args:       args,
 			WorkingDir: workdir,
+			Env: []corev1.EnvVar{
+				{Name: ing.EnvSessionID, Value: sessionID},
+			},
 		},
 		TargetContainerName: targetContainer,
 	}
@@ -140,15 +193,28 @@ func getEphemeralContainerSpec(
 // waitAccessContainer waits until the ephemeral container containerName
 // is in the Running state.
 // Returns error if container is terminated or could not be found.
-func waitAccessContainer(kube *ClientImpl, pod *v1.Pod, containerName string) error {
+func waitAccessContainer(ctx context.Context, kube *ClientImpl, pod *v1.Pod, containerName string) (err error) {
+
+	start := time.Now()
+	ctx, span := tracing.Tracer().Start(ctx, "k8s.wait_access_container",
+		trace.WithAttributes(attribute.String("k8s.container.name", containerName)))
+	defer func() {
+		result := "running"
+		if err != nil {
+			result = "failed"
+		}
+		metrics.DebugContainerStartup.WithLabelValues(result).Observe(time.Since(start).Seconds())
+		tracing.EndSpan(span, err)
+	}()
 
-	watcher, err := kube.V1().Pods(pod.Namespace).Watch(kube.ctx, metav1.SingleObject(pod.ObjectMeta))
+	watcher, err := kube.V1().Pods(pod.Namespace).Watch(ctx, metav1.SingleObject(pod.ObjectMeta))
 	if err != nil {
 		return err
 	}
 	defer watcher.Stop()
 
-	log.Infof("Watching the pod %s to wait attach container %s ready...", pod.Name, containerName)
+	logger := ctrllog.FromContext(ctx)
+	logger.Info("Watching the pod to wait attach container ready...", "pod", pod.Name, "container", containerName)
 
 	for event := range watcher.ResultChan() {
 		switch event.Type {
@@ -157,7 +223,7 @@ func waitAccessContainer(kube *ClientImpl, pod *v1.Pod, containerName string) er
 
 			containerFound := false
 			for i, containerStatus := range pod.Status.EphemeralContainerStatuses {
-				log.Debugf("Iterating over ephemeral container %s", pod.Spec.EphemeralContainers[i].Name)
+				logger.V(1).Info("Iterating over ephemeral container", "container", pod.Spec.EphemeralContainers[i].Name)
 				if containerName != pod.Spec.EphemeralContainers[i].Name {
 					continue
 				}
@@ -190,7 +256,21 @@ func waitAccessContainer(kube *ClientImpl, pod *v1.Pod, containerName string) er
 //     all session streams should work. If the user specified the command
 //     on the command line - then no terminal set. This means Exec mode resource
 //     have to specify the command. What about Debug mode resource?
-func ExecInContainer(kube *ClientImpl, pod *v1.Pod, containerName string, sess ssh.Session, command []string) error {
+//
+// In the readOnly mode the command is executed without stdin, the user only
+// watches its output.
+//
+// Cancelling ctx terminates the session, as well as the timeouts do.
+func ExecInContainer(
+	ctx context.Context,
+	kube *ClientImpl,
+	pod *v1.Pod,
+	containerName string,
+	sess ssh.Session,
+	command []string,
+	readOnly bool,
+	timeouts types.SessionTimeouts,
+) error {
 
 	request := kube.V1().RESTClient().
 		Post().
@@ -201,7 +281,7 @@ func ExecInContainer(kube *ClientImpl, pod *v1.Pod, containerName string, sess s
 		VersionedParams(&v1.PodExecOptions{
 			Container: containerName,
 			Command:   command,
-			Stdin:     true,
+			Stdin:     !readOnly,
 			Stdout:    true,
 			Stderr:    true,
 			TTY:       true,
@@ -212,29 +292,95 @@ func ExecInContainer(kube *ClientImpl, pod *v1.Pod, containerName string, sess s
 		return err
 	}
 
-	ctx, cancel := context.WithCancel(kube.Ctx())
-	defer cancel()
-	tty := TerminalSession{}
-	tty.Init(sess, ctx)
+	err = streamSession(ctx, exec, sess, readOnly, true, timeouts)
+
+	if err != nil {
+		return fmt.Errorf("%w failed executing command on %v/%v container %s",
+			err, pod.Namespace, pod.Name, containerName)
+	}
+	return nil
+}
+
+// ExecCommand runs the command of the SSH session in the container without
+// a terminal and input, writing the command output to stdout and stderr.
+// The command is stopped with ErrSessionTimeout on the timeouts of the
+// session, the same way as the streams of the interactive sessions; the
+// warnings are written to stderr.
+//
+// Returns the exit code of the command, or error if the command could not be
+// executed at all.
+func ExecCommand(
+	ctx context.Context,
+	kube *ClientImpl,
+	pod *v1.Pod,
+	containerName string,
+	sess ssh.Session,
+	stdout io.Writer,
+	stderr io.Writer,
+	timeouts types.SessionTimeouts,
+) (int, error) {
+
+	command := sess.Command()
+
+	request := kube.V1().RESTClient().
+		Post().
+		Namespace(pod.Namespace).
+		Resource("pods").
+		Name(pod.Name).
+		SubResource("exec").
+		VersionedParams(&v1.PodExecOptions{
+			Container: containerName,
+			Command:   command,
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+
+	exec, err := remotecommand.NewSPDYExecutor(kube.cfg, "POST", request.URL())
+	if err != nil {
+		return 0, err
+	}
+
+	ctx, cancel, watchdog := watchSession(ctx, sess, stderr, timeouts)
+	defer cancel(nil)
 
 	err = exec.StreamWithContext(ctx, remotecommand.StreamOptions{
-		Stdin:             sess,
-		Stdout:            sess,
-		Stderr:            sess,
-		TerminalSizeQueue: &tty,
-		Tty:               true,
+		Stdout: watchdog.writer(stdout),
+		Stderr: watchdog.writer(stderr),
 	})
+	if cause := context.Cause(ctx); errors.Is(cause, ErrSessionTimeout) {
+		return 0, cause
+	}
 
+	var exitErr utilexec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitStatus(), nil
+	}
 	if err != nil {
-		return fmt.Errorf("%w failed executing command on %v/%v container %s",
+		return 0, fmt.Errorf("%w failed executing command on %v/%v container %s",
 			err, pod.Namespace, pod.Name, containerName)
 	}
-	return nil
+	return 0, nil
 }
 
 // AttachSshSessionTerminal setups SSH session to run a shell in the container
-func AttachSshSessionTerminal(kube *ClientImpl, pod *v1.Pod, containerName string, sess ssh.Session) error {
+//
+// In the readOnly mode the session mirrors the container output and drops
+// the user's input. The terminal is used only if the container allocates
+// one, so the output of any container, not only of the debug containers,
+// can be mirrored.
+//
+// Cancelling ctx terminates the session, as well as the timeouts do.
+func AttachSshSessionTerminal(
+	ctx context.Context,
+	kube *ClientImpl,
+	pod *v1.Pod,
+	containerName string,
+	sess ssh.Session,
+	readOnly bool,
+	timeouts types.SessionTimeouts,
+) error {
 
+	tty := containerTTY(pod, containerName)
 	request := kube.V1().RESTClient().
 		Post().
 		Namespace(pod.Namespace).
@@ -243,10 +389,10 @@ func AttachSshSessionTerminal(kube *ClientImpl, pod *v1.Pod, containerName strin
 		SubResource("attach").
 		VersionedParams(&v1.PodAttachOptions{
 			Container: containerName,
-			Stdin:     true,
+			Stdin:     !readOnly,
 			Stdout:    true,
 			Stderr:    true,
-			TTY:       true,
+			TTY:       tty,
 		}, scheme.ParameterCodec)
 
 	exec, err := remotecommand.NewSPDYExecutor(kube.cfg, "POST", request.URL())
@@ -254,18 +400,7 @@ func AttachSshSessionTerminal(kube *ClientImpl, pod *v1.Pod, containerName strin
 		return err
 	}
 
-	ctx, cancel := context.WithCancel(kube.Ctx())
-	defer cancel()
-	tty := TerminalSession{}
-	tty.Init(sess, ctx)
-
-	err = exec.StreamWithContext(ctx, remotecommand.StreamOptions{
-		Stdin:             sess,
-		Stdout:            sess,
-		Stderr:            sess,
-		TerminalSizeQueue: &tty,
-		Tty:               true,
-	})
+	err = streamSession(ctx, exec, sess, readOnly, tty, timeouts)
 
 	if err != nil {
 		return fmt.Errorf("%w failed executing shell on %v/%v container %s",
@@ -274,3 +409,81 @@ func AttachSshSessionTerminal(kube *ClientImpl, pod *v1.Pod, containerName strin
 
 	return nil
 }
+
+// streamSession binds the streams of the SSH session to the remote process
+// and blocks until the process completes.
+//
+// For the read-only sessions the user's input is drained instead of being
+// forwarded to the container. Detaching from such a session is not an error.
+//
+// The session is closed with ErrSessionTimeout when it is idle or lasts for
+// too long, or when the client doesn't answer the keepalive requests. The
+// user is warned before the timeouts.
+func streamSession(
+	ctx context.Context,
+	exec remotecommand.Executor,
+	sess ssh.Session,
+	readOnly bool,
+	tty bool,
+	timeouts types.SessionTimeouts,
+) error {
+
+	ctx, cancel, watchdog := watchSession(ctx, sess, sess.Stderr(), timeouts)
+	defer cancel(nil)
+	terminal := TerminalSession{}
+	terminal.Init(sess, ctx)
+
+	// The stream is set up once the first output arrives, or the process
+	// completes without any.
+	_, setup := tracing.Tracer().Start(ctx, "k8s.stream_setup",
+		trace.WithAttributes(attribute.Bool("ingressh.read_only", readOnly)))
+	output := &streamSetupWriter{Writer: watchdog.writer(sess), span: setup}
+	defer output.end()
+
+	options := remotecommand.StreamOptions{
+		Stdin:  watchdog.reader(sess),
+		Stdout: output,
+		Stderr: output,
+		Tty:    tty,
+	}
+	if tty {
+		options.TerminalSizeQueue = &terminal
+	}
+
+	if !readOnly {
+		err := exec.StreamWithContext(ctx, options)
+		if cause := context.Cause(ctx); errors.Is(cause, ErrSessionTimeout) {
+			return cause
+		}
+		return err
+	}
+
+	input := ReadOnlyInput{}
+	go input.Drain(options.Stdin, func() { cancel(nil) })
+	options.Stdin = nil
+
+	err := exec.StreamWithContext(ctx, options)
+	if input.Detached() {
+		return nil
+	}
+	if cause := context.Cause(ctx); errors.Is(cause, ErrSessionTimeout) {
+		return cause
+	}
+	return err
+}
+
+// containerTTY returns true if the container of the pod, including the
+// ephemeral ones, allocates a terminal.
+func containerTTY(pod *v1.Pod, containerName string) bool {
+	for _, c := range pod.Spec.Containers {
+		if c.Name == containerName {
+			return c.TTY
+		}
+	}
+	for _, c := range pod.Spec.EphemeralContainers {
+		if c.Name == containerName {
+			return c.TTY
+		}
+	}
+	return false
+}
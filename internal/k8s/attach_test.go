@@ -0,0 +1,32 @@
+package k8s
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestContainerTTY(t *testing.T) {
+
+	pod := &v1.Pod{Spec: v1.PodSpec{
+		Containers: []v1.Container{{Name: "app"}, {Name: "console", TTY: true}},
+		EphemeralContainers: []v1.EphemeralContainer{
+			{EphemeralContainerCommon: v1.EphemeralContainerCommon{Name: "ssh-access-1", TTY: true}},
+		},
+	}}
+
+	tests := []struct {
+		container string
+		tty       bool
+	}{
+		{"app", false},
+		{"console", true},
+		{"ssh-access-1", true},
+		{"missing", false},
+	}
+	for _, tc := range tests {
+		if tty := containerTTY(pod, tc.container); tty != tc.tty {
+			t.Errorf("containerTTY(%s) = %v, expected %v", tc.container, tty, tc.tty)
+		}
+	}
+}
@@ -14,6 +14,7 @@ import (
 type Client interface {
 	Namespaces() ([]string, error)
 	Pods(selector string, namespace string, hint string) ([]corev1.Pod, error)
+	ReplicaSetController(namespace string, name string) (*metav1.OwnerReference, error)
 }
 
 type ClientImpl struct {
@@ -34,8 +35,11 @@ type ClientImpl struct {
 //	if err != nil {
 //		return "", "", err
 //	}
+//
+// The API requests of the client are instrumented with the metrics.
 func (c *ClientImpl) Init(cfg *rest.Config) error {
-	c.cfg = cfg
+	c.cfg = rest.CopyConfig(cfg)
+	c.cfg.Wrap(instrumentTransport)
 	client, err := kubernetes.NewForConfig(c.cfg)
 	if err != nil {
 		return err
@@ -84,6 +88,19 @@ func (c *ClientImpl) Pods(selector string, namespace string, hint string) (
 	return pods.Items, nil
 }
 
+// ReplicaSetController returns the owner reference of the controller managing
+// the replica set, f.e. a deployment. Returns nil if the replica set is not
+// managed by a controller.
+func (c *ClientImpl) ReplicaSetController(namespace string, name string) (
+	*metav1.OwnerReference, error,
+) {
+	rs, err := c.client.AppsV1().ReplicaSets(namespace).Get(c.ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return metav1.GetControllerOf(rs), nil
+}
+
 // Returns the list of namespaces in the cluster.
 func (c *ClientImpl) Namespaces() ([]string, error) {
 	nss, err := c.V1().Namespaces().List(c.ctx, metav1.ListOptions{})
@@ -0,0 +1,64 @@
+package k8s
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"kuberstein.io/ingressh/internal/metrics"
+)
+
+// instrumentedTransport observes the latency and the errors of the Kubernetes
+// API requests passing through the wrapped transport.
+type instrumentedTransport struct {
+	next http.RoundTripper
+}
+
+func instrumentTransport(rt http.RoundTripper) http.RoundTripper {
+	return &instrumentedTransport{next: rt}
+}
+
+func (t *instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+
+	verb := strings.ToLower(req.Method)
+	if req.URL.Query().Get("watch") == "true" {
+		verb = "watch"
+	}
+	resource := apiResource(req.URL.Path)
+
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	metrics.KubeRequestDuration.WithLabelValues(verb, resource).Observe(time.Since(start).Seconds())
+
+	if err != nil {
+		metrics.KubeRequestErrors.WithLabelValues(verb, resource, "error").Inc()
+	} else if resp.StatusCode >= http.StatusBadRequest {
+		metrics.KubeRequestErrors.WithLabelValues(verb, resource, strconv.Itoa(resp.StatusCode)).Inc()
+	}
+	return resp, err
+}
+
+// apiResource returns the resource of the API path with the subresource if
+// any, f.e. "pods/ephemeralcontainers". The names of the namespaces and the
+// objects are dropped to keep the cardinality of the metrics low.
+func apiResource(path string) string {
+
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	switch {
+	case len(segments) > 2 && segments[0] == "api":
+		segments = segments[2:]
+	case len(segments) > 3 && segments[0] == "apis":
+		segments = segments[3:]
+	default:
+		return "other"
+	}
+
+	if segments[0] == "namespaces" && len(segments) > 2 {
+		segments = segments[2:]
+	}
+	if len(segments) > 2 {
+		return segments[0] + "/" + segments[2]
+	}
+	return segments[0]
+}
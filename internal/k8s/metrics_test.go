@@ -0,0 +1,28 @@
+package k8s
+
+import "testing"
+
+func TestApiResource(t *testing.T) {
+
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"/api/v1/namespaces", "namespaces"},
+		{"/api/v1/namespaces/default", "namespaces"},
+		{"/api/v1/namespaces/default/pods", "pods"},
+		{"/api/v1/namespaces/default/pods/nginx-0", "pods"},
+		{"/api/v1/namespaces/default/pods/nginx-0/ephemeralcontainers", "pods/ephemeralcontainers"},
+		{"/api/v1/namespaces/default/pods/nginx-0/exec", "pods/exec"},
+		{"/apis/apps/v1/namespaces/default/replicasets/api-7d9f", "replicasets"},
+		{"/apis/ingress.kuberstein.io/v1/ingresshes", "ingresshes"},
+		{"/version", "other"},
+		{"/api/v1", "other"},
+	}
+
+	for _, tt := range tests {
+		if got := apiResource(tt.path); got != tt.expected {
+			t.Errorf("apiResource(%q) = %q, expected %q", tt.path, got, tt.expected)
+		}
+	}
+}
@@ -2,6 +2,8 @@ package k8s
 
 import (
 	"context"
+	"io"
+	"sync/atomic"
 
 	"github.com/gliderlabs/ssh"
 	"k8s.io/client-go/tools/remotecommand"
@@ -32,3 +34,41 @@ func (t *TerminalSession) Next() *remotecommand.TerminalSize {
 
 	}
 }
+
+// Control characters which detach the user from the read-only session.
+const (
+	keyCtrlC = 0x03
+	keyCtrlD = 0x04
+)
+
+// ReadOnlyInput consumes the input of the view-only SSH session. The input
+// is never forwarded to the container, but the user still needs a way to
+// leave the session, so Ctrl+C, Ctrl+D or the end of the input stream
+// detaches the session.
+type ReadOnlyInput struct {
+	detached atomic.Bool
+}
+
+// Drain reads and drops the input of sess until the user detaches, then
+// invokes cancel to stop the streams of the session.
+func (r *ReadOnlyInput) Drain(sess io.Reader, cancel context.CancelFunc) {
+	buf := make([]byte, 256)
+	for {
+		n, err := sess.Read(buf)
+		for _, b := range buf[:n] {
+			if b == keyCtrlC || b == keyCtrlD {
+				err = io.EOF
+			}
+		}
+		if err != nil {
+			r.detached.Store(true)
+			cancel()
+			return
+		}
+	}
+}
+
+// Detached returns true if the user has requested to leave the session.
+func (r *ReadOnlyInput) Detached() bool {
+	return r.detached.Load()
+}
@@ -0,0 +1,209 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/gliderlabs/ssh"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+
+	"kuberstein.io/ingressh/internal/types"
+)
+
+// ErrSessionTimeout is the cause of the sessions closed by the server because
+// of their timeouts: idle, maximum duration or unanswered keepalives.
+var ErrSessionTimeout = errors.New("session timed out")
+
+const (
+	// keepAliveRequest is the request type used by OpenSSH for keepalives.
+	// The clients reply to it with a failure, which is fine: any reply means
+	// the client is alive.
+	keepAliveRequest = "keepalive@openssh.com"
+	// keepAliveCountMax is the number of unanswered keepalive requests in a
+	// row after which the client is considered dead.
+	keepAliveCountMax = 3
+	// warningLead is how long before the timeout the user is warned.
+	warningLead = time.Minute
+	// watchdogTick is the precision of the session timeouts.
+	watchdogTick = time.Second
+)
+
+// sessionWatchdog enforces the idle timeout and the maximum duration of the
+// session. The session streams touch the watchdog on every input and output.
+type sessionWatchdog struct {
+	timeouts types.SessionTimeouts
+	started  time.Time
+	// activity is the time of the last input or output, in unix nanoseconds
+	activity atomic.Int64
+
+	idleWarned bool
+	maxWarned  bool
+}
+
+func newSessionWatchdog(timeouts types.SessionTimeouts, now time.Time) *sessionWatchdog {
+	w := &sessionWatchdog{timeouts: timeouts, started: now}
+	w.touch(now)
+	return w
+}
+
+func (w *sessionWatchdog) touch(now time.Time) {
+	w.activity.Store(now.UnixNano())
+}
+
+// check returns the warning to show the user if the session is about to be
+// closed, or the error if the session has to be closed now.
+func (w *sessionWatchdog) check(now time.Time) (string, error) {
+
+	if max := w.timeouts.MaxDuration; max > 0 {
+		left := w.started.Add(max).Sub(now)
+		if left <= 0 {
+			return "", fmt.Errorf("%w: the maximum session duration %s is reached", ErrSessionTimeout, max)
+		}
+		if left <= lead(max) && !w.maxWarned {
+			w.maxWarned = true
+			return fmt.Sprintf("The session will be closed in %s: the maximum session duration is %s",
+				left.Round(time.Second), max), nil
+		}
+	}
+
+	if idle := w.timeouts.Idle; idle > 0 {
+		left := time.Unix(0, w.activity.Load()).Add(idle).Sub(now)
+		if left <= 0 {
+			return "", fmt.Errorf("%w: the session is idle for %s", ErrSessionTimeout, idle)
+		}
+		if left > lead(idle) {
+			// The user has been active since the warning
+			w.idleWarned = false
+		} else if !w.idleWarned {
+			w.idleWarned = true
+			return fmt.Sprintf("The session is idle and will be closed in %s unless there is some activity",
+				left.Round(time.Second)), nil
+		}
+	}
+
+	return "", nil
+}
+
+// lead returns how long before the timeout the user is warned: a minute, or
+// half of the timeout for the short ones.
+func lead(timeout time.Duration) time.Duration {
+	if timeout < 2*warningLead {
+		return timeout / 2
+	}
+	return warningLead
+}
+
+// watchSession enforces the timeouts of the session streams: starts the
+// watchdog writing its warnings to notice, and the keepalive requests.
+// Returns the context canceled with the timeout error as the cause, and the
+// watchdog to account the activity of the streams.
+func watchSession(ctx context.Context, sess ssh.Session, notice io.Writer, timeouts types.SessionTimeouts,
+) (context.Context, context.CancelCauseFunc, *sessionWatchdog) {
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	watchdog := newSessionWatchdog(timeouts, time.Now())
+	if timeouts.Idle > 0 || timeouts.MaxDuration > 0 {
+		go watchdog.run(ctx, notice, cancel)
+	}
+	if timeouts.KeepAliveInterval > 0 {
+		go keepAlive(ctx, sess, timeouts.KeepAliveInterval, cancel)
+	}
+	return ctx, cancel, watchdog
+}
+
+// run checks the timeouts of the session until ctx is done. The warnings are
+// written to notice. Cancels the session with the timeout error as the cause.
+func (w *sessionWatchdog) run(ctx context.Context, notice io.Writer, cancel context.CancelCauseFunc) {
+
+	ticker := time.NewTicker(watchdogTick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			warning, err := w.check(now)
+			if warning != "" {
+				fmt.Fprintf(notice, "\r\n%s\r\n", warning)
+			}
+			if err != nil {
+				fmt.Fprintf(notice, "\r\nThe session is closed: %s\r\n", err)
+				cancel(err)
+				return
+			}
+		}
+	}
+}
+
+// reader touches the watchdog on every input.
+func (w *sessionWatchdog) reader(r io.Reader) io.Reader {
+	return &activityReader{Reader: r, watchdog: w}
+}
+
+// writer touches the watchdog on every output.
+func (w *sessionWatchdog) writer(wr io.Writer) io.Writer {
+	return &activityWriter{Writer: wr, watchdog: w}
+}
+
+type activityReader struct {
+	io.Reader
+	watchdog *sessionWatchdog
+}
+
+func (r *activityReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if n > 0 {
+		r.watchdog.touch(time.Now())
+	}
+	return n, err
+}
+
+type activityWriter struct {
+	io.Writer
+	watchdog *sessionWatchdog
+}
+
+func (w *activityWriter) Write(p []byte) (int, error) {
+	w.watchdog.touch(time.Now())
+	return w.Writer.Write(p)
+}
+
+// keepAlive sends the keepalive requests to the SSH client every interval
+// until ctx is done. Cancels the session with the timeout error as the cause
+// when the client doesn't answer keepAliveCountMax requests in a row.
+func keepAlive(ctx context.Context, sess ssh.Session, interval time.Duration, cancel context.CancelCauseFunc) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var unanswered atomic.Int32
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if unanswered.Load() >= keepAliveCountMax {
+				ctrllog.FromContext(ctx).Info("The client doesn't answer the keepalive requests",
+					"unanswered", keepAliveCountMax)
+				cancel(fmt.Errorf("%w: the client doesn't answer %d keepalive requests",
+					ErrSessionTimeout, keepAliveCountMax))
+				return
+			}
+			unanswered.Add(1)
+			// The reply is awaited asynchronously, as it never comes
+			// from the dead clients. The request fails once the session
+			// is closed.
+			go func() {
+				if _, err := sess.SendRequest(keepAliveRequest, true, nil); err == nil {
+					unanswered.Store(0)
+				}
+			}()
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package k8s
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"kuberstein.io/ingressh/internal/types"
+)
+
+func TestSessionWatchdogCheck(t *testing.T) {
+
+	started := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		timeouts types.SessionTimeouts
+		activity time.Duration
+		elapsed  time.Duration
+		warning  bool
+		expired  bool
+	}{
+		{"no timeouts", types.SessionTimeouts{}, 0, 100 * time.Hour, false, false},
+		{"active", types.SessionTimeouts{Idle: 30 * time.Minute}, 0, 10 * time.Minute, false, false},
+		{"idle warning", types.SessionTimeouts{Idle: 30 * time.Minute}, 0, 29*time.Minute + 30*time.Second, true, false},
+		{"idle expired", types.SessionTimeouts{Idle: 30 * time.Minute}, 0, 30 * time.Minute, false, true},
+		{"idle after activity", types.SessionTimeouts{Idle: 30 * time.Minute}, 20 * time.Minute, 40 * time.Minute, false, false},
+		{"short idle warning", types.SessionTimeouts{Idle: time.Minute}, 0, 40 * time.Second, true, false},
+		{"max warning", types.SessionTimeouts{MaxDuration: 8 * time.Hour}, 0, 8*time.Hour - time.Minute, true, false},
+		{"max expired though active", types.SessionTimeouts{MaxDuration: time.Hour, Idle: time.Hour}, time.Hour, time.Hour, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newSessionWatchdog(tt.timeouts, started)
+			w.touch(started.Add(tt.activity))
+
+			warning, err := w.check(started.Add(tt.elapsed))
+			if (warning != "") != tt.warning {
+				t.Errorf("check() warning = %q, expected warning: %v", warning, tt.warning)
+			}
+			if (err != nil) != tt.expired {
+				t.Errorf("check() error = %v, expected expired: %v", err, tt.expired)
+			}
+			if err != nil && !errors.Is(err, ErrSessionTimeout) {
+				t.Errorf("check() error = %v, expected ErrSessionTimeout", err)
+			}
+		})
+	}
+}
+
+func TestSessionWatchdogWarnsOnce(t *testing.T) {
+
+	started := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	w := newSessionWatchdog(types.SessionTimeouts{Idle: 10 * time.Minute}, started)
+
+	if warning, _ := w.check(started.Add(9*time.Minute + 10*time.Second)); warning == "" {
+		t.Fatalf("expected the idle warning")
+	}
+	if warning, _ := w.check(started.Add(9*time.Minute + 20*time.Second)); warning != "" {
+		t.Errorf("expected the idle warning only once, got %q", warning)
+	}
+
+	// The activity resets the warning
+	w.touch(started.Add(9*time.Minute + 30*time.Second))
+	if warning, _ := w.check(started.Add(10 * time.Minute)); warning != "" {
+		t.Errorf("expected no warning after the activity, got %q", warning)
+	}
+	if warning, _ := w.check(started.Add(18*time.Minute + 40*time.Second)); warning == "" {
+		t.Errorf("expected the idle warning again")
+	}
+}
+
+func TestWatchSession(t *testing.T) {
+
+	notice := &bytes.Buffer{}
+	ctx, cancel, _ := watchSession(context.Background(), nil, notice, types.SessionTimeouts{MaxDuration: time.Second})
+	defer cancel(nil)
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the session to be closed on the maximum duration")
+	}
+	if cause := context.Cause(ctx); !errors.Is(cause, ErrSessionTimeout) {
+		t.Errorf("expected the timeout cause, got %v", cause)
+	}
+	if !strings.Contains(notice.String(), "The session is closed") {
+		t.Errorf("expected the notice, got %q", notice.String())
+	}
+}
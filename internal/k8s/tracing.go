@@ -0,0 +1,76 @@
+package k8s
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"kuberstein.io/ingressh/internal/tracing"
+)
+
+// tracedClient traces the calls of the wrapped client as the children of
+// the span in the context.
+type tracedClient struct {
+	ctx  context.Context
+	next Client
+}
+
+// WithTracing returns the client tracing its calls under the span of ctx,
+// f.e. the calls made to authorize the session.
+func WithTracing(ctx context.Context, client Client) Client {
+	return &tracedClient{ctx: ctx, next: client}
+}
+
+func (c *tracedClient) Namespaces() ([]string, error) {
+	_, span := tracing.Tracer().Start(c.ctx, "k8s.namespaces.list")
+	namespaces, err := c.next.Namespaces()
+	span.SetAttributes(attribute.Int("k8s.namespaces.count", len(namespaces)))
+	tracing.EndSpan(span, err)
+	return namespaces, err
+}
+
+func (c *tracedClient) Pods(selector string, namespace string, hint string) ([]corev1.Pod, error) {
+	_, span := tracing.Tracer().Start(c.ctx, "k8s.pods.list", trace.WithAttributes(
+		attribute.String("k8s.namespace.name", namespace),
+		attribute.String("k8s.pod.selector", selector),
+		attribute.String("k8s.pod.hint", hint),
+	))
+	pods, err := c.next.Pods(selector, namespace, hint)
+	span.SetAttributes(attribute.Int("k8s.pods.count", len(pods)))
+	tracing.EndSpan(span, err)
+	return pods, err
+}
+
+func (c *tracedClient) ReplicaSetController(namespace string, name string) (*metav1.OwnerReference, error) {
+	_, span := tracing.Tracer().Start(c.ctx, "k8s.replicaset.get", trace.WithAttributes(
+		attribute.String("k8s.namespace.name", namespace),
+		attribute.String("k8s.replicaset.name", name),
+	))
+	owner, err := c.next.ReplicaSetController(namespace, name)
+	tracing.EndSpan(span, err)
+	return owner, err
+}
+
+// streamSetupWriter ends the stream setup span when the first output of the
+// remote process arrives, which means the stream is established.
+type streamSetupWriter struct {
+	io.Writer
+	span trace.Span
+	once sync.Once
+}
+
+func (w *streamSetupWriter) Write(p []byte) (int, error) {
+	w.end()
+	return w.Writer.Write(p)
+}
+
+func (w *streamSetupWriter) end() {
+	w.once.Do(func() {
+		w.span.End()
+	})
+}
@@ -0,0 +1,117 @@
+// Package metrics defines the Prometheus metrics of the SSH server and of its
+// Kubernetes operations. The metrics are registered in the controller-runtime
+// registry, so they are exposed on the metrics endpoint of the manager along
+// with the controller metrics.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const namespace = "ingressh"
+
+// Results of the connections and authentication attempts
+const (
+	ResultAccepted = "accepted"
+	ResultRejected = "rejected"
+	ResultSuccess  = "success"
+	ResultFailure  = "failure"
+)
+
+// Directions of the session traffic, from the user's point of view
+const (
+	DirectionIn  = "in"
+	DirectionOut = "out"
+)
+
+var (
+	// Connections counts the SSH connections by result: accepted once the
+	// user is authenticated, rejected when the handshake fails.
+	Connections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ssh_connections_total",
+		Help:      "Number of SSH connections by result.",
+	}, []string{"result"})
+
+	// AuthAttempts counts the authentication attempts by method and result.
+	AuthAttempts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ssh_auth_attempts_total",
+		Help:      "Number of SSH authentication attempts by method and result.",
+	}, []string{"method", "result"})
+
+	// ActiveSessions is the number of the sessions attached to the
+	// containers, by the target namespace and the session mode.
+	ActiveSessions = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "ssh_active_sessions",
+		Help:      "Number of active SSH sessions by target namespace and session mode.",
+	}, []string{"namespace", "mode"})
+
+	// SessionDuration observes the duration of the ended sessions.
+	SessionDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "ssh_session_duration_seconds",
+		Help:      "Duration of SSH sessions by target namespace and session mode.",
+		// 1s to ~9h
+		Buckets: prometheus.ExponentialBuckets(1, 3, 11),
+	}, []string{"namespace", "mode"})
+
+	// SessionBytes counts the bytes of the session streams: the input of
+	// the user and the output of the container.
+	SessionBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ssh_session_bytes_total",
+		Help:      "Number of bytes transferred by SSH sessions by direction.",
+	}, []string{"direction"})
+
+	// TimeToFirstByte observes the time from the session start to the
+	// first output of the container.
+	TimeToFirstByte = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "ssh_time_to_first_byte_seconds",
+		Help:      "Time from the SSH session start to the first output byte by session mode.",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 10),
+	}, []string{"mode"})
+
+	// DebugContainerStartup observes the time the ephemeral container takes
+	// to reach the running state, by result: running or failed.
+	DebugContainerStartup = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "debug_container_startup_seconds",
+		Help:      "Startup latency of the ephemeral access containers by result.",
+		Buckets:   prometheus.ExponentialBuckets(0.25, 2, 10),
+	}, []string{"result"})
+
+	// KubeRequestDuration observes the latency of the Kubernetes API calls
+	// of the SSH server.
+	KubeRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "kube_request_duration_seconds",
+		Help:      "Latency of Kubernetes API requests of the SSH server by verb and resource.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"verb", "resource"})
+
+	// KubeRequestErrors counts the failed Kubernetes API calls of the SSH
+	// server by the status code, or "error" if there is no response.
+	KubeRequestErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "kube_request_errors_total",
+		Help:      "Number of failed Kubernetes API requests of the SSH server by verb, resource and code.",
+	}, []string{"verb", "resource", "code"})
+)
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(
+		Connections,
+		AuthAttempts,
+		ActiveSessions,
+		SessionDuration,
+		SessionBytes,
+		TimeToFirstByte,
+		DebugContainerStartup,
+		KubeRequestDuration,
+		KubeRequestErrors,
+	)
+}
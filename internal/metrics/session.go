@@ -0,0 +1,68 @@
+package metrics
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/gliderlabs/ssh"
+)
+
+// Session wraps the SSH session to count the bytes of its streams and to
+// observe the time to the first output byte. It is used in place of the
+// original session for the session streams.
+type Session struct {
+	ssh.Session
+
+	mode      string
+	started   time.Time
+	firstByte sync.Once
+}
+
+// Meter starts metering the session streams. The time to the first byte is
+// counted from the started time.
+func Meter(sess ssh.Session, mode string, started time.Time) *Session {
+	return &Session{Session: sess, mode: mode, started: started}
+}
+
+// Read counts the input of the user.
+func (s *Session) Read(p []byte) (int, error) {
+	n, err := s.Session.Read(p)
+	SessionBytes.WithLabelValues(DirectionIn).Add(float64(n))
+	return n, err
+}
+
+// Write counts the output of the session.
+func (s *Session) Write(p []byte) (int, error) {
+	n, err := s.Session.Write(p)
+	s.output(n)
+	return n, err
+}
+
+// Stderr returns the stderr stream of the session, which is counted as the
+// output as well.
+func (s *Session) Stderr() io.ReadWriter {
+	return stderr{ReadWriter: s.Session.Stderr(), session: s}
+}
+
+func (s *Session) output(n int) {
+	if n == 0 {
+		return
+	}
+	s.firstByte.Do(func() {
+		TimeToFirstByte.WithLabelValues(s.mode).Observe(time.Since(s.started).Seconds())
+	})
+	SessionBytes.WithLabelValues(DirectionOut).Add(float64(n))
+}
+
+// stderr counts the data written to the stderr stream of the session.
+type stderr struct {
+	io.ReadWriter
+	session *Session
+}
+
+func (s stderr) Write(p []byte) (int, error) {
+	n, err := s.ReadWriter.Write(p)
+	s.session.output(n)
+	return n, err
+}
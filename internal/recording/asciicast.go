@@ -0,0 +1,147 @@
+package recording
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// header is the first line of the asciicast v2 file.
+// See https://docs.asciinema.org/manual/asciicast/v2/
+type header struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// Types of the asciicast v2 events
+const (
+	eventOutput = "o"
+	eventResize = "r"
+)
+
+// Recorder writes the terminal session as asciicast v2 stream.
+//
+// Recording errors never break the session: the first error stops the
+// recording and is reported by Close.
+type Recorder struct {
+	out     io.WriteCloser
+	started time.Time
+	pending []byte
+	index   *wordIndex
+	err     error
+	mutex   sync.Mutex
+}
+
+// NewRecorder writes asciicast header to out and returns the recorder of the
+// session events.
+func NewRecorder(out io.WriteCloser, width int, height int, title string, env map[string]string) (*Recorder, error) {
+
+	r := &Recorder{
+		out:     out,
+		started: time.Now(),
+		index:   newWordIndex(),
+	}
+
+	line, err := json.Marshal(header{
+		Version:   2,
+		Width:     width,
+		Height:    height,
+		Timestamp: r.started.Unix(),
+		Title:     title,
+		Env:       env,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fmt.Fprintf(out, "%s\n", line); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Output records the data written to the terminal.
+func (r *Recorder) Output(p []byte) {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	// A multi-byte character split between writes is kept until the rest of
+	// it arrives, as the events must carry valid UTF-8 strings.
+	data := append(r.pending, p...)
+	end := len(data)
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				end = i
+			}
+			break
+		}
+	}
+	r.pending = append([]byte{}, data[end:]...)
+
+	if end > 0 {
+		r.writeEvent(eventOutput, string(data[:end]))
+		r.index.add(string(data[:end]))
+	}
+}
+
+// Resize records the terminal size change.
+func (r *Recorder) Resize(width int, height int) {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.writeEvent(eventResize, fmt.Sprintf("%dx%d", width, height))
+}
+
+// Close completes the recording.
+func (r *Recorder) Close() error {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if len(r.pending) > 0 {
+		r.writeEvent(eventOutput, string(r.pending))
+		r.index.add(string(r.pending))
+		r.pending = nil
+	}
+
+	if err := r.out.Close(); err != nil && r.err == nil {
+		r.err = err
+	}
+	return r.err
+}
+
+// Words returns the sorted distinct words of the recorded output, or false
+// if there are too many of them to index. The recorder must be closed.
+func (r *Recorder) Words() ([]string, bool) {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.index.sorted()
+}
+
+func (r *Recorder) writeEvent(eventType string, data string) {
+
+	if r.err != nil {
+		return
+	}
+
+	elapsed := time.Since(r.started).Seconds()
+	line, err := json.Marshal([]interface{}{elapsed, eventType, data})
+	if err == nil {
+		_, err = fmt.Fprintf(r.out, "%s\n", line)
+	}
+	if err != nil {
+		recordingLog.Error(err, "Session recording stopped")
+		r.err = err
+	}
+}
@@ -0,0 +1,79 @@
+package recording
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+type bufferCloser struct {
+	bytes.Buffer
+}
+
+func (b *bufferCloser) Close() error {
+	return nil
+}
+
+func TestRecorder(t *testing.T) {
+
+	out := &bufferCloser{}
+	r, err := NewRecorder(out, 120, 40, "test", map[string]string{"TERM": "xterm"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	euro := []byte("€")
+	r.Output([]byte("hello\n"))
+	r.Output(append([]byte("price "), euro[:2]...))
+	r.Output(append(euro[2:], '!'))
+	r.Resize(100, 30)
+	r.Output(euro[:1])
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 6 {
+		t.Fatalf("expected 6 lines, got %d: %q", len(lines), lines)
+	}
+
+	var h header
+	if err := json.Unmarshal([]byte(lines[0]), &h); err != nil {
+		t.Fatal(err)
+	}
+	if h.Version != 2 || h.Width != 120 || h.Height != 40 || h.Env["TERM"] != "xterm" {
+		t.Errorf("unexpected header %+v", h)
+	}
+
+	tests := []struct {
+		eventType string
+		data      string
+	}{
+		{eventOutput, "hello\n"},
+		{eventOutput, "price "},
+		{eventOutput, "€!"},
+		{eventResize, "100x30"},
+		// Incomplete character is flushed on close as a replacement
+		{eventOutput, "\ufffd"},
+	}
+	for i, tt := range tests {
+		var event []interface{}
+		if err := json.Unmarshal([]byte(lines[i+1]), &event); err != nil {
+			t.Fatalf("event %d: %v", i, err)
+		}
+		if len(event) != 3 {
+			t.Fatalf("event %d: unexpected %v", i, event)
+		}
+		if event[1] != tt.eventType {
+			t.Errorf("event %d: expected type %q, got %q", i, tt.eventType, event[1])
+		}
+		if event[2] != tt.data {
+			t.Errorf("event %d: expected %q, got %q", i, tt.data, event[2])
+		}
+	}
+
+	if words, ok := r.Words(); !ok || strings.Join(words, " ") != "hello price" {
+		t.Errorf("unexpected index %q", words)
+	}
+}
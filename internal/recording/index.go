@@ -0,0 +1,145 @@
+package recording
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Limits of the word index of the recording. The sessions with more distinct
+// words are not indexed and are searched by reading the whole recording.
+const (
+	maxIndexWords = 20000
+	maxWordLength = 64
+)
+
+// States of the terminal escape sequences skipped by the index
+const (
+	escapeNone = iota
+	escapeStart
+	escapeCSI
+	escapeOSC
+)
+
+// wordIndex collects the distinct words of the output, so the recordings
+// could be searched by their index without reading the recordings. The
+// words are lowercased, and the terminal escape sequences are skipped, so
+// the colored output is indexed as the plain text.
+type wordIndex struct {
+	words map[string]struct{}
+	// wanted limits the index to the searched words, if set
+	wanted   map[string]struct{}
+	word     strings.Builder
+	long     bool
+	escape   int
+	overflow bool
+}
+
+func newWordIndex() *wordIndex {
+	return &wordIndex{words: map[string]struct{}{}}
+}
+
+// add indexes the output, which must not split the multi-byte characters.
+// The word at the end of the output is completed by the next call.
+func (x *wordIndex) add(output string) {
+	for _, c := range output {
+		switch x.escape {
+		case escapeStart:
+			switch c {
+			case '[':
+				x.escape = escapeCSI
+			case ']':
+				x.escape = escapeOSC
+			default:
+				x.escape = escapeNone
+			}
+			continue
+		case escapeCSI:
+			if c >= 0x40 && c <= 0x7e {
+				x.escape = escapeNone
+			}
+			continue
+		case escapeOSC:
+			switch c {
+			case '\a':
+				x.escape = escapeNone
+			case '\x1b':
+				x.escape = escapeStart
+			}
+			continue
+		}
+
+		switch {
+		case c == '\x1b':
+			x.flush()
+			x.escape = escapeStart
+		case c == '_' || unicode.IsLetter(c) || unicode.IsDigit(c):
+			if x.word.Len() >= maxWordLength {
+				x.long = true
+				continue
+			}
+			x.word.WriteRune(unicode.ToLower(c))
+		default:
+			x.flush()
+		}
+	}
+}
+
+// flush completes the current word. The words longer than the limit are
+// not indexed.
+func (x *wordIndex) flush() {
+
+	word, long := x.word.String(), x.long
+	x.word.Reset()
+	x.long = false
+	if word == "" || long || x.overflow {
+		return
+	}
+	if _, ok := x.wanted[word]; x.wanted != nil && !ok {
+		return
+	}
+
+	x.words[word] = struct{}{}
+	if len(x.words) > maxIndexWords {
+		x.overflow = true
+		x.words = nil
+	}
+}
+
+// sorted completes the index and returns its sorted words, or false if the
+// output has too many words to index.
+func (x *wordIndex) sorted() ([]string, bool) {
+
+	x.flush()
+	if x.overflow {
+		return nil, false
+	}
+	words := make([]string, 0, len(x.words))
+	for word := range x.words {
+		words = append(words, word)
+	}
+	sort.Strings(words)
+	return words, true
+}
+
+// Words returns the distinct words of the text the way they are indexed,
+// for searching the recordings with ContainsWords.
+func Words(text string) []string {
+	x := newWordIndex()
+	x.add(text)
+	words, _ := x.sorted()
+	return words
+}
+
+// ContainsWords returns true if the index of the recording contains all the
+// words, which are found with Words. The recordings which are not indexed
+// are searched by their output with Cast.ContainsWords, matching the same.
+func ContainsWords(index []string, words []string) bool {
+	for _, word := range words {
+		i := sort.SearchStrings(index, word)
+		if i == len(index) || index[i] != word {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,77 @@
+package recording
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestWordIndex(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		output []string
+		words  []string
+	}{
+		{"plain", []string{"kubectl get pods\r\n"}, []string{"get", "kubectl", "pods"}},
+		{"case and duplicates", []string{"Error: error\n"}, []string{"error"}},
+		{"split word", []string{"kube", "ctl delete"}, []string{"delete", "kubectl"}},
+		{"colors", []string{"\x1b[1;31mERROR\x1b[0m done"}, []string{"done", "error"}},
+		{"split escape", []string{"\x1b[3", "2mok"}, []string{"ok"}},
+		{"title", []string{"\x1b]0;user@host\aprompt"}, []string{"prompt"}},
+		{"unicode", []string{"Größe_1 €"}, []string{"größe_1"}},
+		{"long word", []string{fmt.Sprintf("%065d short", 0)}, []string{"short"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			x := newWordIndex()
+			for _, o := range tt.output {
+				x.add(o)
+			}
+			words, ok := x.sorted()
+			if !ok || !reflect.DeepEqual(words, tt.words) {
+				t.Errorf("expected %q, got %q (%v)", tt.words, words, ok)
+			}
+		})
+	}
+}
+
+func TestWordIndexOverflow(t *testing.T) {
+
+	x := newWordIndex()
+	for i := 0; i <= maxIndexWords; i++ {
+		x.add(fmt.Sprintf("w%d ", i))
+	}
+	if words, ok := x.sorted(); ok || words != nil {
+		t.Errorf("expected the index to overflow, got %d words", len(words))
+	}
+}
+
+func TestContainsWords(t *testing.T) {
+
+	// The indexed recording and the recording searched by its output match
+	// the same
+	output := "$ kubectl delete pod nginx\r\npod \"nginx\" deleted"
+	index := Words(output)
+	cast := &Cast{Events: []Event{{Type: eventOutput, Data: output}}}
+	tests := []struct {
+		text     string
+		expected bool
+	}{
+		{"kubectl delete", true},
+		{"KUBECTL   Delete", true},
+		{"delete kubectl", true},
+		{"\"nginx\" deleted", true},
+		{"kubectl get", false},
+		{"kube", false},
+	}
+	for _, tt := range tests {
+		if got := ContainsWords(index, Words(tt.text)); got != tt.expected {
+			t.Errorf("%q: expected %v in the index, got %v", tt.text, tt.expected, got)
+		}
+		if got := cast.ContainsWords(Words(tt.text)); got != tt.expected {
+			t.Errorf("%q: expected %v in the output, got %v", tt.text, tt.expected, got)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package recording
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LocalSink stores the recordings as files in the local directory, f.e. on
+// the mounted persistent volume.
+type LocalSink struct {
+	dir string
+}
+
+// NewLocalSink returns the sink storing the recordings in the directory,
+// creating the directory if needed.
+func NewLocalSink(dir string) (*LocalSink, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("recording directory is not specified")
+	}
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("unable to create recording directory %s: %w", dir, err)
+	}
+	return &LocalSink{dir: dir}, nil
+}
+
+func (s *LocalSink) Create(name string) (io.WriteCloser, error) {
+	return os.OpenFile(filepath.Join(s.dir, name+castExt), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o640)
+}
+
+func (s *LocalSink) SaveMetadata(name string, meta Metadata) error {
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(s.dir, name+metadataExt), data, 0o640)
+}
+
+func (s *LocalSink) SaveIndex(name string, words []string) error {
+	return os.WriteFile(filepath.Join(s.dir, name+indexExt), []byte(strings.Join(words, "\n")), 0o640)
+}
+
+func (s *LocalSink) List() ([]Recording, error) {
+
+	files, err := filepath.Glob(filepath.Join(s.dir, "*"+metadataExt))
+	if err != nil {
+		return nil, err
+	}
+
+	recordings := []Recording{}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		r := Recording{Name: strings.TrimSuffix(filepath.Base(file), metadataExt)}
+		if err := json.Unmarshal(data, &r.Metadata); err != nil {
+			return nil, fmt.Errorf("invalid metadata of recording %s: %w", r.Name, err)
+		}
+		recordings = append(recordings, r)
+	}
+	return recordings, nil
+}
+
+func (s *LocalSink) Open(name string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(s.dir, filepath.Base(name)+castExt))
+}
+
+func (s *LocalSink) Index(name string) ([]string, error) {
+	data, err := os.ReadFile(filepath.Join(s.dir, filepath.Base(name)+indexExt))
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(string(data)), nil
+}
@@ -0,0 +1,247 @@
+package recording
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Event is a recorded event of the terminal session.
+type Event struct {
+	Time float64
+	Type string
+	Data string
+}
+
+// Cast is a parsed asciicast v2 recording.
+type Cast struct {
+	Width  int
+	Height int
+	Events []Event
+}
+
+// ReadCast parses the asciicast v2 recording.
+func ReadCast(r io.Reader) (*Cast, error) {
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("empty recording")
+	}
+	var h header
+	if err := json.Unmarshal(scanner.Bytes(), &h); err != nil {
+		return nil, fmt.Errorf("invalid recording header: %w", err)
+	}
+	if h.Version != 2 {
+		return nil, fmt.Errorf("unsupported recording version %d", h.Version)
+	}
+
+	cast := &Cast{Width: h.Width, Height: h.Height}
+	for scanner.Scan() {
+		var fields []interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &fields); err != nil {
+			return nil, fmt.Errorf("invalid recording event: %w", err)
+		}
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("invalid recording event: %s", scanner.Text())
+		}
+		t, okTime := fields[0].(float64)
+		eventType, okType := fields[1].(string)
+		data, okData := fields[2].(string)
+		if !okTime || !okType || !okData {
+			return nil, fmt.Errorf("invalid recording event: %s", scanner.Text())
+		}
+		cast.Events = append(cast.Events, Event{Time: t, Type: eventType, Data: data})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return cast, nil
+}
+
+// Duration returns the length of the recording in seconds.
+func (c *Cast) Duration() float64 {
+	if len(c.Events) == 0 {
+		return 0
+	}
+	return c.Events[len(c.Events)-1].Time
+}
+
+// ContainsWords returns true if the output of the recording contains all the
+// words, which are found with Words. The output is split into the words the
+// same way as it is indexed, collecting only the searched ones.
+func (c *Cast) ContainsWords(words []string) bool {
+	x := newWordIndex()
+	x.wanted = map[string]struct{}{}
+	for _, word := range words {
+		x.wanted[word] = struct{}{}
+	}
+	for _, e := range c.Events {
+		if e.Type == eventOutput {
+			x.add(e.Data)
+		}
+	}
+	found, _ := x.sorted()
+	return ContainsWords(found, words)
+}
+
+// Control is a command of the user to the player.
+type Control int
+
+const (
+	ControlPause Control = iota
+	ControlFaster
+	ControlSlower
+	ControlForward
+	ControlBackward
+	ControlQuit
+)
+
+// Player settings
+const (
+	minSpeed = 0.25
+	maxSpeed = 16
+	// seekStep is the time the seek controls move the playback by.
+	seekStep = 5.0
+	// maxIdle limits the pauses of the playback, so long inactivity in the
+	// session doesn't look like the player hung.
+	maxIdle = 2.0
+	// resetTerminal clears the screen of the viewer before seeking back.
+	resetTerminal = "\x1bc"
+)
+
+// Player plays the recording back into the terminal of the viewer.
+type Player struct {
+	cast   *Cast
+	out    io.Writer
+	speed  float64
+	paused bool
+	// next is the index of the next event to play
+	next int
+	// clock is the current position of the playback in the recording time
+	clock float64
+}
+
+// NewPlayer returns the player of the recording writing into out.
+func NewPlayer(cast *Cast, out io.Writer, speed float64) *Player {
+	return &Player{cast: cast, out: out, speed: clampSpeed(speed)}
+}
+
+// Play plays the recording until its end, ControlQuit or the controls channel
+// is closed.
+func (p *Player) Play(ctx context.Context, controls <-chan Control) error {
+
+	events := p.cast.Events
+	for p.next < len(events) {
+
+		if p.paused {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case c, ok := <-controls:
+				if !ok || c == ControlQuit {
+					return nil
+				}
+				p.control(c)
+			}
+			continue
+		}
+
+		event := events[p.next]
+		wait := event.Time - p.clock
+		if wait > maxIdle {
+			wait = maxIdle
+		}
+		started := time.Now()
+		timer := time.NewTimer(time.Duration(wait / p.speed * float64(time.Second)))
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+
+		case c, ok := <-controls:
+			timer.Stop()
+			if !ok || c == ControlQuit {
+				return nil
+			}
+			p.clock += time.Since(started).Seconds() * p.speed
+			if p.clock > event.Time {
+				p.clock = event.Time
+			}
+			p.control(c)
+
+		case <-timer.C:
+			p.clock = event.Time
+			if err := p.play(event); err != nil {
+				return err
+			}
+			p.next++
+		}
+	}
+	return nil
+}
+
+func (p *Player) control(c Control) {
+	switch c {
+	case ControlPause:
+		p.paused = !p.paused
+	case ControlFaster:
+		p.speed = clampSpeed(p.speed * 2)
+	case ControlSlower:
+		p.speed = clampSpeed(p.speed / 2)
+	case ControlForward:
+		p.seek(p.clock + seekStep)
+	case ControlBackward:
+		p.seek(p.clock - seekStep)
+	}
+}
+
+// seek moves the playback to the position. The terminal state can't be
+// rewound, so seeking back replays the output from the start at once.
+func (p *Player) seek(position float64) {
+
+	if position < 0 {
+		position = 0
+	}
+	if position < p.clock {
+		fmt.Fprint(p.out, resetTerminal)
+		p.next = 0
+	}
+
+	events := p.cast.Events
+	for p.next < len(events) && events[p.next].Time <= position {
+		if p.play(events[p.next]) != nil {
+			break
+		}
+		p.next++
+	}
+	p.clock = position
+}
+
+func (p *Player) play(event Event) error {
+	// The terminal of the viewer can't be resized by the player, so only
+	// the output is played.
+	if event.Type != eventOutput {
+		return nil
+	}
+	_, err := io.WriteString(p.out, event.Data)
+	return err
+}
+
+func clampSpeed(speed float64) float64 {
+	if speed < minSpeed {
+		return minSpeed
+	}
+	if speed > maxSpeed {
+		return maxSpeed
+	}
+	return speed
+}
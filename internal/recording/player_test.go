@@ -0,0 +1,92 @@
+package recording
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+const testCast = `{"version":2,"width":80,"height":24,"timestamp":1700000000}
+[0.1,"o","$ "]
+[0.5,"o","kubectl get pods\r\n"]
+[0.7,"r","100x30"]
+[1.0,"o","No resources found\r\n"]
+`
+
+func TestReadCast(t *testing.T) {
+
+	cast, err := ReadCast(strings.NewReader(testCast))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cast.Width != 80 || cast.Height != 24 || len(cast.Events) != 4 {
+		t.Fatalf("unexpected cast %+v", cast)
+	}
+	if cast.Duration() != 1.0 {
+		t.Errorf("expected duration 1.0, got %v", cast.Duration())
+	}
+	if !cast.ContainsWords(Words("KUBECTL get")) {
+		t.Errorf("expected the output to contain the command")
+	}
+	if cast.ContainsWords(Words("100x30")) {
+		t.Errorf("expected resize events not to be searched")
+	}
+
+	_, err = ReadCast(strings.NewReader(`{"version":1}`))
+	if err == nil {
+		t.Errorf("expected error for unsupported version")
+	}
+}
+
+func TestPlayerSeek(t *testing.T) {
+
+	cast, err := ReadCast(strings.NewReader(testCast))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := &bytes.Buffer{}
+	p := NewPlayer(cast, out, 1)
+
+	p.seek(0.6)
+	if out.String() != "$ kubectl get pods\r\n" {
+		t.Errorf("unexpected output after seek forward: %q", out.String())
+	}
+
+	out.Reset()
+	p.seek(0.2)
+	if out.String() != resetTerminal+"$ " {
+		t.Errorf("unexpected output after seek back: %q", out.String())
+	}
+
+	// Playing the rest at the maximum speed
+	out.Reset()
+	p.speed = maxSpeed
+	if err := p.Play(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "kubectl get pods\r\nNo resources found\r\n" {
+		t.Errorf("unexpected output of the playback: %q", out.String())
+	}
+}
+
+func TestPlayerQuit(t *testing.T) {
+
+	cast, err := ReadCast(strings.NewReader(testCast))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	controls := make(chan Control, 2)
+	controls <- ControlPause
+	controls <- ControlQuit
+
+	out := &bytes.Buffer{}
+	if err := NewPlayer(cast, out, 1).Play(context.Background(), controls); err != nil {
+		t.Fatal(err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("expected no output, got %q", out.String())
+	}
+}
@@ -0,0 +1,160 @@
+package recording
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+
+	"kuberstein.io/ingressh/internal/types"
+)
+
+// S3Sink stores the recordings in the bucket of S3-compatible object storage,
+// like AWS S3 or MinIO.
+type S3Sink struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+// NewS3Sink returns the sink storing the recordings in the configured bucket.
+// The bucket must exist.
+func NewS3Sink(conf types.S3Config) (*S3Sink, error) {
+
+	if conf.Endpoint == "" || conf.Bucket == "" {
+		return nil, fmt.Errorf("S3 endpoint and bucket must be specified for the recordings")
+	}
+
+	client, err := minio.New(conf.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(conf.AccessKey, conf.SecretKey, ""),
+		Secure: !conf.Insecure,
+		Region: conf.Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to create S3 client: %w", err)
+	}
+
+	exists, err := client.BucketExists(context.Background(), conf.Bucket)
+	if err != nil {
+		return nil, fmt.Errorf("unable to check S3 bucket %s: %w", conf.Bucket, err)
+	}
+	if !exists {
+		return nil, fmt.Errorf("S3 bucket %s does not exist", conf.Bucket)
+	}
+
+	return &S3Sink{client: client, bucket: conf.Bucket, prefix: conf.Prefix}, nil
+}
+
+// s3PartSize is the size of the parts of the recording uploads. The client
+// buffers a part in memory for every recorded session, and would size the
+// parts for the largest object without it. The parts of 5 MiB, the minimum
+// of S3, limit the recording to about 48 GiB.
+const s3PartSize = 5 * 1024 * 1024
+
+// s3Upload streams the recording to the object storage while it is written.
+type s3Upload struct {
+	*io.PipeWriter
+	done chan error
+}
+
+// Close completes the recording and waits for the upload to finish.
+func (u *s3Upload) Close() error {
+	u.PipeWriter.Close()
+	return <-u.done
+}
+
+func (s *S3Sink) Create(name string) (io.WriteCloser, error) {
+
+	reader, writer := io.Pipe()
+	upload := &s3Upload{PipeWriter: writer, done: make(chan error, 1)}
+
+	go func() {
+		// The size of the recording is unknown, so the object is uploaded
+		// in parts as the data arrives.
+		_, err := s.client.PutObject(context.Background(), s.bucket, s.objectName(name+castExt),
+			reader, -1, minio.PutObjectOptions{ContentType: "application/x-asciicast", PartSize: s3PartSize})
+		reader.CloseWithError(err)
+		upload.done <- err
+	}()
+
+	return upload, nil
+}
+
+func (s *S3Sink) SaveMetadata(name string, meta Metadata) error {
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = s.client.PutObject(context.Background(), s.bucket, s.objectName(name+metadataExt),
+		bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{ContentType: "application/json"})
+	return err
+}
+
+func (s *S3Sink) SaveIndex(name string, words []string) error {
+	data := []byte(strings.Join(words, "\n"))
+	_, err := s.client.PutObject(context.Background(), s.bucket, s.objectName(name+indexExt),
+		bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{ContentType: "text/plain"})
+	return err
+}
+
+func (s *S3Sink) List() ([]Recording, error) {
+
+	ctx := context.Background()
+	prefix := ""
+	if s.prefix != "" {
+		prefix = strings.TrimSuffix(s.prefix, "/") + "/"
+	}
+
+	recordings := []Recording{}
+	for object := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: prefix}) {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		if !strings.HasSuffix(object.Key, metadataExt) {
+			continue
+		}
+
+		r := Recording{Name: strings.TrimSuffix(strings.TrimPrefix(object.Key, prefix), metadataExt)}
+		if err := s.readMetadata(ctx, object.Key, &r.Metadata); err != nil {
+			return nil, fmt.Errorf("invalid metadata of recording %s: %w", r.Name, err)
+		}
+		recordings = append(recordings, r)
+	}
+	return recordings, nil
+}
+
+func (s *S3Sink) readMetadata(ctx context.Context, key string, meta *Metadata) error {
+	object, err := s.client.GetObject(ctx, s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return err
+	}
+	defer object.Close()
+	return json.NewDecoder(object).Decode(meta)
+}
+
+func (s *S3Sink) Open(name string) (io.ReadCloser, error) {
+	return s.client.GetObject(context.Background(), s.bucket, s.objectName(name+castExt), minio.GetObjectOptions{})
+}
+
+func (s *S3Sink) Index(name string) ([]string, error) {
+	object, err := s.client.GetObject(context.Background(), s.bucket, s.objectName(name+indexExt), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer object.Close()
+	data, err := io.ReadAll(object)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(string(data)), nil
+}
+
+func (s *S3Sink) objectName(name string) string {
+	return path.Join(s.prefix, name)
+}
@@ -0,0 +1,151 @@
+package recording
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/gliderlabs/ssh"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+var recordingLog = ctrllog.Log.WithName("recording")
+
+// Session wraps the SSH session to record the output and the terminal size
+// changes, while passing everything through to the wrapped session. It is
+// used in place of the original session for the session streams.
+type Session struct {
+	ssh.Session
+
+	name     string
+	meta     Metadata
+	sink     Sink
+	recorder *Recorder
+
+	winOnce sync.Once
+	winCh   chan ssh.Window
+}
+
+// Default terminal size for the recordings of the sessions without a terminal.
+const (
+	defaultWidth  = 80
+	defaultHeight = 24
+)
+
+// Start starts the recording of the SSH session into the sink.
+func Start(sess ssh.Session, sink Sink, meta Metadata) (*Session, error) {
+
+	meta.StartTime = time.Now().UTC()
+	name := recordingName(meta)
+
+	out, err := sink.Create(name)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create recording %s: %w", name, err)
+	}
+
+	width, height := defaultWidth, defaultHeight
+	env := map[string]string{}
+	if pty, _, isPty := sess.Pty(); isPty {
+		width, height = pty.Window.Width, pty.Window.Height
+		env["TERM"] = pty.Term
+	}
+
+	title := fmt.Sprintf("%s@%s/%s/%s", meta.User, meta.Namespace, meta.Pod, meta.Container)
+	recorder, err := NewRecorder(out, width, height, title, env)
+	if err != nil {
+		out.Close()
+		return nil, fmt.Errorf("unable to start recording %s: %w", name, err)
+	}
+
+	recordingLog.Info("Recording the session", "recording", name, "session", meta.SessionID)
+	return &Session{
+		Session:  sess,
+		name:     name,
+		meta:     meta,
+		sink:     sink,
+		recorder: recorder,
+	}, nil
+}
+
+// recordingName names the recording after its start time, target and session
+// ID, so the names are sorted chronologically and could be found by the ID.
+// The container tells apart the recordings of the fan-out session.
+func recordingName(meta Metadata) string {
+	return fmt.Sprintf("%s-%s-%s-%s-%s",
+		meta.StartTime.Format("20060102T150405Z"), meta.Namespace, meta.Pod, meta.Container, meta.SessionID)
+}
+
+// Finish completes the recording and stores the index of the output words
+// and the metadata with the session exit code. The index is stored first, so
+// the listed recordings marked as indexed always have it.
+func (s *Session) Finish(exitCode int) error {
+
+	err := s.recorder.Close()
+
+	s.meta.EndTime = time.Now().UTC()
+	s.meta.ExitCode = exitCode
+	if words, ok := s.recorder.Words(); ok {
+		// The recording without the index is still searched by its output
+		if indexErr := s.sink.SaveIndex(s.name, words); indexErr != nil {
+			recordingLog.Error(indexErr, "Unable to store the index of the recording", "recording", s.name)
+		} else {
+			s.meta.Indexed = true
+		}
+	}
+	if metaErr := s.sink.SaveMetadata(s.name, s.meta); metaErr != nil && err == nil {
+		err = metaErr
+	}
+
+	if err != nil {
+		return fmt.Errorf("unable to complete recording %s: %w", s.name, err)
+	}
+	return nil
+}
+
+// Write records and writes the data to the session output.
+func (s *Session) Write(p []byte) (int, error) {
+	n, err := s.Session.Write(p)
+	s.recorder.Output(p[:n])
+	return n, err
+}
+
+// Stderr returns the stderr stream of the session, which is recorded the
+// same way as the output.
+func (s *Session) Stderr() io.ReadWriter {
+	return stderr{ReadWriter: s.Session.Stderr(), recorder: s.recorder}
+}
+
+// Pty returns the terminal of the session. The window changes go through
+// the recorder to the returned channel.
+func (s *Session) Pty() (ssh.Pty, <-chan ssh.Window, bool) {
+
+	pty, winCh, isPty := s.Session.Pty()
+	if !isPty {
+		return pty, winCh, isPty
+	}
+
+	s.winOnce.Do(func() {
+		s.winCh = make(chan ssh.Window, 1)
+		go func() {
+			defer close(s.winCh)
+			for win := range winCh {
+				s.recorder.Resize(win.Width, win.Height)
+				s.winCh <- win
+			}
+		}()
+	})
+	return pty, s.winCh, isPty
+}
+
+// stderr records the data written to the stderr stream of the session.
+type stderr struct {
+	io.ReadWriter
+	recorder *Recorder
+}
+
+func (s stderr) Write(p []byte) (int, error) {
+	n, err := s.ReadWriter.Write(p)
+	s.recorder.Output(p[:n])
+	return n, err
+}
@@ -0,0 +1,74 @@
+package recording
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"kuberstein.io/ingressh/internal/types"
+)
+
+// Extensions of the stored objects of the recording
+const (
+	castExt     = ".cast"
+	metadataExt = ".json"
+	indexExt    = ".words"
+)
+
+// Metadata describes the recorded session.
+type Metadata struct {
+	SessionID   string    `json:"sessionId"`
+	Route       string    `json:"route"`
+	User        string    `json:"user"`
+	Fingerprint string    `json:"fingerprint"`
+	Namespace   string    `json:"namespace"`
+	Pod         string    `json:"pod"`
+	Container   string    `json:"container"`
+	Mode        string    `json:"mode"`
+	Command     []string  `json:"command,omitempty"`
+	StartTime   time.Time `json:"startTime"`
+	EndTime     time.Time `json:"endTime"`
+	ExitCode    int       `json:"exitCode"`
+	// Indexed is set if the index of the output words is stored with the
+	// recording, so the recording could be searched without reading it.
+	Indexed bool `json:"indexed,omitempty"`
+}
+
+// Sink stores the session recordings.
+type Sink interface {
+	// Create creates a new recording with the name. The recording is
+	// complete when the returned writer is closed.
+	Create(name string) (io.WriteCloser, error)
+	// SaveMetadata stores the metadata of the recording with the name.
+	SaveMetadata(name string, meta Metadata) error
+	// SaveIndex stores the sorted distinct words of the output of the
+	// recording with the name.
+	SaveIndex(name string, words []string) error
+	// List returns the completed recordings, which have the metadata stored.
+	// The indexes of the recordings are not read.
+	List() ([]Recording, error)
+	// Open returns the reader of the recording with the name.
+	Open(name string) (io.ReadCloser, error)
+	// Index returns the words of the output of the indexed recording.
+	Index(name string) ([]string, error)
+}
+
+// Recording is a stored recording of the session.
+type Recording struct {
+	Name string
+	Metadata
+}
+
+// NewSink returns the sink configured for the server, or nil if the session
+// recording is not configured.
+func NewSink(conf types.RecordingConfig) (Sink, error) {
+	switch conf.Sink {
+	case "":
+		return nil, nil
+	case types.RecordingSinkLocal:
+		return NewLocalSink(conf.Dir)
+	case types.RecordingSinkS3:
+		return NewS3Sink(conf.S3)
+	}
+	return nil, fmt.Errorf("unknown recording sink %s", conf.Sink)
+}
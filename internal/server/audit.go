@@ -0,0 +1,86 @@
+package server
+
+import (
+	"net"
+	"net/netip"
+
+	"github.com/gliderlabs/ssh"
+	gossh "golang.org/x/crypto/ssh"
+
+	"kuberstein.io/ingressh/internal/audit"
+	"kuberstein.io/ingressh/internal/tracing"
+	"kuberstein.io/ingressh/internal/types"
+)
+
+// auditEvent returns the audit event of the type with the details of the
+// connection: the session, the user and where the user came from.
+func auditEvent(ctx ssh.Context, eventType string) audit.Event {
+	e := audit.Event{
+		Type:      eventType,
+		SessionID: GetSessionIDFromCtx(ctx),
+		Login:     ctx.User(),
+		SourceIP:  sourceIP(ctx.RemoteAddr()),
+		TraceID:   tracing.TraceID(connContext(ctx)),
+	}
+	if username, ok := ctx.Value(ctxKeyUsername).(string); ok {
+		e.User = username
+	}
+	if authorizedKey, ok := ctx.Value(ctxKeyAuthorizedKey).(string); ok {
+		e.Fingerprint = fingerprint(authorizedKey)
+	}
+	return e
+}
+
+// targetAuditEvent returns the audit event of the type regarding the target
+// of the session.
+func targetAuditEvent(ctx ssh.Context, eventType string, target types.SshTarget, config *types.SshConfig) audit.Event {
+	e := auditEvent(ctx, eventType)
+	e.Namespace = target.Namespace
+	e.Pod = target.Pod
+	e.Container = target.Container
+	if config != nil {
+		e.Route = config.Route()
+		e.Mode = config.Session
+	}
+	return e
+}
+
+// auditContainerCreated logs the creation of the ephemeral access container
+// targeting the container of the session target.
+func auditContainerCreated(ctx ssh.Context, target types.SshTarget, accessContainerName string, config *types.SshConfig) {
+	e := targetAuditEvent(ctx, audit.EventContainerCreated, target, config)
+	e.AccessContainer = accessContainerName
+	audit.Log(e)
+}
+
+// sourceIP returns the IP address of the client without the port.
+func sourceIP(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// sourceAddr returns the IP address of the remote address, or the invalid
+// address if it is not an IP address.
+func sourceAddr(addr net.Addr) netip.Addr {
+	ip, err := netip.ParseAddr(sourceIP(addr))
+	if err != nil {
+		return netip.Addr{}
+	}
+	return ip.Unmap().WithZone("")
+}
+
+// fingerprint returns the SHA256 fingerprint of the key in the authorized_keys
+// format, so the logs identify the keys without exposing them.
+func fingerprint(authorizedKey string) string {
+	key, _, _, _, err := gossh.ParseAuthorizedKey([]byte(authorizedKey))
+	if err != nil {
+		return ""
+	}
+	return gossh.FingerprintSHA256(key)
+}
@@ -1,13 +1,19 @@
 package server
 
 import (
+	"net"
+	"net/netip"
 	"strings"
-	"time"
 
 	"github.com/gliderlabs/ssh"
-	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	gossh "golang.org/x/crypto/ssh"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
 
+	"kuberstein.io/ingressh/internal/audit"
+	"kuberstein.io/ingressh/internal/metrics"
+	"kuberstein.io/ingressh/internal/tracing"
 	"kuberstein.io/ingressh/internal/types"
 )
 
@@ -17,40 +23,143 @@ type contextKey struct {
 	name string
 }
 
-var ctxKeySshConfigs = &contextKey{"ssh_configs"}
+var (
+	ctxKeySshConfigs    = &contextKey{"ssh_configs"}
+	ctxKeyAuthorizedKey = &contextKey{"authorized_key"}
+	ctxKeyUsername      = &contextKey{"username"}
+	ctxKeyAuthenticated = &contextKey{"authenticated"}
+)
 
+// PublicKeyAuthHandler authorizes the public key of the user. It is called
+// for the queries of the client whether the key is acceptable as well, which
+// carry no signature, so the handshake is not done until the first channel
+// of the connection.
 func PublicKeyAuthHandler(ctx ssh.Context, key ssh.PublicKey) bool {
+
+	_, span := tracing.Tracer().Start(connContext(ctx), "ssh.auth.publickey", trace.WithAttributes(
+		attribute.String("ssh.login", ctx.User()),
+		attribute.String("ssh.key.fingerprint", gossh.FingerprintSHA256(key)),
+	))
+	defer span.End()
+
+	if publicKeyAuth(ctx, key) {
+		span.SetAttributes(attribute.String("ssh.auth.result", metrics.ResultSuccess))
+		metrics.AuthAttempts.WithLabelValues("publickey", metrics.ResultSuccess).Inc()
+		return true
+	}
+	span.SetAttributes(attribute.String("ssh.auth.result", metrics.ResultFailure))
+	metrics.AuthAttempts.WithLabelValues("publickey", metrics.ResultFailure).Inc()
+	authFailed(ctx)
+	return false
+}
+
+// ConnectionFailedHandler accounts the connections rejected during the
+// handshake, including the ones failed to authenticate.
+func ConnectionFailedHandler(conn net.Conn, err error) {
+	ctrllog.Log.WithName("ssh").V(1).Info("Connection failed", "sourceIP", sourceIP(conn.RemoteAddr()), "reason", err.Error())
+	metrics.Connections.WithLabelValues(metrics.ResultRejected).Inc()
+}
+
+func publicKeyAuth(ctx ssh.Context, key ssh.PublicKey) bool {
+	logger := Logger(ctx)
 	authorized_key := strings.TrimSpace(string(gossh.MarshalAuthorizedKey(key)))
 
-	// log.Errorf("Authorizing the key: %s", authorized_key)
+	failure := auditEvent(ctx, audit.EventAuthFailure)
+	failure.Fingerprint = gossh.FingerprintSHA256(key)
+
+	if !allowsPublicKey(cryptoAlgorithms().PublicKeyAlgorithms, key) {
+		logger.Info("Public key auth failed: key algorithm is not allowed", "login", ctx.User(), "algorithm", key.Type())
+		failure.Reason = "key algorithm not allowed"
+		audit.Log(failure)
+		return false
+	}
+
 	ssh_configs, err := Routes.Get(string(authorized_key))
 	if err != nil {
-		log.Errorf("Public key auth failed for %v: %v", ctx.User(), err)
-
-		// Hold on upon incorrect authentication attempts to prevent
-		// brute-forcing of the secrets
-		time.Sleep(1 * time.Second)
+		logger.Error(err, "Public key auth failed", "login", ctx.User())
+		failure.Reason = "unknown key"
+		audit.Log(failure)
+		return false
+	}
 
+	// The routes restricted to the other source addresses don't match
+	ssh_configs = filterSourceRanges(ssh_configs, sourceAddr(ctx.RemoteAddr()))
+	if len(ssh_configs) == 0 {
+		logger.Info("Public key auth failed: no routes from the source address", "login", ctx.User())
+		failure.Reason = "no routes from the source address"
+		audit.Log(failure)
 		return false
 	}
 
 	username, err := Routes.GetUsername(string(authorized_key))
 	if err != nil {
-		log.Errorf("Can't get login for the authenticated %v: %v", ctx.User(), err)
+		logger.Error(err, "Can't get user name of the authenticated key", "login", ctx.User())
+		failure.Reason = err.Error()
+		audit.Log(failure)
 		return false
 	}
 
-	log.Infof("User %s is authenticated successfully as %s", ctx.User(), username)
+	logger = logger.WithValues("user", username)
 
 	if len(ssh_configs) == 0 {
-		log.Errorf("Empty set of SSH routes for user %s", username)
+		logger.Error(nil, "Empty set of SSH routes for the user")
+		failure.User = username
+		failure.Reason = "no routes"
+		audit.Log(failure)
 		return false
 	}
 
 	ctx.SetValue(ctxKeySshConfigs, ssh_configs)
+	ctx.SetValue(ctxKeyAuthorizedKey, authorized_key)
+	ctx.SetValue(ctxKeyUsername, username)
+	setLogger(ctx, logger)
 	return true
 }
 
+// authenticated logs the successful authentication of the user once for the
+// connection. The public key handler runs for the unsigned queries of the
+// keys as well, so the authentication is logged only when the first channel
+// or request is handled, after the signature is verified.
+func authenticated(ctx ssh.Context) {
+
+	ctx.Lock()
+	logged := ctx.Value(ctxKeyAuthenticated) != nil
+	if !logged {
+		ctx.SetValue(ctxKeyAuthenticated, true)
+	}
+	ctx.Unlock()
+	if logged {
+		return
+	}
+
+	Logger(ctx).Info("User is authenticated successfully", "login", ctx.User())
+	audit.Log(auditEvent(ctx, audit.EventAuthSuccess))
+}
+
+// filterSourceRanges returns the routes the users may connect to from the
+// address.
+func filterSourceRanges(configs []*types.SshConfig, addr netip.Addr) []*types.SshConfig {
+	filtered := make([]*types.SshConfig, 0, len(configs))
+	for _, config := range configs {
+		if config.AllowsSource(addr) {
+			filtered = append(filtered, config)
+		}
+	}
+	return filtered
+}
+
 func GetSshConfigsFromCtx(ctx ssh.Context) []*types.SshConfig {
 	return ctx.Value(ctxKeySshConfigs).([]*types.SshConfig)
 }
+
+// GetAuthorizedKeyFromCtx returns the authorized key the user has been
+// authenticated with, in the format of the authorized_keys file line.
+func GetAuthorizedKeyFromCtx(ctx ssh.Context) string {
+	return ctx.Value(ctxKeyAuthorizedKey).(string)
+}
+
+// GetUsernameFromCtx returns the login name configured for the authorized key
+// of the user. The name is used for audit only.
+func GetUsernameFromCtx(ctx ssh.Context) string {
+	return ctx.Value(ctxKeyUsername).(string)
+}
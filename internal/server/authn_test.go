@@ -0,0 +1,87 @@
+package server
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	gossh "golang.org/x/crypto/ssh"
+
+	ingssh "kuberstein.io/ingressh/api/v1"
+	"kuberstein.io/ingressh/internal/audit"
+	"kuberstein.io/ingressh/internal/types"
+)
+
+func TestPublicKeyQuery(t *testing.T) {
+
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := gossh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &types.SshConfig{Name: "query", Namespace: "ns", IngreSshSpec: ingssh.IngreSshSpec{
+		AuthorizedKeys: []ingssh.AuthorizedKey{{User: "alice", Key: strings.TrimSpace(string(gossh.MarshalAuthorizedKey(key)))}},
+	}}
+	Routes.Set(config)
+	defer Routes.Delete(config)
+
+	Sessions.SetLimits(types.LimitsConfig{Handshakes: 1})
+	defer Sessions.SetLimits(types.LimitsConfig{})
+	if !Sessions.StartHandshake() {
+		t.Fatal("expected the handshake to start")
+	}
+	auditFile := filepath.Join(t.TempDir(), "audit.log")
+	auditLog, err := audit.NewLogger(types.AuditConfig{Sink: types.AuditSinkFile, File: auditFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	audit.SetLogger(auditLog)
+	defer func() {
+		stdout, _ := audit.NewLogger(types.AuditConfig{})
+		audit.SetLogger(stdout)
+	}()
+
+	h := &handshake{ip: netip.MustParseAddr("10.0.0.7")}
+	ctx := newTestContext()
+	ctx.SetValue(ctxKeyHandshake, h)
+
+	// The client asks whether the key is acceptable, but never signs
+	if !PublicKeyAuthHandler(ctx, key) {
+		t.Fatal("expected the key to be acceptable")
+	}
+	if Sessions.StartHandshake() {
+		t.Error("expected the query to keep the handshake slot")
+	}
+	if log, _ := os.ReadFile(auditFile); strings.Contains(string(log), audit.EventAuthSuccess) {
+		t.Errorf("expected no authentication in the audit log of the query, got %s", log)
+	}
+
+	// The connection is closed without authentication
+	h.end(false)
+	if !Sessions.StartHandshake() {
+		t.Fatal("expected the handshake slot to be released")
+	}
+	Sessions.EndHandshake()
+
+	// The authentication is logged once on the channels of the signed key
+	ctx = newTestContext()
+	if !PublicKeyAuthHandler(ctx, key) {
+		t.Fatal("expected the key to be acceptable")
+	}
+	handshakeDone(ctx)
+	handshakeDone(ctx)
+	log, err := os.ReadFile(auditFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(log), audit.EventAuthSuccess); n != 1 {
+		t.Errorf("expected the authentication in the audit log once, got %s", log)
+	}
+}
@@ -2,9 +2,12 @@ package server
 
 import (
 	"errors"
+	"sort"
 
 	"golang.org/x/exp/maps"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	"kuberstein.io/ingressh/internal/k8s"
 	"kuberstein.io/ingressh/internal/types"
@@ -19,23 +22,58 @@ var (
 // podSshConfig is a tuple binding together target pod and corresponding
 // SSH config, as a result of the authorization.
 type podSshConfig struct {
-	pod    corev1.Pod
-	config *types.SshConfig
+	pod      corev1.Pod
+	config   *types.SshConfig
+	readOnly bool
+}
+
+// resolvedTarget is a target container the user is authorized to access,
+// together with the pod and the corresponding SSH config.
+type resolvedTarget struct {
+	target    types.SshTarget
+	podConfig podSshConfig
 }
 
 // authz is an authorization engine.
 type authz struct {
 	authorizedConfigs []*types.SshConfig
+	authorizedKey     string
 	kube              k8s.Client
 }
 
-func GetAuthz(configs []*types.SshConfig, kube k8s.Client) authz {
+func GetAuthz(configs []*types.SshConfig, authorizedKey string, kube k8s.Client) authz {
 	return authz{
 		authorizedConfigs: configs,
+		authorizedKey:     authorizedKey,
 		kube:              kube,
 	}
 }
 
+// RestrictToAlias limits the authorized configurations to the routes named
+// with the alias, so that only the configuration of the selected resource
+// applies to the session.
+//
+// Returns false and keeps the authorization intact if none of the authorized
+// routes has such alias, as the login name could be just the user's name.
+func (a *authz) RestrictToAlias(alias string) bool {
+	if alias == "" {
+		return false
+	}
+
+	aliased := []*types.SshConfig{}
+	for _, c := range a.authorizedConfigs {
+		if c.HasAlias(alias) {
+			aliased = append(aliased, c)
+		}
+	}
+	if len(aliased) == 0 {
+		return false
+	}
+
+	a.authorizedConfigs = aliased
+	return true
+}
+
 func (a authz) getClusterNamespaces() (map[string]bool, error) {
 	nss, err := a.kube.Namespaces()
 	if err != nil {
@@ -155,40 +193,161 @@ func (a authz) listPods(configs []*types.SshConfig, hintPod string, useSelectors
 
 	result := []podSshConfig{}
 
-	// Functions to appends pods to the result set, checking for duplicates
-	deduplicatePods := map[string]bool{}
-	appendResult := func(pods []corev1.Pod, c *types.SshConfig) {
+	// Only the exact pod name could be passed to the API server. The patterns
+	// and the workload references are matched against the listed pods.
+	ref := types.ParsePodRef(hintPod)
+	hintName := ""
+	if ref.IsExact() {
+		hintName = ref.Name
+	}
+	rsControllers := map[string]*metav1.OwnerReference{}
+
+	// Functions to appends pods to the result set, checking for duplicates.
+	// The pod authorized by several resources is accessed with the first
+	// read-write one, so a read-only resource never restricts the access
+	// granted by another resource.
+	deduplicatePods := map[string]int{}
+	appendResult := func(pods []corev1.Pod, c *types.SshConfig) error {
 		for _, pod := range pods {
-			if _, ok := deduplicatePods[pod.Name]; !ok {
-				result = append(result, podSshConfig{pod: pod, config: c})
-				deduplicatePods[pod.Name] = true
+			if !ref.IsExact() {
+				matched, err := a.matchPodRef(pod, ref, rsControllers)
+				if err != nil {
+					return err
+				}
+				if !matched {
+					continue
+				}
+			}
+			readOnly := c.IsReadOnly(a.authorizedKey)
+			if i, ok := deduplicatePods[pod.Name]; !ok {
+				result = append(result, podSshConfig{
+					pod:      pod,
+					config:   c,
+					readOnly: readOnly,
+				})
+				deduplicatePods[pod.Name] = len(result) - 1
+			} else if result[i].readOnly && !readOnly {
+				result[i].config = c
+				result[i].readOnly = false
 			}
 		}
+		return nil
 	}
 
 	for _, c := range configs {
 		if len(c.Selectors) == 0 || !useSelectors {
 			// No sense to check the rest of configs, as a config without
-			// the selector scans the whole namespace for pods
-			pods, err := a.kube.Pods("", c.Namespace, hintPod)
+			// the selector scans the whole namespace for pods. Unless it
+			// is read-only: the rest could grant the read-write access.
+			pods, err := a.kube.Pods("", c.Namespace, hintName)
 			if err != nil {
 				return []podSshConfig{}, err
 			}
-			appendResult(pods, c)
-			break
+			if err := appendResult(pods, c); err != nil {
+				return []podSshConfig{}, err
+			}
+			if !useSelectors || !c.IsReadOnly(a.authorizedKey) {
+				break
+			}
+			continue
 		}
 
 		for _, selector := range c.Selectors {
-			pods, err := a.kube.Pods(selector, c.Namespace, hintPod)
+			pods, err := a.kube.Pods(selector, c.Namespace, hintName)
 			if err != nil {
 				return []podSshConfig{}, err
 			}
-			appendResult(pods, c)
+			if err := appendResult(pods, c); err != nil {
+				return []podSshConfig{}, err
+			}
 		}
 	}
 	return result, nil
 }
 
+// matchPodRef reports whether the pod is referenced by the user's hint.
+//
+// Workload references are resolved through the controller owner references
+// of the pod. The pods of a deployment are owned by its replica sets, so the
+// controllers of the replica sets are looked up and cached in rsControllers.
+// The stateful set references also name the single pods of the stateful
+// sets, like `sts/db-0` for the pod db-0 of the stateful set db.
+func (a authz) matchPodRef(
+	pod corev1.Pod,
+	ref types.PodRef,
+	rsControllers map[string]*metav1.OwnerReference,
+) (bool, error) {
+
+	if !ref.IsWorkload() {
+		return ref.MatchName(pod.Name), nil
+	}
+
+	owner := metav1.GetControllerOf(&pod)
+	if owner == nil {
+		return false, nil
+	}
+
+	if ref.Kind == types.KindStatefulSet && owner.Kind == types.KindStatefulSet && ref.MatchName(pod.Name) {
+		return true, nil
+	}
+	if ref.Kind != types.KindDeployment {
+		return owner.Kind == ref.Kind && ref.MatchName(owner.Name), nil
+	}
+
+	if owner.Kind != types.KindReplicaSet {
+		return false, nil
+	}
+	rsController, ok := rsControllers[owner.Name]
+	if !ok {
+		var err error
+		rsController, err = a.kube.ReplicaSetController(pod.Namespace, owner.Name)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return false, err
+		}
+		rsControllers[owner.Name] = rsController
+	}
+
+	return rsController != nil &&
+		rsController.Kind == types.KindDeployment &&
+		ref.MatchName(rsController.Name), nil
+}
+
+// workloadRef returns the reference to the workload controlling the pod, in
+// the login string form like `deploy/api`, so the reference outlives the pod.
+// The pods of the stateful sets and the pods without the controller are
+// referenced by their names, which are stable.
+func (a authz) workloadRef(pod corev1.Pod, rsControllers map[string]*metav1.OwnerReference) (string, error) {
+
+	owner := metav1.GetControllerOf(&pod)
+	if owner == nil {
+		return pod.Name, nil
+	}
+
+	switch owner.Kind {
+	case types.KindDaemonSet:
+		return "ds/" + owner.Name, nil
+	case types.KindJob:
+		return "job/" + owner.Name, nil
+	case types.KindReplicaSet:
+	default:
+		return pod.Name, nil
+	}
+
+	rsController, ok := rsControllers[owner.Name]
+	if !ok {
+		var err error
+		rsController, err = a.kube.ReplicaSetController(pod.Namespace, owner.Name)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return "", err
+		}
+		rsControllers[owner.Name] = rsController
+	}
+	if rsController != nil && rsController.Kind == types.KindDeployment {
+		return "deploy/" + rsController.Name, nil
+	}
+	return "rs/" + owner.Name, nil
+}
+
 // GetContainers returns a list of containers from the specified pod user is
 // authorized to access.
 //
@@ -254,3 +413,55 @@ func (a authz) GetContainers(pod corev1.Pod, restrictList []string, hintContaine
 
 	return []string{}, nil
 }
+
+// ResolveTargets returns all the containers the user is authorized to
+// access, matching the hint. The targets are ordered by namespace, then
+// accordingly to the pod selection policy.
+//
+// Authorization failures for the hinted objects are reported only if no
+// authorized targets are found at all.
+func (a authz) ResolveTargets(hint types.SshTarget) ([]resolvedTarget, error) {
+
+	namespaces, err := a.GetNamespaces(hint.Namespace)
+	if err != nil {
+		return []resolvedTarget{}, err
+	}
+	sort.Strings(namespaces)
+
+	var authErr error
+	result := []resolvedTarget{}
+
+	for _, ns := range namespaces {
+		podConfigs, err := a.GetPods(ns, hint.Pod)
+		if errors.Is(err, ErrAuthorizationFailed) {
+			authErr = err
+			continue
+		}
+		if err != nil {
+			return []resolvedTarget{}, err
+		}
+
+		for _, podConfig := range a.OrderPods(podConfigs, hint.Pod) {
+			containers, err := a.GetContainers(podConfig.pod, podConfig.config.Containers, hint.Container)
+			if errors.Is(err, ErrAuthorizationFailed) {
+				authErr = err
+				continue
+			}
+			if err != nil {
+				return []resolvedTarget{}, err
+			}
+
+			for _, c := range containers {
+				result = append(result, resolvedTarget{
+					target:    types.SshTarget{Namespace: ns, Pod: podConfig.pod.Name, Container: c},
+					podConfig: podConfig,
+				})
+			}
+		}
+	}
+
+	if len(result) == 0 && authErr != nil {
+		return result, authErr
+	}
+	return result, nil
+}
@@ -5,6 +5,7 @@ import (
 	"reflect"
 	"sort"
 	"testing"
+	"time"
 
 	ingssh "kuberstein.io/ingressh/api/v1"
 
@@ -25,6 +26,9 @@ func (c clientNamespacesMock) Namespaces() ([]string, error) {
 func (c clientNamespacesMock) Pods(selector string, namespace string, hint string) ([]corev1.Pod, error) {
 	return []corev1.Pod{}, nil
 }
+func (c clientNamespacesMock) ReplicaSetController(namespace string, name string) (*metav1.OwnerReference, error) {
+	return nil, nil
+}
 
 func TestNamespaceAccess(t *testing.T) {
 
@@ -92,7 +96,8 @@ type clientPodMock struct {
 		pod      corev1.Pod
 		selector string
 	}
-	err error
+	replicaSets map[string]*metav1.OwnerReference
+	err         error
 }
 
 func (c clientPodMock) Namespaces() ([]string, error) {
@@ -111,6 +116,9 @@ func (c clientPodMock) Pods(selector string, namespace string, hint string) ([]c
 	}
 	return r, nil
 }
+func (c clientPodMock) ReplicaSetController(namespace string, name string) (*metav1.OwnerReference, error) {
+	return c.replicaSets[name], nil
+}
 
 func TestPodAccess(t *testing.T) {
 
@@ -194,3 +202,318 @@ func TestPodAccess(t *testing.T) {
 		t.Errorf("Must return empty result, returned %v instead", configs)
 	}
 }
+
+func TestPodReadOnlyAccess(t *testing.T) {
+
+	readOnlyKey := "ssh-ed25519 read-only"
+	readWriteKey := "ssh-ed25519 read-write"
+
+	config := types.SshConfig{
+		IngreSshSpec: ingssh.IngreSshSpec{
+			AuthorizedKeys: []ingssh.AuthorizedKey{
+				{Key: readOnlyKey, ReadOnly: true},
+				{Key: readWriteKey},
+			},
+		},
+		Namespace: "authorized-ns1",
+	}
+	kube := clientPodMock{
+		pods: []struct {
+			pod      corev1.Pod
+			selector string
+		}{
+			{pod: corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "name1"}}},
+		},
+		namespaces: []string{"authorized-ns1"},
+	}
+
+	tests := []struct {
+		key         string
+		resourceRO  bool
+		expectRO    bool
+		description string
+	}{
+		{key: readOnlyKey, expectRO: true, description: "read-only key"},
+		{key: readWriteKey, expectRO: false, description: "read-write key"},
+		{key: readWriteKey, resourceRO: true, expectRO: true, description: "read-only resource"},
+	}
+
+	for _, tc := range tests {
+		config.ReadOnly = tc.resourceRO
+		a := GetAuthz([]*types.SshConfig{&config}, tc.key, kube)
+		configs, err := a.GetPods("authorized-ns1", "")
+		if err != nil {
+			t.Errorf("Unexpected error when getting pod: %v", err)
+		}
+		if len(configs) != 1 {
+			t.Fatalf("Should be 1 pod authorized for %s", tc.description)
+		}
+		if configs[0].readOnly != tc.expectRO {
+			t.Errorf("Unexpected read-only access for %s: %v", tc.description, configs[0].readOnly)
+		}
+	}
+}
+
+func TestPodOverlappingReadOnlyAccess(t *testing.T) {
+
+	readOnly := types.SshConfig{
+		IngreSshSpec: ingssh.IngreSshSpec{ReadOnly: true, Aliases: []string{"viewer"}},
+		Namespace:    "authorized-ns1",
+	}
+	readWrite := types.SshConfig{
+		IngreSshSpec: ingssh.IngreSshSpec{Aliases: []string{"operator"}},
+		Namespace:    "authorized-ns1",
+	}
+	readOnlySelected := readOnly
+	readOnlySelected.Selectors = []string{"app=name1"}
+	readWriteSelected := readWrite
+	readWriteSelected.Selectors = []string{"app=name1"}
+	kube := clientPodMock{
+		pods: []struct {
+			pod      corev1.Pod
+			selector string
+		}{
+			{pod: corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "name1"}}, selector: "app=name1"},
+			{pod: corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "name2"}}, selector: "app=name2"},
+		},
+		namespaces: []string{"authorized-ns1"},
+	}
+
+	// The pod authorized by both resources is read-write, whatever the
+	// order of the resources
+	tests := []struct {
+		configs     []*types.SshConfig
+		expectRO    map[string]bool
+		description string
+	}{
+		{
+			configs:     []*types.SshConfig{&readOnly, &readWriteSelected},
+			expectRO:    map[string]bool{"name1": false, "name2": true},
+			description: "read-only namespace first",
+		},
+		{
+			configs:     []*types.SshConfig{&readWriteSelected, &readOnly},
+			expectRO:    map[string]bool{"name1": false, "name2": true},
+			description: "read-write selector first",
+		},
+		{
+			configs:     []*types.SshConfig{&readOnlySelected, &readWrite},
+			expectRO:    map[string]bool{"name1": false, "name2": false},
+			description: "read-only selector first",
+		},
+	}
+
+	for _, tc := range tests {
+		a := GetAuthz(tc.configs, "", kube)
+		configs, err := a.GetPods("authorized-ns1", "")
+		if err != nil {
+			t.Errorf("Unexpected error when getting pods for %s: %v", tc.description, err)
+		}
+		readOnlyPods := map[string]bool{}
+		for _, c := range configs {
+			readOnlyPods[c.pod.Name] = c.readOnly
+			if c.config.IsReadOnly("") != c.readOnly {
+				t.Errorf("Unexpected config of pod %s for %s: %v", c.pod.Name, tc.description, c.config.Aliases)
+			}
+		}
+		if !reflect.DeepEqual(readOnlyPods, tc.expectRO) {
+			t.Errorf("Unexpected read-only access for %s: %v", tc.description, readOnlyPods)
+		}
+	}
+}
+
+func TestRestrictToAlias(t *testing.T) {
+
+	configExec := types.SshConfig{
+		IngreSshSpec: ingssh.IngreSshSpec{Session: "Exec", Aliases: []string{"nginx-exec"}},
+		Name:         "exec",
+		Namespace:    "authorized-ns1",
+	}
+	configDebug := types.SshConfig{
+		IngreSshSpec: ingssh.IngreSshSpec{Session: "Debug", Aliases: []string{"nginx-debug", "debug"}},
+		Name:         "debug",
+		Namespace:    "authorized-ns1",
+	}
+
+	tests := []struct {
+		alias      string
+		restricted bool
+		result     []*types.SshConfig
+	}{
+		{alias: "", restricted: false, result: []*types.SshConfig{&configExec, &configDebug}},
+		{alias: "kooper", restricted: false, result: []*types.SshConfig{&configExec, &configDebug}},
+		{alias: "nginx-exec", restricted: true, result: []*types.SshConfig{&configExec}},
+		{alias: "debug", restricted: true, result: []*types.SshConfig{&configDebug}},
+	}
+
+	for _, tc := range tests {
+		a := GetAuthz([]*types.SshConfig{&configExec, &configDebug}, "", clientNamespacesMock{})
+		if restricted := a.RestrictToAlias(tc.alias); restricted != tc.restricted {
+			t.Errorf("Unexpected restriction result for alias '%s': %v", tc.alias, restricted)
+		}
+		if !reflect.DeepEqual(a.authorizedConfigs, tc.result) {
+			t.Errorf("Unexpected configurations for alias '%s': %v", tc.alias, a.authorizedConfigs)
+		}
+	}
+}
+
+func TestPodReferences(t *testing.T) {
+
+	controlledBy := func(kind string, name string) []metav1.OwnerReference {
+		controller := true
+		return []metav1.OwnerReference{{Kind: kind, Name: name, Controller: &controller}}
+	}
+
+	config := types.SshConfig{Namespace: "authorized-ns1"}
+	kube := clientPodMock{
+		pods: []struct {
+			pod      corev1.Pod
+			selector string
+		}{
+			{pod: corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+				Name:            "api-5d9f7c-x1",
+				OwnerReferences: controlledBy("ReplicaSet", "api-5d9f7c"),
+			}}},
+			{pod: corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+				Name:            "api-5d9f7c-x2",
+				OwnerReferences: controlledBy("ReplicaSet", "api-5d9f7c"),
+			}}},
+			{pod: corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+				Name:            "standalone-rs-y1",
+				OwnerReferences: controlledBy("ReplicaSet", "standalone-rs"),
+			}}},
+			{pod: corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+				Name:            "db-0",
+				OwnerReferences: controlledBy("StatefulSet", "db"),
+			}}},
+			{pod: corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "nginx"}}},
+		},
+		replicaSets: map[string]*metav1.OwnerReference{
+			"api-5d9f7c": &controlledBy("Deployment", "api")[0],
+		},
+		namespaces: []string{"authorized-ns1"},
+	}
+	a := GetAuthz([]*types.SshConfig{&config}, "", kube)
+
+	tests := []struct {
+		hint   string
+		result []string
+	}{
+		{hint: "deploy/api", result: []string{"api-5d9f7c-x1", "api-5d9f7c-x2"}},
+		{hint: "deployment/standalone", result: []string{}},
+		{hint: "rs/standalone-rs", result: []string{"standalone-rs-y1"}},
+		{hint: "sts/db", result: []string{"db-0"}},
+		{hint: "sts/db-0", result: []string{"db-0"}},
+		{hint: "sts/db-1", result: []string{}},
+		{hint: "sts/nginx", result: []string{}},
+		{hint: "job/db", result: []string{}},
+		{hint: "api-*", result: []string{"api-5d9f7c-x1", "api-5d9f7c-x2"}},
+		{hint: "pod/nginx", result: []string{"nginx"}},
+	}
+
+	for _, tc := range tests {
+		configs, err := a.GetPods("authorized-ns1", tc.hint)
+		if err != nil {
+			t.Errorf("Unexpected error when getting pods for '%s': %v", tc.hint, err)
+		}
+		names := []string{}
+		for _, c := range configs {
+			names = append(names, c.pod.Name)
+		}
+		sort.Strings(names)
+		if !reflect.DeepEqual(names, tc.result) {
+			t.Errorf("Unexpected pods for '%s': %v instead of %v", tc.hint, names, tc.result)
+		}
+	}
+}
+
+func TestOrderPods(t *testing.T) {
+
+	runningPod := func(name string, ready bool) corev1.Pod {
+		readyStatus := corev1.ConditionFalse
+		if ready {
+			readyStatus = corev1.ConditionTrue
+		}
+		return corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "ns"},
+			Status: corev1.PodStatus{
+				Phase:      corev1.PodRunning,
+				Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: readyStatus}},
+			},
+		}
+	}
+
+	terminating := runningPod("terminating", true)
+	terminating.DeletionTimestamp = &metav1.Time{}
+	pending := runningPod("pending", false)
+	pending.Status.Phase = corev1.PodPending
+	crashing := runningPod("crashing", false)
+	crashing.Status.ContainerStatuses = []corev1.ContainerStatus{{
+		State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}},
+	}}
+
+	podConfigsWithPolicy := func(policy string) []podSshConfig {
+		config := &types.SshConfig{IngreSshSpec: ingssh.IngreSshSpec{PodSelection: policy}}
+		result := []podSshConfig{}
+		for _, pod := range []corev1.Pod{
+			terminating, pending, crashing,
+			runningPod("b-not-ready", false),
+			runningPod("c-ready", true),
+			runningPod("a-ready", true),
+		} {
+			result = append(result, podSshConfig{pod: pod, config: config})
+		}
+		return result
+	}
+	podNames := func(podConfigs []podSshConfig) []string {
+		names := []string{}
+		for _, p := range podConfigs {
+			names = append(names, p.pod.Name)
+		}
+		return names
+	}
+
+	a := authz{authorizedKey: "ssh-ed25519 key"}
+
+	// Unusable pods are filtered out, the ready ones go first
+	ordered := podNames(a.OrderPods(podConfigsWithPolicy(""), ""))
+	expected := []string{"a-ready", "c-ready", "b-not-ready"}
+	if !reflect.DeepEqual(ordered, expected) {
+		t.Errorf("Unexpected order of pods: %v instead of %v", ordered, expected)
+	}
+
+	// The exactly hinted pod is kept regardless of its state
+	ordered = podNames(a.OrderPods(podConfigsWithPolicy("")[1:2], "pending"))
+	if !reflect.DeepEqual(ordered, []string{"pending"}) {
+		t.Errorf("Hinted pod has been filtered out: %v", ordered)
+	}
+
+	// The same user sticks to the same pod regardless of the pods' order
+	sticky := a.OrderPods(podConfigsWithPolicy(ingssh.PodSelectionSticky), "")
+	reversed := podConfigsWithPolicy(ingssh.PodSelectionSticky)
+	sort.Slice(reversed, func(i, j int) bool { return reversed[i].pod.Name > reversed[j].pod.Name })
+	if a.OrderPods(reversed, "")[0].pod.Name != sticky[0].pod.Name {
+		t.Errorf("Sticky selection depends on the order of pods")
+	}
+
+	// Ready pods with less sessions go first
+	Sessions.Add(&ActiveSession{Target: types.SshTarget{Namespace: "ns", Pod: "a-ready"}, Config: &types.SshConfig{}})
+	defer func() {
+		Sessions = SessionRegistry{sessions: make(map[*ActiveSession]struct{}), lastLogin: make(map[string]time.Time)}
+	}()
+	ordered = podNames(a.OrderPods(podConfigsWithPolicy(ingssh.PodSelectionLeastSessions), ""))
+	expected = []string{"c-ready", "a-ready", "b-not-ready"}
+	if !reflect.DeepEqual(ordered, expected) {
+		t.Errorf("Unexpected order of pods: %v instead of %v", ordered, expected)
+	}
+
+	// The not ready pods go last with any policy
+	for _, policy := range []string{ingssh.PodSelectionRandom, ingssh.PodSelectionSticky, ingssh.PodSelectionLeastSessions} {
+		for i := 0; i < 10; i++ {
+			ordered = podNames(a.OrderPods(podConfigsWithPolicy(policy), ""))
+			if ordered[len(ordered)-1] != "b-not-ready" {
+				t.Errorf("Not ready pod is preferred by the %s policy: %v", policy, ordered)
+			}
+		}
+	}
+}
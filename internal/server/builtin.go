@@ -0,0 +1,172 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/gliderlabs/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"kuberstein.io/ingressh/internal/types"
+)
+
+// builtinCommand is the command of the session served by the server itself
+// rather than run in the target container.
+const builtinCommand = "ingressh"
+
+// aliasPrefix is the prefix of the Host aliases of the targets, so they don't
+// clash with the other hosts of the user's ssh_config.
+const aliasPrefix = "ingressh-"
+
+// isBuiltin returns true if the session runs the built-in command.
+func isBuiltin(sess ssh.Session) bool {
+	command := sess.Command()
+	return len(command) > 0 && command[0] == builtinCommand
+}
+
+// builtin serves the built-in command for the onboarding of the users.
+// Returns the exit code for the session.
+//
+// Supported commands are:
+//
+//	ingressh config [host[:port]]       prints the ssh_config fragment with
+//	                                    a Host alias for every target
+//	ingressh known-hosts [host[:port]]  prints the known_hosts lines of the
+//	                                    host keys
+//
+// The host and the port are the address the users connect to, the public
+// address of the server configuration by default.
+func builtin(sess ssh.Session, targetAuth authz, hint types.SshTarget) int {
+
+	command := sess.Command()
+	if len(command) < 2 || len(command) > 3 {
+		return builtinUsage(sess)
+	}
+	var public string
+	if conf := Config.Load(); conf != nil {
+		public = conf.PublicAddress
+	}
+	host, port, ok := builtinAddress(command, public)
+	if !ok {
+		fmt.Fprintf(sess.Stderr(), "The public address of the server is not configured, pass the address you connect to:\n")
+		return builtinUsage(sess)
+	}
+
+	switch command[1] {
+	case "config":
+		targets, err := targetAuth.ResolveTargets(hint)
+		if err != nil {
+			fmt.Fprintf(sess.Stderr(), "Error: %s\n", err)
+			return 10
+		}
+		entries, err := targetAuth.sshConfigEntries(targets)
+		if err != nil {
+			Logger(sess.Context()).Error(err, "Unable to list the targets")
+			fmt.Fprintf(sess.Stderr(), "Unable to list the targets\n")
+			return 3
+		}
+		Logger(sess.Context()).Info("User requested the ssh_config", "targets", len(entries))
+		writeSshConfig(sess, entries, host, port)
+	case "known-hosts":
+		writeKnownHosts(sess, "", host, port)
+	default:
+		return builtinUsage(sess)
+	}
+	return 0
+}
+
+// builtinAddress returns the host and the port the users connect to: the
+// argument of the command, or the public address. The server never knows the
+// address of the load balancer in front of it, so there is no default.
+func builtinAddress(command []string, public string) (string, string, bool) {
+	address := public
+	if len(command) == 3 {
+		address = command[2]
+	}
+	if address == "" {
+		return "", "", false
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		host, port = address, "22"
+	}
+	return host, port, true
+}
+
+// builtinUsage prints the usage of the built-in command.
+func builtinUsage(sess ssh.Session) int {
+	fmt.Fprintf(sess.Stderr(), "Usage: ssh server %s config|known-hosts [host[:port]]\n", builtinCommand)
+	return 2
+}
+
+// sshConfigEntry is the Host alias of the target in the ssh_config.
+type sshConfigEntry struct {
+	alias string
+	login string
+}
+
+// sshConfigEntries returns the Host aliases of the targets. The pods
+// controlled by the workloads are referenced by the workloads, so the aliases
+// keep working when the pods are replaced, except for the routes in the
+// strict mode, which require the exact pod names.
+func (a authz) sshConfigEntries(targets []resolvedTarget) ([]sshConfigEntry, error) {
+
+	rsControllers := map[string]*metav1.OwnerReference{}
+	logins := map[string]bool{}
+	aliases := map[string]bool{}
+	entries := []sshConfigEntry{}
+
+	for _, t := range targets {
+		target := t.target
+		if !t.podConfig.config.Strict {
+			ref, err := a.workloadRef(t.podConfig.pod, rsControllers)
+			if err != nil {
+				return nil, err
+			}
+			target.Pod = ref
+		}
+		if logins[target.Login()] {
+			continue
+		}
+		logins[target.Login()] = true
+
+		podRef := types.ParsePodRef(target.Pod)
+		alias := aliasPrefix + target.Namespace + "-" + podRef.Name + "-" + target.Container
+		if aliases[alias] {
+			// The workload and the pod of the same name
+			alias += "-" + strings.ToLower(podRef.Kind)
+		}
+		aliases[alias] = true
+		entries = append(entries, sshConfigEntry{alias: alias, login: target.Login()})
+	}
+	return entries, nil
+}
+
+// writeSshConfig writes the ssh_config fragment of the entries, with the
+// known_hosts lines of the host keys in the comments.
+func writeSshConfig(w io.Writer, entries []sshConfigEntry, host string, port string) {
+
+	fmt.Fprintf(w, "# IngreSsh targets, append to ~/.ssh/config\n")
+	fmt.Fprintf(w, "#\n# The host keys of the server, append to ~/.ssh/known_hosts:\n")
+	writeKnownHosts(w, "#   ", host, port)
+	for _, entry := range entries {
+		fmt.Fprintf(w, "\nHost %s\n", entry.alias)
+		fmt.Fprintf(w, "    HostName %s\n", host)
+		if port != "22" {
+			fmt.Fprintf(w, "    Port %s\n", port)
+		}
+		fmt.Fprintf(w, "    User %s\n", entry.login)
+	}
+}
+
+// writeKnownHosts writes the known_hosts lines of the host keys announced to
+// the clients, each line prefixed with the prefix.
+func writeKnownHosts(w io.Writer, prefix string, host string, port string) {
+	address := knownhosts.Normalize(net.JoinHostPort(host, port))
+	for _, key := range HostKeys.publicKeys() {
+		fmt.Fprintf(w, "%s%s\n", prefix, knownhosts.Line([]string{address}, key))
+	}
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	ingssh "kuberstein.io/ingressh/api/v1"
+	"kuberstein.io/ingressh/internal/types"
+)
+
+func TestSshConfigEntries(t *testing.T) {
+
+	controlledBy := func(kind string, name string) []metav1.OwnerReference {
+		controller := true
+		return []metav1.OwnerReference{{Kind: kind, Name: name, Controller: &controller}}
+	}
+	pod := func(name string, owners []metav1.OwnerReference, containers ...string) struct {
+		pod      corev1.Pod
+		selector string
+	} {
+		p := corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "prod", OwnerReferences: owners},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+		}
+		for _, c := range containers {
+			p.Spec.Containers = append(p.Spec.Containers, corev1.Container{Name: c})
+		}
+		return struct {
+			pod      corev1.Pod
+			selector string
+		}{pod: p}
+	}
+
+	kube := clientPodMock{
+		pods: []struct {
+			pod      corev1.Pod
+			selector string
+		}{
+			pod("api-5d9f7c-x1", controlledBy("ReplicaSet", "api-5d9f7c"), "app", "proxy"),
+			pod("api-5d9f7c-x2", controlledBy("ReplicaSet", "api-5d9f7c"), "app", "proxy"),
+			pod("db-0", controlledBy("StatefulSet", "db"), "postgres"),
+			pod("agent-x7k2p", controlledBy("DaemonSet", "agent"), "agent"),
+			pod("api", nil, "app"),
+		},
+		replicaSets: map[string]*metav1.OwnerReference{
+			"api-5d9f7c": &controlledBy("Deployment", "api")[0],
+		},
+		namespaces: []string{"prod"},
+	}
+
+	tests := []struct {
+		name    string
+		config  types.SshConfig
+		entries []sshConfigEntry
+	}{
+		{
+			name:   "workloads",
+			config: types.SshConfig{Namespace: "prod"},
+			entries: []sshConfigEntry{
+				{alias: "ingressh-prod-agent-agent", login: "prod:ds/agent:agent"},
+				{alias: "ingressh-prod-api-app", login: "prod:api:app"},
+				{alias: "ingressh-prod-api-app-deployment", login: "prod:deploy/api:app"},
+				{alias: "ingressh-prod-api-proxy", login: "prod:deploy/api:proxy"},
+				{alias: "ingressh-prod-db-0-postgres", login: "prod:db-0:postgres"},
+			},
+		},
+		{
+			name:   "strict",
+			config: types.SshConfig{Namespace: "prod", IngreSshSpec: ingssh.IngreSshSpec{Strict: true, Containers: []string{"app"}}},
+			entries: []sshConfigEntry{
+				{alias: "ingressh-prod-api-5d9f7c-x1-app", login: "prod:api-5d9f7c-x1:app"},
+				{alias: "ingressh-prod-api-5d9f7c-x2-app", login: "prod:api-5d9f7c-x2:app"},
+				{alias: "ingressh-prod-api-app", login: "prod:api:app"},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			a := GetAuthz([]*types.SshConfig{&tc.config}, "", kube)
+			targets, err := a.ResolveTargets(types.SshTarget{})
+			if err != nil {
+				t.Fatalf("ResolveTargets() error = %v", err)
+			}
+			entries, err := a.sshConfigEntries(targets)
+			if err != nil {
+				t.Fatalf("sshConfigEntries() error = %v", err)
+			}
+			// The pods are ordered by the selection policy
+			sort.Slice(entries, func(i, j int) bool { return entries[i].alias < entries[j].alias })
+			if !reflect.DeepEqual(entries, tc.entries) {
+				t.Errorf("unexpected entries %+v instead of %+v", entries, tc.entries)
+			}
+		})
+	}
+}
+
+func TestWriteSshConfig(t *testing.T) {
+
+	entries := []sshConfigEntry{{alias: "ingressh-prod-api-app", login: "prod:deploy/api:app"}}
+
+	tests := []struct {
+		port     string
+		expected string
+	}{
+		{port: "22", expected: "\nHost ingressh-prod-api-app\n    HostName ssh.example.com\n    User prod:deploy/api:app\n"},
+		{port: "2222", expected: "\nHost ingressh-prod-api-app\n    HostName ssh.example.com\n    Port 2222\n    User prod:deploy/api:app\n"},
+	}
+
+	for _, tc := range tests {
+		var out strings.Builder
+		writeSshConfig(&out, entries, "ssh.example.com", tc.port)
+		if !strings.HasSuffix(out.String(), tc.expected) {
+			t.Errorf("unexpected ssh_config for port %s:\n%s", tc.port, out.String())
+		}
+	}
+}
+
+func TestBuiltinAddress(t *testing.T) {
+
+	tests := []struct {
+		command []string
+		public  string
+		host    string
+		port    string
+		ok      bool
+	}{
+		{[]string{"ingressh", "config"}, "", "", "", false},
+		{[]string{"ingressh", "config"}, "ssh.example.com", "ssh.example.com", "22", true},
+		{[]string{"ingressh", "config"}, "ssh.example.com:2222", "ssh.example.com", "2222", true},
+		{[]string{"ingressh", "config", "other.example.com:2200"}, "ssh.example.com", "other.example.com", "2200", true},
+		{[]string{"ingressh", "known-hosts", "[::1]:2222"}, "", "::1", "2222", true},
+	}
+
+	for _, tt := range tests {
+		host, port, ok := builtinAddress(tt.command, tt.public)
+		if host != tt.host || port != tt.port || ok != tt.ok {
+			t.Errorf("builtinAddress(%q, %q) = %q, %q, %v", tt.command, tt.public, host, port, ok)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+
+	"kuberstein.io/ingressh/internal/types"
+)
+
+// ConfigStore holds the configuration of the server, replaced when the
+// configuration file is reloaded. The sessions read the configuration once
+// they start, so the running sessions keep the configuration they started
+// with.
+type ConfigStore struct {
+	current atomic.Pointer[types.ServerConfig]
+}
+
+// Config is the configuration of the server. It is nil until it is applied.
+var Config ConfigStore
+
+// Load returns the current configuration.
+func (s *ConfigStore) Load() *types.ServerConfig {
+	return s.current.Load()
+}
+
+// Apply makes the configuration current, applying the host keys, the crypto
+// policy, the limits, the guard and the source address lists to the new
+// connections and sessions. The fields which require the restart of the
+// server are logged if they change.
+func (s *ConfigStore) Apply(conf *types.ServerConfig) error {
+
+	if err := HostKeys.Load(conf.HostKeys, conf.Crypto.Algorithms().HostKeyAlgorithms); err != nil {
+		return err
+	}
+	if err := Guard.SetConfig(conf.Guard); err != nil {
+		return fmt.Errorf("unable to configure the connection guard: %w", err)
+	}
+	if err := Sources.SetLists(conf.Listener.SourceAllowList, conf.Listener.SourceDenyList); err != nil {
+		return fmt.Errorf("unable to configure the source addresses: %w", err)
+	}
+	Sessions.SetLimits(conf.Limits)
+
+	if prev := s.current.Swap(conf); prev != nil {
+		if fields := prev.RestartRequired(conf); len(fields) > 0 {
+			ctrllog.Log.WithName("config").Info("The changed fields are applied on the restart of the server",
+				"fields", fields)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,210 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gliderlabs/ssh"
+	"github.com/go-logr/logr"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+
+	"kuberstein.io/ingressh/internal/audit"
+	"kuberstein.io/ingressh/internal/metrics"
+)
+
+var (
+	ctxKeySessionID   = &contextKey{"session_id"}
+	ctxKeyConnContext = &contextKey{"conn_context"}
+	ctxKeyHandshake   = &contextKey{"handshake"}
+)
+
+// ConnHandler identifies the accepted connection with a unique session ID,
+// and starts its trace and logger. The session ID correlates the logs, the
+// audit events, the debug containers and the recordings of the connection.
+//
+// The connection is closed right away if the source IP is blocked by the
+// guard, or if the limit of the handshakes in flight is reached, and when
+// the user is not authenticated within the handshake timeout.
+func ConnHandler(ctx ssh.Context, conn net.Conn) net.Conn {
+
+	id := newSessionID()
+	ctx.SetValue(ctxKeySessionID, id)
+
+	logger := ctrllog.Log.WithName("ssh").WithValues("session", id, "sourceIP", sourceIP(conn.RemoteAddr()))
+	ip := sourceAddr(conn.RemoteAddr())
+
+	if err := Guard.Allow(ip); err != nil {
+		logger.V(1).Info("Connection rejected", "reason", err.Error())
+		metrics.Connections.WithLabelValues(metrics.ResultRejected).Inc()
+		return nil
+	}
+
+	if !Sessions.StartHandshake() {
+		logger.Info("Connection rejected: too many handshakes in flight")
+		rejected := audit.Event{
+			Type:      audit.EventLimitExceeded,
+			SessionID: id,
+			SourceIP:  sourceIP(conn.RemoteAddr()),
+			Reason:    "too many handshakes in flight",
+		}
+		audit.Log(rejected)
+		metrics.Connections.WithLabelValues(metrics.ResultRejected).Inc()
+		return nil
+	}
+	h := &handshake{ip: ip, conn: conn}
+	if conf := Config.Load(); conf != nil && conf.Listener.HandshakeTimeout.Duration > 0 {
+		h.deadline = time.Now().Add(conf.Listener.HandshakeTimeout.Duration)
+		conn.SetDeadline(h.deadline)
+	}
+	ctx.SetValue(ctxKeyHandshake, h)
+	ctx.SetValue(ctxKeyHostKeysAnnounced, &sync.Once{})
+
+	traceCtx, t := startConnTrace(id, conn)
+	ctx.SetValue(ctxKeyConnTrace, t)
+	ctx.SetValue(ctxKeyConnContext, logr.NewContext(traceCtx, logger))
+
+	k := &kexInit{logger: logger}
+	ctx.SetValue(ctxKeyKexInit, k)
+
+	return &handshakeConn{Conn: &tracedConn{Conn: &kexInitConn{Conn: conn, kexInit: k}, trace: t}, handshake: h}
+}
+
+// handshake holds the place of the connection among the handshakes in
+// flight until the user is authenticated or the connection is closed. The
+// connection closed after the failed authentication attempts is reported to
+// the guard.
+//
+// Until the handshake ends, the deadline of the connection is never later
+// than the handshake deadline, whatever the connection timeouts set.
+type handshake struct {
+	ip     netip.Addr
+	failed atomic.Bool
+	once   sync.Once
+
+	conn     net.Conn
+	deadline time.Time
+	// requested is the deadline set by the SSH library, restored once the
+	// handshake ends
+	requested time.Time
+	ended     bool
+	mutex     sync.Mutex
+}
+
+// end releases the place of the handshake, and reports the result to the
+// guard.
+func (h *handshake) end(authenticated bool) {
+	h.once.Do(func() {
+		h.mutex.Lock()
+		h.ended = true
+		if !h.deadline.IsZero() {
+			h.conn.SetDeadline(h.requested)
+		}
+		h.mutex.Unlock()
+
+		Sessions.EndHandshake()
+		if authenticated {
+			metrics.Connections.WithLabelValues(metrics.ResultAccepted).Inc()
+			Guard.Success(h.ip)
+		} else if h.failed.Load() {
+			Guard.Failure(h.ip)
+		}
+	})
+}
+
+// setDeadline sets the deadline of the connection, limited by the handshake
+// deadline until the handshake ends.
+func (h *handshake) setDeadline(t time.Time) error {
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.requested = t
+	if !h.ended && !h.deadline.IsZero() && (t.IsZero() || t.After(h.deadline)) {
+		t = h.deadline
+	}
+	return h.conn.SetDeadline(t)
+}
+
+// handshakeConn ends the handshake when the connection is closed.
+type handshakeConn struct {
+	net.Conn
+	handshake *handshake
+}
+
+func (c *handshakeConn) Close() error {
+	c.handshake.end(false)
+	return c.Conn.Close()
+}
+
+// SetDeadline is used by the SSH library for the connection timeouts.
+func (c *handshakeConn) SetDeadline(t time.Time) error {
+	return c.handshake.setDeadline(t)
+}
+
+// handshakeDone ends the handshake of the connection once the user is
+// authenticated: logs the authentication, ends its span, releases its
+// handshake slot and clears the handshake deadline. The channels and the
+// requests of the connection are handled only after the signature of the
+// user is verified, so the handlers call it first.
+func handshakeDone(ctx ssh.Context) {
+	authenticated(ctx)
+	if t, ok := ctx.Value(ctxKeyConnTrace).(*connTrace); ok {
+		t.endHandshake(nil)
+	}
+	if h, ok := ctx.Value(ctxKeyHandshake).(*handshake); ok {
+		h.end(true)
+	}
+}
+
+// authFailed marks the handshake of the connection as failed, so the guard
+// accounts the failure if the connection is closed without authentication.
+func authFailed(ctx ssh.Context) {
+	if h, ok := ctx.Value(ctxKeyHandshake).(*handshake); ok {
+		h.failed.Store(true)
+	}
+}
+
+// newSessionID returns a random ID of 16 hex digits.
+func newSessionID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// GetSessionIDFromCtx returns the ID assigned to the connection, or the SSH
+// session ID if the connection has not been identified.
+func GetSessionIDFromCtx(ctx ssh.Context) string {
+	if id, ok := ctx.Value(ctxKeySessionID).(string); ok {
+		return id
+	}
+	return ctx.SessionID()
+}
+
+// connContext returns the context of the connection carrying its logger and
+// span, so the logs and the spans of the connection are correlated.
+func connContext(ctx ssh.Context) context.Context {
+	if c, ok := ctx.Value(ctxKeyConnContext).(context.Context); ok {
+		return c
+	}
+	logger := ctrllog.Log.WithName("ssh").WithValues("session", GetSessionIDFromCtx(ctx))
+	return logr.NewContext(context.Background(), logger)
+}
+
+// Logger returns the logger of the connection.
+func Logger(ctx ssh.Context) logr.Logger {
+	return logr.FromContextOrDiscard(connContext(ctx))
+}
+
+// setLogger replaces the logger of the connection, f.e. to add the name of
+// the authenticated user to the log lines.
+func setLogger(ctx ssh.Context, logger logr.Logger) {
+	ctx.SetValue(ctxKeyConnContext, logr.NewContext(connContext(ctx), logger))
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"encoding/hex"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestNewSessionID(t *testing.T) {
+
+	seen := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		id := newSessionID()
+		if len(id) != 16 {
+			t.Fatalf("newSessionID() = %q, expected 16 hex digits", id)
+		}
+		if _, err := hex.DecodeString(id); err != nil {
+			t.Fatalf("newSessionID() = %q, expected hex digits: %v", id, err)
+		}
+		if seen[id] {
+			t.Fatalf("newSessionID() = %q, expected unique IDs", id)
+		}
+		seen[id] = true
+	}
+}
+
+// deadlineConn records the deadline set on the connection.
+type deadlineConn struct {
+	net.Conn
+	deadline time.Time
+}
+
+func (c *deadlineConn) SetDeadline(t time.Time) error {
+	c.deadline = t
+	return nil
+}
+
+func TestHandshakeDeadline(t *testing.T) {
+
+	conn := &deadlineConn{}
+	deadline := time.Now().Add(time.Minute)
+	h := &handshake{ip: netip.MustParseAddr("10.0.0.7"), conn: conn, deadline: deadline}
+	c := &handshakeConn{Conn: conn, handshake: h}
+
+	tests := []struct {
+		name      string
+		requested time.Time
+		expected  time.Time
+	}{
+		{"no deadline", time.Time{}, deadline},
+		{"later", deadline.Add(time.Hour), deadline},
+		{"earlier", deadline.Add(-time.Second), deadline.Add(-time.Second)},
+	}
+	for _, tt := range tests {
+		c.SetDeadline(tt.requested)
+		if !conn.deadline.Equal(tt.expected) {
+			t.Errorf("%s: expected the deadline %v, got %v", tt.name, tt.expected, conn.deadline)
+		}
+	}
+
+	// The deadline of the library is restored once authenticated
+	later := deadline.Add(time.Hour)
+	c.SetDeadline(later)
+	if !Sessions.StartHandshake() {
+		t.Fatal("expected the handshake to start")
+	}
+	h.end(true)
+	if !conn.deadline.Equal(later) {
+		t.Errorf("expected the deadline %v after the handshake, got %v", later, conn.deadline)
+	}
+	c.SetDeadline(time.Time{})
+	if !conn.deadline.IsZero() {
+		t.Errorf("expected no deadline after the handshake, got %v", conn.deadline)
+	}
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"slices"
+
+	"github.com/gliderlabs/ssh"
+	gossh "golang.org/x/crypto/ssh"
+
+	"kuberstein.io/ingressh/internal/types"
+)
+
+// cryptoAlgorithms returns the algorithms allowed by the current
+// configuration, or the ones of the compatible profile before the
+// configuration is applied.
+func cryptoAlgorithms() types.CryptoAlgorithms {
+	if conf := Config.Load(); conf != nil {
+		return conf.Crypto.Algorithms()
+	}
+	return types.CryptoConfig{Profile: types.CryptoProfileCompatible}.Algorithms()
+}
+
+// ServerConfigCallback returns the configuration of the SSH transport of the
+// new connection, restricted to the algorithms of the crypto policy, so the
+// changes of the policy apply to the new connections.
+//
+// The public key algorithms are listed in the server-sig-algs extension: the
+// clients sign with the RSA keys using SHA-2 only if the server lists
+// rsa-sha2-256 and rsa-sha2-512, and OpenSSH 8.8 and later refuse to sign
+// with SHA-1. The library refuses the signatures of the other algorithms.
+func ServerConfigCallback(ctx ssh.Context) *gossh.ServerConfig {
+
+	algorithms := cryptoAlgorithms()
+	if k, ok := ctx.Value(ctxKeyKexInit).(*kexInit); ok {
+		k.setServer(algorithms, HostKeys.hostKeyAlgorithms())
+	}
+	return &gossh.ServerConfig{
+		Config: gossh.Config{
+			KeyExchanges: algorithms.KeyExchanges,
+			Ciphers:      algorithms.Ciphers,
+			MACs:         algorithms.MACs,
+		},
+		PublicKeyAuthAlgorithms: algorithms.PublicKeyAlgorithms,
+	}
+}
+
+// allowsPublicKey returns true if the algorithms allow the key of the user.
+// The RSA keys are allowed with any of the signature algorithms, as the
+// algorithm of the signature is not known to the callbacks: the library
+// checks it with the PublicKeyAuthAlgorithms.
+func allowsPublicKey(algorithms []string, key gossh.PublicKey) bool {
+	if key.Type() == gossh.KeyAlgoRSA {
+		return slices.Contains(algorithms, gossh.KeyAlgoRSASHA512) ||
+			slices.Contains(algorithms, gossh.KeyAlgoRSASHA256) ||
+			slices.Contains(algorithms, gossh.KeyAlgoRSA)
+	}
+	return slices.Contains(algorithms, key.Type())
+}
+
+// rsaAlgorithms are the host key algorithms of the RSA keys and of their
+// certificates, in the order the library offers them. The signature
+// algorithms of both are the ones of the keys.
+var rsaAlgorithms = map[string][]string{
+	gossh.KeyAlgoRSA:     {gossh.KeyAlgoRSASHA256, gossh.KeyAlgoRSASHA512, gossh.KeyAlgoRSA},
+	gossh.CertAlgoRSAv01: {gossh.CertAlgoRSASHA256v01, gossh.CertAlgoRSASHA512v01, gossh.CertAlgoRSAv01},
+}
+
+// allowedHostKey returns the host key restricted to the algorithms, or nil if
+// none of its algorithms is allowed. The library offers all the algorithms
+// of the RSA keys, so the RSA keys are restricted to the allowed ones, and
+// the old clients negotiate another algorithm rather than SHA-1.
+func allowedHostKey(algorithms []string, signer gossh.Signer) gossh.Signer {
+
+	rsa, ok := rsaAlgorithms[signer.PublicKey().Type()]
+	if !ok {
+		if !slices.Contains(algorithms, signer.PublicKey().Type()) {
+			return nil
+		}
+		return signer
+	}
+
+	var allowed []string
+	for i, algorithm := range rsa {
+		if slices.Contains(algorithms, algorithm) {
+			allowed = append(allowed, rsaAlgorithms[gossh.KeyAlgoRSA][i])
+		}
+	}
+	if len(allowed) == len(rsa) {
+		return signer
+	}
+	as, ok := signer.(gossh.AlgorithmSigner)
+	if !ok || len(allowed) == 0 {
+		return nil
+	}
+	restricted, err := gossh.NewSignerWithAlgorithms(as, allowed)
+	if err != nil {
+		return nil
+	}
+	return restricted
+}
+
+// signerAlgorithms returns the host key algorithms the library offers for the
+// signer.
+func signerAlgorithms(signer gossh.Signer) []string {
+
+	keyType := signer.PublicKey().Type()
+	rsa, ok := rsaAlgorithms[keyType]
+	if !ok {
+		return []string{keyType}
+	}
+	switch s := signer.(type) {
+	case gossh.MultiAlgorithmSigner:
+		var algorithms []string
+		for i, algorithm := range rsa {
+			if slices.Contains(s.Algorithms(), rsaAlgorithms[gossh.KeyAlgoRSA][i]) {
+				algorithms = append(algorithms, algorithm)
+			}
+		}
+		return algorithms
+	case gossh.AlgorithmSigner:
+		return rsa
+	}
+	return []string{keyType}
+}
@@ -0,0 +1,201 @@
+package server
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/binary"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	gossh "golang.org/x/crypto/ssh"
+
+	"kuberstein.io/ingressh/internal/types"
+)
+
+func TestAllowedHostKey(t *testing.T) {
+
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	rsaSigner, _ := gossh.NewSignerFromKey(rsaKey)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	edSigner, _ := gossh.NewSignerFromKey(edKey)
+
+	fips := types.CryptoConfig{Profile: types.CryptoProfileFips}.Algorithms().HostKeyAlgorithms
+	compatible := types.CryptoConfig{Profile: types.CryptoProfileCompatible}.Algorithms().HostKeyAlgorithms
+
+	if allowedHostKey(fips, edSigner) != nil {
+		t.Errorf("expected the Ed25519 key not to be allowed by the fips profile")
+	}
+	if allowedHostKey(compatible, rsaSigner) != rsaSigner {
+		t.Errorf("expected the RSA key to be used as it is by the compatible profile")
+	}
+	if allowedHostKey([]string{"ssh-ed25519"}, rsaSigner) != nil {
+		t.Errorf("expected the RSA key not to be allowed without the RSA algorithms")
+	}
+
+	signer, ok := allowedHostKey(fips, rsaSigner).(gossh.AlgorithmSigner)
+	if !ok {
+		t.Fatalf("expected the RSA key to be allowed by the fips profile")
+	}
+	expected := []string{gossh.KeyAlgoRSASHA256, gossh.KeyAlgoRSASHA512}
+	if algorithms := signerAlgorithms(signer); !reflect.DeepEqual(algorithms, expected) {
+		t.Errorf("expected the RSA key to be offered with %v, got %v", expected, algorithms)
+	}
+	if algorithms := signerAlgorithms(rsaSigner); len(algorithms) != 3 {
+		t.Errorf("expected the RSA key to be offered with all the algorithms, got %v", algorithms)
+	}
+	if _, err := signer.SignWithAlgorithm(rand.Reader, []byte("data"), gossh.KeyAlgoRSA); err == nil {
+		t.Errorf("expected the ssh-rsa signature to be refused")
+	}
+	sig, err := signer.SignWithAlgorithm(rand.Reader, []byte("data"), gossh.KeyAlgoRSASHA512)
+	if err != nil {
+		t.Fatalf("SignWithAlgorithm() error = %v", err)
+	}
+	if err := rsaSigner.PublicKey().Verify([]byte("data"), sig); err != nil {
+		t.Errorf("expected a valid rsa-sha2-512 signature, got %v", err)
+	}
+}
+
+func TestAllowsPublicKey(t *testing.T) {
+
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	rsaPub, _ := gossh.NewPublicKey(&rsaKey.PublicKey)
+	edPub, _, _ := ed25519.GenerateKey(rand.Reader)
+	edKey, _ := gossh.NewPublicKey(edPub)
+
+	fips := types.CryptoConfig{Profile: types.CryptoProfileFips}.Algorithms().PublicKeyAlgorithms
+	if !allowsPublicKey(fips, rsaPub) {
+		t.Errorf("expected the RSA key to be allowed with rsa-sha2-*")
+	}
+	if allowsPublicKey(fips, edKey) {
+		t.Errorf("expected the Ed25519 key not to be allowed by the fips profile")
+	}
+	if allowsPublicKey([]string{"ssh-ed25519"}, rsaPub) {
+		t.Errorf("expected the RSA key not to be allowed without the RSA algorithms")
+	}
+}
+
+func TestPublicKeyAuthAlgorithms(t *testing.T) {
+
+	prev := Config.Load()
+	Config.current.Store(&types.ServerConfig{Crypto: types.CryptoConfig{Profile: types.CryptoProfileModern}})
+	t.Cleanup(func() { Config.current.Store(prev) })
+
+	_, hostKey, _ := ed25519.GenerateKey(rand.Reader)
+	hostSigner, _ := gossh.NewSignerFromKey(hostKey)
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	rsaSigner, _ := gossh.NewSignerFromKey(rsaKey)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			config := ServerConfigCallback(newTestContext())
+			config.AddHostKey(hostSigner)
+			config.PublicKeyCallback = func(conn gossh.ConnMetadata, key gossh.PublicKey) (*gossh.Permissions, error) {
+				return nil, nil
+			}
+			go func() {
+				defer conn.Close()
+				if sconn, _, _, err := gossh.NewServerConn(conn, config); err == nil {
+					sconn.Close()
+				}
+			}()
+		}
+	}()
+
+	// The RSA key signs with the algorithms the server lists, and SHA-1 is
+	// refused by the modern profile
+	tests := []struct {
+		algorithm string
+		accepted  bool
+	}{
+		{gossh.KeyAlgoRSASHA512, true},
+		{gossh.KeyAlgoRSASHA256, true},
+		{gossh.KeyAlgoRSA, false},
+	}
+	for _, tt := range tests {
+		signer, err := gossh.NewSignerWithAlgorithms(rsaSigner.(gossh.AlgorithmSigner), []string{tt.algorithm})
+		if err != nil {
+			t.Fatal(err)
+		}
+		client, err := gossh.Dial("tcp", ln.Addr().String(), &gossh.ClientConfig{
+			User:            "user",
+			Auth:            []gossh.AuthMethod{gossh.PublicKeys(signer)},
+			HostKeyCallback: gossh.InsecureIgnoreHostKey(),
+			Timeout:         5 * time.Second,
+		})
+		if err == nil {
+			client.Close()
+		}
+		if (err == nil) != tt.accepted {
+			t.Errorf("expected the %s signature to be accepted %v, got %v", tt.algorithm, tt.accepted, err)
+		}
+	}
+}
+
+func TestParseKexInit(t *testing.T) {
+
+	lists := []string{
+		"sntrup761x25519-sha512@openssh.com,curve25519-sha256@libssh.org,ext-info-c",
+		"ssh-ed25519,rsa-sha2-512",
+		"chacha20-poly1305@openssh.com,aes256-ctr", "aes256-ctr",
+		"hmac-sha2-256-etm@openssh.com", "hmac-sha2-256-etm@openssh.com",
+		"none", "none", "", "",
+	}
+	payload := append([]byte{msgKexInit}, make([]byte, 16)...)
+	for _, list := range lists {
+		payload = appendString(payload, []byte(list))
+	}
+	payload = append(payload, 0, 0, 0, 0, 0)
+	padding := 8 - (len(payload)+5)%8 + 4
+	packet := binary.BigEndian.AppendUint32(nil, uint32(1+len(payload)+padding))
+	packet = append(packet, byte(padding))
+	packet = append(packet, payload...)
+	packet = append(packet, make([]byte, padding)...)
+	data := append([]byte("SSH-2.0-OpenSSH_9.6\r\n"), packet...)
+
+	if _, _, ok := parseKexInit(data[:len(data)-1]); ok {
+		t.Errorf("expected the incomplete packet not to be parsed")
+	}
+	version, algorithms, ok := parseKexInit(data)
+	if !ok {
+		t.Fatalf("expected the packet to be parsed")
+	}
+	if version != "SSH-2.0-OpenSSH_9.6" {
+		t.Errorf("unexpected version %q", version)
+	}
+	expected := types.CryptoAlgorithms{
+		KeyExchanges:      strings.Split(lists[0], ","),
+		HostKeyAlgorithms: strings.Split(lists[1], ","),
+		Ciphers:           strings.Split(lists[2], ","),
+		MACs:              strings.Split(lists[4], ","),
+	}
+	if !reflect.DeepEqual(algorithms, expected) {
+		t.Errorf("unexpected algorithms %+v", algorithms)
+	}
+
+	server := types.CryptoConfig{Profile: types.CryptoProfileCompatible}.Algorithms()
+	if kex, _ := findCommon(algorithms.KeyExchanges, server.KeyExchanges); kex != "curve25519-sha256@libssh.org" {
+		t.Errorf("expected the first key exchange of the client the server supports, got %q", kex)
+	}
+	// The negotiated host key algorithm is kept for the connection
+	k := &kexInit{logger: logr.Discard()}
+	k.setServer(server, []string{"rsa-sha2-256", "rsa-sha2-512", "ssh-rsa"})
+	k.read(data[:10])
+	k.read(data[10:])
+	if hostKey := k.hostKeyAlgorithm(); hostKey != "rsa-sha2-512" {
+		t.Errorf("expected the rsa-sha2-512 host key algorithm, got %q", hostKey)
+	}
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+
+	ing "kuberstein.io/ingressh/api/v1"
+	"kuberstein.io/ingressh/internal/types"
+)
+
+// Reasons of the Kubernetes events emitted by the server
+const (
+	ReasonSessionStarted        = "SessionStarted"
+	ReasonSessionEnded          = "SessionEnded"
+	ReasonDebugContainerCreated = "DebugContainerCreated"
+	ReasonAuthorizationDenied   = "AuthorizationDenied"
+)
+
+// Rate limits of the events for the same object, reason and user: a burst
+// of events is let through, then the events are dropped until the tokens
+// are refilled.
+const (
+	eventBurst    = 5
+	eventInterval = 30 * time.Second
+	// eventLimitersMax triggers cleanup of the limiters of the idle users.
+	eventLimitersMax = 1000
+)
+
+// EventRecorder emits Kubernetes events on the target pods and the IngreSsh
+// resources granting the access, so `kubectl describe` shows who accessed
+// the pod and when. The events are rate limited, so scripts opening many
+// sessions don't spam them.
+type EventRecorder struct {
+	recorder record.EventRecorder
+	limiters map[string]*rate.Limiter
+	mutex    sync.Mutex
+}
+
+// Events emits the events of the sessions. No events are emitted until the
+// recorder is set.
+var Events = EventRecorder{
+	limiters: make(map[string]*rate.Limiter),
+}
+
+// SetRecorder sets the recorder of the events, f.e. the one of the manager.
+func (r *EventRecorder) SetRecorder(recorder record.EventRecorder) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.recorder = recorder
+}
+
+// Emit records the event on the pod and on the IngreSsh resource of the
+// config. Either of them may be nil. The message is prefixed with the user
+// name.
+func (r *EventRecorder) Emit(pod *corev1.Pod, config *types.SshConfig, user string,
+	eventType string, reason string, messageFmt string, args ...interface{},
+) {
+
+	message := fmt.Sprintf("User %s: %s", user, fmt.Sprintf(messageFmt, args...))
+
+	var objects []runtime.Object
+	if pod != nil {
+		objects = append(objects, pod)
+	}
+	if config != nil {
+		objects = append(objects, &ing.IngreSsh{ObjectMeta: metav1.ObjectMeta{
+			Name:      config.Name,
+			Namespace: config.Namespace,
+			UID:       config.UID,
+		}})
+	}
+
+	for _, obj := range objects {
+		meta, ok := obj.(metav1.Object)
+		if !ok {
+			continue
+		}
+		recorder := r.allow(string(meta.GetUID()) + "/" + reason + "/" + user)
+		if recorder != nil {
+			recorder.Event(obj, eventType, reason, message)
+		}
+	}
+}
+
+// allow returns the recorder if the event with the key is within the rate
+// limits, or nil otherwise.
+func (r *EventRecorder) allow(key string) record.EventRecorder {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.recorder == nil {
+		return nil
+	}
+
+	limiter, ok := r.limiters[key]
+	if !ok {
+		if len(r.limiters) >= eventLimitersMax {
+			r.cleanup()
+		}
+		limiter = rate.NewLimiter(rate.Every(eventInterval), eventBurst)
+		r.limiters[key] = limiter
+	}
+	if !limiter.Allow() {
+		return nil
+	}
+	return r.recorder
+}
+
+// cleanup forgets the limiters that are refilled, as they behave the same
+// as the new ones.
+func (r *EventRecorder) cleanup() {
+	for key, limiter := range r.limiters {
+		if limiter.Tokens() >= eventBurst {
+			delete(r.limiters, key)
+		}
+	}
+}
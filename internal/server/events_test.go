@@ -0,0 +1,51 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/time/rate"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+
+	"kuberstein.io/ingressh/internal/types"
+)
+
+func TestEventRecorder(t *testing.T) {
+
+	fake := record.NewFakeRecorder(100)
+	r := EventRecorder{limiters: make(map[string]*rate.Limiter)}
+
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "ns", UID: "pod-uid"}}
+	config := &types.SshConfig{Name: "route", Namespace: "ns", UID: "route-uid"}
+
+	// No events until the recorder is set
+	r.Emit(pod, config, "alice", corev1.EventTypeNormal, ReasonSessionStarted, "started")
+	if len(fake.Events) != 0 {
+		t.Fatalf("expected no events without recorder")
+	}
+
+	r.SetRecorder(fake)
+	for i := 0; i < eventBurst+3; i++ {
+		r.Emit(pod, config, "alice", corev1.EventTypeNormal, ReasonSessionStarted, "started %d", i)
+	}
+	// The other user is limited separately
+	r.Emit(pod, nil, "bob", corev1.EventTypeWarning, ReasonAuthorizationDenied, "denied")
+
+	events := []string{}
+	for len(fake.Events) > 0 {
+		events = append(events, <-fake.Events)
+	}
+
+	// The burst for both the pod and the route, and the event of the other user
+	if len(events) != 2*eventBurst+1 {
+		t.Fatalf("expected %d events, got %d: %v", 2*eventBurst+1, len(events), events)
+	}
+	if events[0] != "Normal SessionStarted User alice: started 0" {
+		t.Errorf("unexpected event %q", events[0])
+	}
+	if !strings.HasPrefix(events[len(events)-1], "Warning AuthorizationDenied User bob") {
+		t.Errorf("unexpected event %q", events[len(events)-1])
+	}
+}
@@ -0,0 +1,290 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/gliderlabs/ssh"
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/sync/errgroup"
+	corev1 "k8s.io/api/core/v1"
+
+	"kuberstein.io/ingressh/internal/audit"
+	"kuberstein.io/ingressh/internal/k8s"
+	"kuberstein.io/ingressh/internal/recording"
+	"kuberstein.io/ingressh/internal/types"
+)
+
+// prefixWriter writes the output line by line, prefixing each line. The
+// writers of the concurrent commands share the mutex, so the lines of
+// different commands are never mixed up, and the writer could be used by
+// the session watchdog while the command writes to it.
+type prefixWriter struct {
+	prefix string
+	out    io.Writer
+	mutex  *sync.Mutex
+	buf    []byte
+}
+
+func (w *prefixWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.buf = append(w.buf, p...)
+	for {
+		idx := bytes.IndexByte(w.buf, '\n')
+		if idx < 0 {
+			return len(p), nil
+		}
+		if err := w.writeLine(w.buf[:idx+1]); err != nil {
+			return len(p), err
+		}
+		w.buf = w.buf[idx+1:]
+	}
+}
+
+// Flush writes the last incomplete line, if any.
+func (w *prefixWriter) Flush() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if len(w.buf) == 0 {
+		return nil
+	}
+	err := w.writeLine(append(w.buf, '\n'))
+	w.buf = nil
+	return err
+}
+
+// writeLine writes the line with the prefix. The caller must hold the lock.
+func (w *prefixWriter) writeLine(line []byte) error {
+	_, err := fmt.Fprintf(w.out, "%s: %s", w.prefix, line)
+	return err
+}
+
+// errTerminated is the error of the fan-out targets terminated by deleting
+// their session resources.
+var errTerminated = errors.New("terminated by the administrator")
+
+// fanOutResult is the outcome of the command for a single target.
+type fanOutResult struct {
+	target   types.SshTarget
+	exitCode int
+	err      error
+}
+
+// fanOut runs the session command on all the targets matching the hint,
+// concurrently but with the parallelism limit of the server configuration.
+// Output lines are prefixed with pod/container, and the exit codes of the
+// targets are summarized at the end.
+//
+// The command output of the targets of the routes with the recording
+// enabled is recorded for every target separately, and the fan-out is
+// refused if the recording sink is not configured.
+//
+// Returns the exit code for the session: non-zero if the command failed for
+// any of the targets. The spans of the targets are the children of the span
+// of ctx.
+func fanOut(ctx context.Context, sess ssh.Session, kube *k8s.ClientImpl, conf *types.ServerConfig,
+	recordings recording.Sink, targetAuth authz, hint types.SshTarget,
+) int {
+
+	if len(sess.Command()) == 0 {
+		fmt.Fprintf(sess.Stderr(), "Command is required to run on all the targets\n")
+		return 2
+	}
+
+	targets, err := targetAuth.ResolveTargets(hint)
+	if err != nil {
+		fmt.Fprintf(sess.Stderr(), "Error: %s\n", err)
+		return 10
+	}
+	if len(targets) == 0 {
+		fmt.Fprintf(sess.Stderr(), "No container selected\n")
+		return 13
+	}
+
+	for _, t := range targets {
+		if t.podConfig.config.Record && recordings == nil {
+			Logger(sess.Context()).Error(nil, "Recording is enabled, but the recording sink is not configured",
+				"route", t.podConfig.config.Route())
+			fmt.Fprintf(sess.Stderr(), "Session recording is not available\n")
+			return 4
+		}
+	}
+
+	Logger(sess.Context()).Info("Executing the command on the targets", "command", sess.Command(), "targets", len(targets))
+
+	var mutex sync.Mutex
+	results := make([]fanOutResult, len(targets))
+
+	eg := errgroup.Group{}
+	eg.SetLimit(conf.FanOutParallelism)
+	for i, t := range targets {
+		results[i].target = t.target
+		id := fmt.Sprintf("%s-%d", GetSessionIDFromCtx(sess.Context()), i+1)
+		eg.Go(func() error {
+			results[i].exitCode, results[i].err = fanOutTarget(ctx, sess, kube, conf, recordings, t, id, &mutex)
+			return nil
+		})
+	}
+	eg.Wait()
+
+	exitCode := 0
+	fmt.Fprintf(sess.Stderr(), "\nSummary:\n")
+	for _, r := range results {
+		switch {
+		case r.err != nil:
+			Logger(sess.Context()).Error(r.err, "Command failed", "pod", r.target.Pod, "container", r.target.Container)
+			fmt.Fprintf(sess.Stderr(), "  %s/%s/%s: error: %s\n",
+				r.target.Namespace, r.target.Pod, r.target.Container, r.err)
+			exitCode = 1
+		default:
+			fmt.Fprintf(sess.Stderr(), "  %s/%s/%s: exit %d\n",
+				r.target.Namespace, r.target.Pod, r.target.Container, r.exitCode)
+			if r.exitCode != 0 {
+				exitCode = 1
+			}
+		}
+	}
+	return exitCode
+}
+
+// fanOutTarget runs the session command on a single target, the same way
+// as the regular session would do, but without the terminal and input. The
+// session of the target is identified by id, the session ID of the
+// connection suffixed with the index of the target.
+func fanOutTarget(ctx context.Context, sess ssh.Session, kube *k8s.ClientImpl, conf *types.ServerConfig,
+	recordings recording.Sink, t resolvedTarget, id string, mutex *sync.Mutex,
+) (int, error) {
+
+	config := t.podConfig.config
+	pod := &t.podConfig.pod
+
+	if t.podConfig.readOnly {
+		err := fmt.Errorf("commands are not allowed on the read-only target")
+		denied := targetAuditEvent(sess.Context(), audit.EventAuthzDenied, t.target, config)
+		denied.Command = sess.Command()
+		denied.Reason = err.Error()
+		audit.Log(denied)
+		Events.Emit(pod, config, denied.User, corev1.EventTypeWarning, ReasonAuthorizationDenied,
+			"command %v is not allowed on the read-only container %s", sess.Command(), t.target.Container)
+		return 0, err
+	}
+
+	config = config.WithDefaults(*conf)
+	containerName := t.target.Container
+
+	// Every target is a session of its own for the limits, and could be
+	// terminated separately by deleting its IngreSshSession resource. The
+	// target is stopped when the client disconnects as well.
+	execCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(sess.Context(), cancel)
+	defer stop()
+	session := &ActiveSession{
+		ID:           id,
+		ConnectionID: GetSessionIDFromCtx(sess.Context()),
+		User:         GetUsernameFromCtx(sess.Context()),
+		Fingerprint:  gossh.FingerprintSHA256(sess.PublicKey()),
+		Target:       t.target,
+		Config:       config,
+		Started:      time.Now(),
+		cancel:       cancel,
+	}
+	if err := Sessions.Admit(session); err != nil {
+		Logger(sess.Context()).Info("Session rejected", "reason", err.Error(), "route", config.Route(),
+			"pod", t.target.Pod, "container", t.target.Container)
+		rejected := targetAuditEvent(sess.Context(), audit.EventLimitExceeded, t.target, config)
+		rejected.Reason = err.Error()
+		audit.Log(rejected)
+		return 0, err
+	}
+	defer Sessions.Remove(session)
+	SessionObjects.Create(sess, session, false)
+	defer SessionObjects.Delete(session)
+
+	if config.Session != "Exec" {
+		var created bool
+		var err error
+		pod, containerName, created, err = k8s.AttachAccessContainer(execCtx, kube, pod, t.target.Container, config,
+			session.ID)
+		if err != nil {
+			return 0, err
+		}
+		if created {
+			auditContainerCreated(sess.Context(), t.target, containerName, config)
+			Events.Emit(pod, config, GetUsernameFromCtx(sess.Context()), corev1.EventTypeNormal,
+				ReasonDebugContainerCreated, "created debug container %s targeting container %s",
+				containerName, t.target.Container)
+		}
+	}
+
+	started := targetAuditEvent(sess.Context(), audit.EventSessionStart, t.target, config)
+	started.Command = sess.Command()
+
+	var out, errOut io.Writer = sess, sess.Stderr()
+	var recorded *recording.Session
+	if config.Record {
+		var err error
+		recorded, err = recording.Start(sess, recordings, recording.Metadata{
+			SessionID:   session.ID,
+			Route:       config.Route(),
+			User:        started.User,
+			Fingerprint: started.Fingerprint,
+			Namespace:   t.target.Namespace,
+			Pod:         t.target.Pod,
+			Container:   t.target.Container,
+			Mode:        config.Session,
+			Command:     sess.Command(),
+		})
+		if err != nil {
+			return 0, err
+		}
+		out, errOut = recorded, recorded.Stderr()
+	}
+
+	prefix := fmt.Sprintf("%s/%s", t.target.Pod, t.target.Container)
+	stdout := &prefixWriter{prefix: prefix, out: out, mutex: mutex}
+	stderr := &prefixWriter{prefix: prefix, out: errOut, mutex: mutex}
+
+	audit.Log(started)
+	Events.Emit(pod, config, started.User, corev1.EventTypeNormal, ReasonSessionStarted,
+		"started command %v in container %s", sess.Command(), t.target.Container)
+	startTime := time.Now()
+
+	exitCode, err := k8s.ExecCommand(execCtx, kube, pod, containerName, sess, stdout, stderr, config.Timeouts())
+	if session.Terminated() {
+		exitCode, err = 0, errTerminated
+	}
+	stdout.Flush()
+	stderr.Flush()
+	if recorded != nil {
+		if err := recorded.Finish(exitCode); err != nil {
+			Logger(sess.Context()).Error(err, "Unable to complete the recording")
+		}
+	}
+
+	ended := targetAuditEvent(sess.Context(), audit.EventSessionEnd, t.target, config)
+	ended.Duration = time.Since(startTime).Seconds()
+	if err != nil {
+		ended.Reason = err.Error()
+	} else {
+		ended.ExitCode = &exitCode
+	}
+	audit.Log(ended)
+	if err != nil {
+		Events.Emit(pod, config, ended.User, corev1.EventTypeWarning, ReasonSessionEnded,
+			"command %v in container %s failed: %v", sess.Command(), t.target.Container, err)
+	} else {
+		Events.Emit(pod, config, ended.User, corev1.EventTypeNormal, ReasonSessionEnded,
+			"ended command %v in container %s after %s with exit code %d",
+			sess.Command(), t.target.Container, time.Since(startTime).Round(time.Second), exitCode)
+	}
+	return exitCode, err
+}
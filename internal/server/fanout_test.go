@@ -0,0 +1,239 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gliderlabs/ssh"
+	gossh "golang.org/x/crypto/ssh"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	ingssh "kuberstein.io/ingressh/api/v1"
+	"kuberstein.io/ingressh/internal/k8s"
+	"kuberstein.io/ingressh/internal/types"
+)
+
+// testContext is the context of the connection of testSession.
+type testContext struct {
+	context.Context
+	sync.Mutex
+	values map[any]any
+}
+
+func newTestContext() *testContext {
+	return &testContext{Context: context.Background(), values: map[any]any{}}
+}
+
+func (c *testContext) Value(key any) any {
+	if v, ok := c.values[key]; ok {
+		return v
+	}
+	return c.Context.Value(key)
+}
+
+func (c *testContext) SetValue(key, value any) { c.values[key] = value }
+func (c *testContext) User() string            { return "all:prod" }
+func (c *testContext) SessionID() string       { return "test" }
+func (c *testContext) ClientVersion() string   { return "SSH-2.0-test" }
+func (c *testContext) ServerVersion() string   { return "SSH-2.0-ingressh" }
+func (c *testContext) RemoteAddr() net.Addr    { return &net.TCPAddr{IP: net.IPv4(10, 0, 0, 7)} }
+func (c *testContext) LocalAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 8022}
+}
+func (c *testContext) Permissions() *ssh.Permissions { return &ssh.Permissions{} }
+
+// testSession is the non-interactive SSH session running the command, which
+// collects the output.
+type testSession struct {
+	ssh.Session
+	ctx     *testContext
+	command []string
+	key     ssh.PublicKey
+	stdout  bytes.Buffer
+	stderr  bytes.Buffer
+}
+
+func (s *testSession) Context() ssh.Context                    { return s.ctx }
+func (s *testSession) Command() []string                       { return s.command }
+func (s *testSession) PublicKey() ssh.PublicKey                { return s.key }
+func (s *testSession) RemoteAddr() net.Addr                    { return s.ctx.RemoteAddr() }
+func (s *testSession) Environ() []string                       { return nil }
+func (s *testSession) Pty() (ssh.Pty, <-chan ssh.Window, bool) { return ssh.Pty{}, nil, false }
+func (s *testSession) Write(p []byte) (int, error)             { return s.stdout.Write(p) }
+func (s *testSession) Stderr() io.ReadWriter                   { return &s.stderr }
+
+func TestPrefixWriter(t *testing.T) {
+
+	var out bytes.Buffer
+	var mutex sync.Mutex
+	w := prefixWriter{prefix: "pod/container", out: &out, mutex: &mutex}
+
+	w.Write([]byte("first line\nsecond "))
+	w.Write([]byte("line\nincomplete"))
+	w.Flush()
+
+	expected := "pod/container: first line\npod/container: second line\npod/container: incomplete\n"
+	if out.String() != expected {
+		t.Errorf("Unexpected prefixed output: %q", out.String())
+	}
+}
+
+func TestFanOutRecording(t *testing.T) {
+
+	kube := clientPodMock{
+		pods: []struct {
+			pod      corev1.Pod
+			selector string
+		}{{pod: corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "api-1"},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+		}}},
+		namespaces: []string{"prod"},
+	}
+	config := types.SshConfig{Namespace: "prod", IngreSshSpec: ingssh.IngreSshSpec{Session: "Exec", Record: true}}
+	conf := types.DefaultServerConf()
+
+	// The recorded route is never used unrecorded, the command is not run
+	sess := &testSession{ctx: newTestContext(), command: []string{"hostname"}}
+	a := GetAuthz([]*types.SshConfig{&config}, "", kube)
+	if code := fanOut(context.Background(), sess, nil, conf, nil, a, types.SshTarget{Namespace: "prod", FanOut: true}); code != 4 {
+		t.Errorf("expected the fan-out to be refused with the exit code 4, got %d", code)
+	}
+	if !strings.Contains(sess.stderr.String(), "Session recording is not available") {
+		t.Errorf("unexpected output %q", sess.stderr.String())
+	}
+}
+
+func TestFanOutLimits(t *testing.T) {
+
+	kube := clientPodMock{
+		pods: []struct {
+			pod      corev1.Pod
+			selector string
+		}{{pod: corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "api-1"},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+		}}},
+		namespaces: []string{"prod"},
+	}
+	config := types.SshConfig{Namespace: "prod", IngreSshSpec: ingssh.IngreSshSpec{Session: "Exec"}}
+	conf := types.DefaultServerConf()
+
+	// The pod has a session already
+	existing := &ActiveSession{User: "bob", Target: types.SshTarget{Namespace: "prod", Pod: "api-1", Container: "app"}, Config: &config}
+	Sessions.Add(existing)
+	Sessions.SetLimits(types.LimitsConfig{SessionsPerPod: 1})
+	defer Sessions.SetLimits(types.LimitsConfig{})
+	defer Sessions.Remove(existing)
+
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := gossh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The target over the limit is rejected before the command is run
+	sess := &testSession{ctx: newTestContext(), command: []string{"hostname"}, key: key}
+	sess.ctx.SetValue(ctxKeyUsername, "alice")
+	a := GetAuthz([]*types.SshConfig{&config}, "", kube)
+	if code := fanOut(context.Background(), sess, nil, conf, nil, a, types.SshTarget{Namespace: "prod", FanOut: true}); code != 1 {
+		t.Errorf("expected the fan-out to fail with the exit code 1, got %d", code)
+	}
+	if !strings.Contains(sess.stderr.String(), "prod/api-1/app: error: too many concurrent sessions per pod") {
+		t.Errorf("unexpected output %q", sess.stderr.String())
+	}
+	if n := Sessions.CountByPod("prod", "api-1"); n != 1 {
+		t.Errorf("expected only the existing session, got %d", n)
+	}
+}
+
+func TestFanOutSessionIDs(t *testing.T) {
+
+	pods := []corev1.Pod{}
+	for _, name := range []string{"api-1", "api-2"} {
+		pods = append(pods, corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "prod"},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+		})
+	}
+	mock := clientPodMock{namespaces: []string{"prod"}}
+	for _, pod := range pods {
+		mock.pods = append(mock.pods, struct {
+			pod      corev1.Pod
+			selector string
+		}{pod: pod})
+	}
+
+	// The commands fail right away, the sessions are created anyway
+	attached := make(chan struct{})
+	close(attached)
+	api := debugAPIServer(t, pods[0], nil, attached)
+	var kube k8s.ClientImpl
+	if err := kube.Init(&rest.Config{Host: api.URL, ContentConfig: rest.ContentConfig{ContentType: "application/json"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	scheme := runtime.NewScheme()
+	if err := ingssh.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	var mutex sync.Mutex
+	created := map[string]ingssh.IngreSshSessionSpec{}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithInterceptorFuncs(interceptor.Funcs{
+		Create: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+			mutex.Lock()
+			defer mutex.Unlock()
+			spec := obj.(*ingssh.IngreSshSession).Spec
+			created[spec.Pod] = spec
+			return c.Create(ctx, obj, opts...)
+		},
+	}).Build()
+	SessionObjects.Init(c, "ingressh-0")
+	t.Cleanup(func() { SessionObjects = SessionObjectStore{} })
+
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := gossh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := types.SshConfig{Namespace: "prod", IngreSshSpec: ingssh.IngreSshSpec{Session: "Exec"}}
+	sess := &testSession{ctx: newTestContext(), command: []string{"hostname"}, key: key}
+	sess.ctx.SetValue(ctxKeyUsername, "alice")
+	sess.ctx.SetValue(ctxKeyAuthorizedKey, "")
+	a := GetAuthz([]*types.SshConfig{&config}, "", mock)
+	fanOut(context.Background(), sess, &kube, types.DefaultServerConf(), nil, a, types.SshTarget{Namespace: "prod", FanOut: true})
+
+	// Every target is a session of its own, correlated by the connection
+	expected := map[string]string{"api-1": "test-1", "api-2": "test-2"}
+	if len(created) != len(expected) {
+		t.Fatalf("expected a session resource per target, got %v", created)
+	}
+	for pod, id := range expected {
+		if spec := created[pod]; spec.SessionID != id || spec.ConnectionID != "test" {
+			t.Errorf("expected the session %q of the connection %q for %s, got %q of %q",
+				id, "test", pod, spec.SessionID, spec.ConnectionID)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"net"
+	"strconv"
+
+	"github.com/gliderlabs/ssh"
+	gossh "golang.org/x/crypto/ssh"
+
+	"kuberstein.io/ingressh/internal/audit"
+)
+
+// forwardRequest is the payload of the direct-tcpip channel, see RFC 4254
+// section 7.2.
+type forwardRequest struct {
+	DestAddr   string
+	DestPort   uint32
+	OriginAddr string
+	OriginPort uint32
+}
+
+// DirectTcpipHandler handles the local port forwarding channels. The port
+// forwarding is not supported, so the channels are rejected, but the
+// attempts are recorded in the audit log.
+func DirectTcpipHandler(srv *ssh.Server, conn *gossh.ServerConn, newChan gossh.NewChannel, ctx ssh.Context) {
+
+	handshakeDone(ctx)
+	HostKeys.announce(ctx)
+
+	e := auditEvent(ctx, audit.EventChannelForward)
+	e.Reason = "port forwarding is not supported"
+
+	req := forwardRequest{}
+	if err := gossh.Unmarshal(newChan.ExtraData(), &req); err != nil {
+		Logger(ctx).Error(err, "Invalid port forwarding request")
+		newChan.Reject(gossh.ConnectionFailed, "invalid request")
+		return
+	}
+	e.Destination = net.JoinHostPort(req.DestAddr, strconv.FormatUint(uint64(req.DestPort), 10))
+	audit.Log(e)
+
+	Logger(ctx).Info("Rejected port forwarding", "destination", e.Destination)
+	newChan.Reject(gossh.Prohibited, e.Reason)
+}
@@ -0,0 +1,279 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+
+	"kuberstein.io/ingressh/internal/audit"
+	"kuberstein.io/ingressh/internal/types"
+)
+
+const (
+	// guardHostsMax triggers cleanup of the states of the source IPs which
+	// are neither blocked nor limited.
+	guardHostsMax = 10000
+	// guardBackoffMax caps the backoff if the bans are disabled.
+	guardBackoffMax = time.Hour
+)
+
+// Ban is a temporary ban of the source IP.
+type Ban struct {
+	IP       string    `json:"ip"`
+	Reason   string    `json:"reason"`
+	Failures int       `json:"failures"`
+	Since    time.Time `json:"since"`
+	Until    time.Time `json:"until"`
+}
+
+// hostState tracks the connections of the source IP.
+type hostState struct {
+	limiter *rate.Limiter
+	// failures is the number of the failed connections in a row
+	failures     int
+	blockedUntil time.Time
+	ban          *Ban
+}
+
+// AuthGuard protects the server against the brute-forcing of the keys, like
+// fail2ban. The new connections from the source IP are rate limited with a
+// token bucket. Every connection closed without authentication after the
+// failed attempts blocks the source IP for the backoff time doubling with
+// every failure in a row, and too many failures ban the source IP for a
+// while. The blocked connections are closed before the handshake, so they
+// hold no resources.
+//
+// The failures are counted per connection rather than per key, as the SSH
+// clients usually offer several keys before the right one.
+type AuthGuard struct {
+	conf      types.GuardConfig
+	allowList []netip.Prefix
+	hosts     map[netip.Addr]*hostState
+	now       func() time.Time
+	mutex     sync.Mutex
+}
+
+// Guard guards the connections of the server. Nothing is limited until the
+// guard is configured.
+var Guard = AuthGuard{
+	hosts: make(map[netip.Addr]*hostState),
+	now:   time.Now,
+}
+
+// SetConfig configures the limits of the guard. The failures and the bans of
+// the source IPs are kept, so the guard can be reconfigured at runtime.
+func (g *AuthGuard) SetConfig(conf types.GuardConfig) error {
+
+	allowList, err := types.ParseCIDRs(conf.AllowList)
+	if err != nil {
+		return fmt.Errorf("invalid allow-list: %w", err)
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	g.conf = conf
+	g.allowList = allowList
+	if g.hosts == nil {
+		g.hosts = make(map[netip.Addr]*hostState)
+	}
+	for _, host := range g.hosts {
+		host.limiter = g.limiter(host.limiter)
+	}
+	return nil
+}
+
+// Allow returns nil if the new connection from the source IP is allowed, or
+// the error describing why it is not.
+func (g *AuthGuard) Allow(ip netip.Addr) error {
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if !ip.IsValid() || types.ContainsAddr(g.allowList, ip) {
+		return nil
+	}
+
+	now := g.now()
+	host := g.host(ip)
+	if host.ban != nil {
+		if now.Before(host.ban.Until) {
+			return fmt.Errorf("banned until %s: %s", host.ban.Until.Format(time.RFC3339), host.ban.Reason)
+		}
+		host.ban = nil
+		host.failures = 0
+	}
+	if now.Before(host.blockedUntil) {
+		return fmt.Errorf("blocked for %s after %d failed authentications",
+			host.blockedUntil.Sub(now).Round(time.Second), host.failures)
+	}
+	if host.limiter != nil && !host.limiter.AllowN(now, 1) {
+		return fmt.Errorf("too many connections")
+	}
+	return nil
+}
+
+// Failure accounts the connection from the source IP closed without
+// authentication after the failed attempts.
+func (g *AuthGuard) Failure(ip netip.Addr) {
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if !ip.IsValid() || types.ContainsAddr(g.allowList, ip) {
+		return
+	}
+
+	now := g.now()
+	host := g.host(ip)
+	if !host.blockedUntil.IsZero() && now.After(host.blockedUntil.Add(g.backoffMax())) {
+		// The previous failures are too old to be in a row
+		host.failures = 0
+	}
+	host.failures++
+
+	if g.conf.BanAfter > 0 && host.failures >= g.conf.BanAfter && host.ban == nil {
+		host.ban = &Ban{
+			IP:       ip.String(),
+			Reason:   fmt.Sprintf("%d failed authentications in a row", host.failures),
+			Failures: host.failures,
+			Since:    now,
+			Until:    now.Add(g.conf.BanDuration.Duration),
+		}
+		ctrllog.Log.WithName("guard").Info("Source IP is banned",
+			"sourceIP", host.ban.IP, "reason", host.ban.Reason, "until", host.ban.Until)
+		audit.Log(audit.Event{
+			Type:     audit.EventSourceBanned,
+			SourceIP: host.ban.IP,
+			Reason:   host.ban.Reason,
+			Duration: g.conf.BanDuration.Seconds(),
+		})
+		return
+	}
+	host.blockedUntil = now.Add(g.backoff(host.failures))
+}
+
+// Success resets the failures of the source IP once the user is
+// authenticated.
+func (g *AuthGuard) Success(ip netip.Addr) {
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if host, ok := g.hosts[ip]; ok && host.ban == nil {
+		host.failures = 0
+		host.blockedUntil = time.Time{}
+	}
+}
+
+// Bans returns the active bans ordered by the source IP.
+func (g *AuthGuard) Bans() []Ban {
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	now := g.now()
+	bans := []Ban{}
+	for _, host := range g.hosts {
+		if host.ban != nil && now.Before(host.ban.Until) {
+			bans = append(bans, *host.ban)
+		}
+	}
+	sort.Slice(bans, func(i, j int) bool {
+		return bans[i].IP < bans[j].IP
+	})
+	return bans
+}
+
+// ServeHTTP lists the active bans in JSON.
+func (g *AuthGuard) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+
+	if req.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(g.Bans()); err != nil {
+		ctrllog.Log.WithName("guard").Error(err, "Unable to write the bans")
+	}
+}
+
+// backoff returns the time the source IP is blocked for after the failures
+// in a row: the backoff doubling with every failure, up to the ban duration.
+func (g *AuthGuard) backoff(failures int) time.Duration {
+
+	backoff := g.conf.Backoff.Duration
+	for i := 1; i < failures && backoff < g.backoffMax(); i++ {
+		backoff *= 2
+	}
+	return min(backoff, g.backoffMax())
+}
+
+// backoffMax returns the maximum backoff: the ban duration if the bans are
+// enabled.
+func (g *AuthGuard) backoffMax() time.Duration {
+	if g.conf.BanAfter > 0 && g.conf.BanDuration.Duration > 0 {
+		return g.conf.BanDuration.Duration
+	}
+	return guardBackoffMax
+}
+
+// host returns the state of the source IP. The caller must hold the lock.
+func (g *AuthGuard) host(ip netip.Addr) *hostState {
+
+	host, ok := g.hosts[ip]
+	if ok {
+		return host
+	}
+	if len(g.hosts) >= guardHostsMax {
+		g.cleanup()
+	}
+	host = &hostState{limiter: g.limiter(nil)}
+	g.hosts[ip] = host
+	return host
+}
+
+// limiter returns the connection rate limiter of the source IP for the
+// configured rate: the existing one updated, if any, or nil if the rate is
+// not limited. The caller must hold the lock.
+func (g *AuthGuard) limiter(limiter *rate.Limiter) *rate.Limiter {
+
+	if g.conf.ConnectionRate <= 0 {
+		return nil
+	}
+	limit, burst := rate.Limit(g.conf.ConnectionRate), max(g.conf.ConnectionBurst, 1)
+	if limiter == nil {
+		return rate.NewLimiter(limit, burst)
+	}
+	now := g.now()
+	limiter.SetLimitAt(now, limit)
+	limiter.SetBurstAt(now, burst)
+	return limiter
+}
+
+// cleanup forgets the source IPs without failures and with the refilled
+// limiters, as they behave the same as the new ones.
+func (g *AuthGuard) cleanup() {
+
+	now := g.now()
+	for ip, host := range g.hosts {
+		if host.ban != nil && now.Before(host.ban.Until) {
+			continue
+		}
+		if host.failures > 0 && now.Before(host.blockedUntil.Add(g.backoffMax())) {
+			continue
+		}
+		if host.limiter != nil && host.limiter.TokensAt(now) < float64(host.limiter.Burst()) {
+			continue
+		}
+		delete(g.hosts, ip)
+	}
+}
@@ -0,0 +1,186 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"kuberstein.io/ingressh/internal/types"
+)
+
+// newTestGuard returns the guard with the clock controlled by the test.
+func newTestGuard(t *testing.T, conf types.GuardConfig) (*AuthGuard, *time.Time) {
+	now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	g := &AuthGuard{now: func() time.Time { return now }}
+	if err := g.SetConfig(conf); err != nil {
+		t.Fatalf("SetConfig() error = %v", err)
+	}
+	return g, &now
+}
+
+func TestAuthGuardBackoffAndBan(t *testing.T) {
+
+	g, now := newTestGuard(t, types.GuardConfig{
+		Backoff:     metav1.Duration{Duration: time.Second},
+		BanAfter:    4,
+		BanDuration: metav1.Duration{Duration: time.Hour},
+		AllowList:   []string{"10.0.0.0/8"},
+	})
+	ip := netip.MustParseAddr("203.0.113.7")
+
+	// The backoff doubles with every failure in a row
+	for i, backoff := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second} {
+		if err := g.Allow(ip); err != nil {
+			t.Fatalf("failure %d: expected the connection to be allowed, got %v", i, err)
+		}
+		g.Failure(ip)
+		*now = now.Add(backoff - time.Millisecond)
+		if err := g.Allow(ip); err == nil {
+			t.Fatalf("failure %d: expected the connection to be blocked for %s", i, backoff)
+		}
+		*now = now.Add(time.Millisecond)
+	}
+
+	g.Failure(ip)
+	bans := g.Bans()
+	if len(bans) != 1 || bans[0].IP != ip.String() || bans[0].Failures != 4 || bans[0].Reason == "" {
+		t.Fatalf("expected the ban of %s with the reason, got %+v", ip, bans)
+	}
+	if err := g.Allow(ip); err == nil {
+		t.Fatalf("expected the banned source to be rejected")
+	}
+
+	*now = now.Add(time.Hour)
+	if err := g.Allow(ip); err != nil {
+		t.Errorf("expected the ban to expire, got %v", err)
+	}
+	if len(g.Bans()) != 0 {
+		t.Errorf("expected no bans after the expiration")
+	}
+
+	// The allow-listed sources are never blocked
+	allowed := netip.MustParseAddr("10.1.2.3")
+	for i := 0; i < 10; i++ {
+		g.Failure(allowed)
+	}
+	if err := g.Allow(allowed); err != nil {
+		t.Errorf("expected the allow-listed source to be allowed, got %v", err)
+	}
+}
+
+func TestAuthGuardSuccessResetsFailures(t *testing.T) {
+
+	g, now := newTestGuard(t, types.GuardConfig{
+		Backoff:     metav1.Duration{Duration: time.Second},
+		BanAfter:    3,
+		BanDuration: metav1.Duration{Duration: time.Hour},
+	})
+	ip := netip.MustParseAddr("2001:db8::7")
+
+	for i := 0; i < 10; i++ {
+		g.Failure(ip)
+		*now = now.Add(time.Minute)
+		g.Success(ip)
+		if err := g.Allow(ip); err != nil {
+			t.Fatalf("attempt %d: expected the connection to be allowed, got %v", i, err)
+		}
+	}
+	if len(g.Bans()) != 0 {
+		t.Errorf("expected no bans for the failures followed by successes")
+	}
+}
+
+func TestAuthGuardConnectionRate(t *testing.T) {
+
+	g, now := newTestGuard(t, types.GuardConfig{ConnectionRate: 1, ConnectionBurst: 3})
+	ip := netip.MustParseAddr("203.0.113.8")
+
+	for i := 0; i < 3; i++ {
+		if err := g.Allow(ip); err != nil {
+			t.Fatalf("connection %d: expected the burst to be allowed, got %v", i, err)
+		}
+	}
+	if err := g.Allow(ip); err == nil {
+		t.Fatalf("expected the connection over the burst to be rejected")
+	}
+	if err := g.Allow(netip.MustParseAddr("203.0.113.9")); err != nil {
+		t.Errorf("expected the other source to be limited separately, got %v", err)
+	}
+
+	*now = now.Add(time.Second)
+	if err := g.Allow(ip); err != nil {
+		t.Errorf("expected the connection to be allowed after the refill, got %v", err)
+	}
+}
+
+func TestAuthGuardReconfigure(t *testing.T) {
+
+	g, _ := newTestGuard(t, types.GuardConfig{
+		ConnectionRate:  1,
+		ConnectionBurst: 1,
+		BanAfter:        1,
+		BanDuration:     metav1.Duration{Duration: time.Hour},
+	})
+	banned := netip.MustParseAddr("203.0.113.7")
+	limited := netip.MustParseAddr("203.0.113.8")
+	g.Failure(banned)
+	if err := g.Allow(limited); err != nil {
+		t.Fatalf("expected the first connection to be allowed, got %v", err)
+	}
+
+	if err := g.Allow(limited); err == nil {
+		t.Fatalf("expected the second connection to be limited")
+	}
+
+	// The bans are kept, and the limits are lifted
+	if err := g.SetConfig(types.GuardConfig{
+		BanAfter:    1,
+		BanDuration: metav1.Duration{Duration: time.Hour},
+	}); err != nil {
+		t.Fatalf("SetConfig() error = %v", err)
+	}
+	if err := g.Allow(banned); err == nil {
+		t.Errorf("expected the ban to survive the reconfiguration")
+	}
+	for i := 0; i < 10; i++ {
+		if err := g.Allow(limited); err != nil {
+			t.Fatalf("connection %d: expected no rate limit, got %v", i, err)
+		}
+	}
+
+	// The known sources are limited again
+	if err := g.SetConfig(types.GuardConfig{ConnectionRate: 1, ConnectionBurst: 1}); err != nil {
+		t.Fatalf("SetConfig() error = %v", err)
+	}
+	if g.Allow(limited) != nil || g.Allow(limited) == nil {
+		t.Errorf("expected the new rate limit to apply to the known source")
+	}
+}
+
+func TestAuthGuardServeHTTP(t *testing.T) {
+
+	g, _ := newTestGuard(t, types.GuardConfig{BanAfter: 1, BanDuration: metav1.Duration{Duration: time.Hour}})
+	g.Failure(netip.MustParseAddr("203.0.113.7"))
+
+	rec := httptest.NewRecorder()
+	g.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/bans", nil))
+
+	var bans []Ban
+	if err := json.Unmarshal(rec.Body.Bytes(), &bans); err != nil {
+		t.Fatalf("unable to decode the bans: %v", err)
+	}
+	if len(bans) != 1 || bans[0].IP != "203.0.113.7" {
+		t.Errorf("unexpected bans %+v", bans)
+	}
+
+	rec = httptest.NewRecorder()
+	g.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/bans", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected the bans to be read-only, got %d", rec.Code)
+	}
+}
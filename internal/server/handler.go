@@ -1,27 +1,74 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"time"
 
 	"github.com/gliderlabs/ssh"
-	log "github.com/sirupsen/logrus"
+	"github.com/go-logr/logr"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	gossh "golang.org/x/crypto/ssh"
+	corev1 "k8s.io/api/core/v1"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
 
+	"kuberstein.io/ingressh/internal/audit"
 	"kuberstein.io/ingressh/internal/k8s"
+	"kuberstein.io/ingressh/internal/metrics"
+	"kuberstein.io/ingressh/internal/recording"
+	"kuberstein.io/ingressh/internal/tracing"
 	"kuberstein.io/ingressh/internal/types"
 )
 
 // GetHandler returns SSH connection handler for the SSH server.
 // The user is authorized at this moment, the list of authorized configurations
 // is stored in the session context.
-func GetHandler(kube *k8s.ClientImpl, conf *types.ServerConfig) func(sess ssh.Session) {
+//
+// If recordings sink is not nil, it stores the recordings of the sessions for
+// the routes with the recording enabled. The session uses the configuration
+// of the server current at its start.
+func GetHandler(kube *k8s.ClientImpl, recordings recording.Sink) func(sess ssh.Session) {
 
 	return func(sess ssh.Session) {
 
+		conf := Config.Load()
+		handshakeDone(sess.Context())
+		HostKeys.announce(sess.Context())
+
+		traceCtx, span := tracing.Tracer().Start(connContext(sess.Context()), "ssh.session",
+			trace.WithAttributes(attribute.String("ssh.login", sess.User())))
+		defer span.End()
+
+		if sess.User() == replayUser {
+			sess.Exit(replay(sess, recordings))
+			return
+		}
+
 		// User may hint the target route with login name of SSH session.
 		hint := types.SshTarget{}
 		hint.InitFromUsername(sess.User())
 
-		targetAuth := GetAuthz(GetSshConfigsFromCtx(sess.Context()), kube)
+		targetAuth := GetAuthz(
+			GetSshConfigsFromCtx(sess.Context()),
+			GetAuthorizedKeyFromCtx(sess.Context()),
+			k8s.WithTracing(traceCtx, kube),
+		)
+		if targetAuth.RestrictToAlias(hint.Alias) {
+			Logger(sess.Context()).Info("Session is routed with the alias", "alias", hint.Alias)
+		}
+
+		if isBuiltin(sess) {
+			sess.Exit(builtin(sess, targetAuth, hint))
+			return
+		}
+
+		if hint.FanOut {
+			sess.Exit(fanOut(traceCtx, sess, kube, conf, recordings, targetAuth, hint))
+			return
+		}
 
 		var target types.SshTarget
 		var targetPodConfig podSshConfig
@@ -30,82 +77,261 @@ func GetHandler(kube *k8s.ClientImpl, conf *types.ServerConfig) func(sess ssh.Se
 
 		// Interactive selection makes sense only when there is a terminal
 		// and the user didn't specify all the components of the target
-		// to connect to.
+		// to connect to. The strict mode depends on the resources of the
+		// targets matching the request.
+		strict := false
 		if isPty && !hint.IsComplete() {
 			target, targetPodConfig, err = interactive(sess, targetAuth, hint)
 		} else {
-			target, targetPodConfig, err = automatic(sess, targetAuth, hint)
+			requested := strictRequested(sess.Environ())
+			if !isPty && (requested || targetAuth.hasStrictRoutes()) {
+				target, targetPodConfig, strict, err = strictAutomatic(sess, targetAuth, hint, requested)
+			}
+			if !strict && err == nil {
+				target, targetPodConfig, err = automatic(sess, targetAuth, hint)
+			}
+		}
+
+		// In the strict mode the session output is reserved for the
+		// machine-readable results, so the messages go to stderr.
+		var notice io.Writer = sess
+		if strict {
+			notice = sess.Stderr()
 		}
 		if err != nil {
-			fmt.Fprintf(sess, "Error: %s\n", err)
+			denied := targetAuditEvent(sess.Context(), audit.EventAuthzDenied, hint, nil)
+			denied.Reason = err.Error()
+			audit.Log(denied)
+		}
+		if errors.Is(err, ErrAmbiguousTarget) {
+			fmt.Fprintf(notice, "Error: %s\n", err)
+			sess.Exit(12)
+			return
+		}
+		if err != nil {
+			fmt.Fprintf(notice, "Error: %s\n", err)
 			sess.Exit(10)
 			return
 		}
 		if !target.IsComplete() {
-			fmt.Fprintf(sess, "No container selected\n")
+			fmt.Fprintf(notice, "No container selected\n")
 			sess.Exit(13)
 			return
 		}
 
+		// The session uses the route config resolved with the server
+		// defaults, including the debug container and the timeouts.
+		targetPodConfig.config = targetPodConfig.config.WithDefaults(*conf)
 		targetConfig := targetPodConfig.config
-		targetConfig.ApplyDefaults(*conf)
 		pod := targetPodConfig.pod
+		readOnly := targetPodConfig.readOnly
+
+		span.SetAttributes(
+			attribute.String("k8s.namespace.name", target.Namespace),
+			attribute.String("k8s.pod.name", target.Pod),
+			attribute.String("k8s.container.name", target.Container),
+			attribute.String("ingressh.session.mode", targetConfig.Session),
+		)
 
-		fmt.Fprintf(sess, "Pod has been found. Connecting your SSH session to %s/%s container %s...\n",
-			pod.Namespace, pod.Name, target.Container)
+		selected := targetAuditEvent(sess.Context(), audit.EventTargetSelected, target, targetConfig)
+		selected.ReadOnly = readOnly
+		audit.Log(selected)
 
-		// Session attach options vary depending on the mode
-		if targetConfig.Session == "Exec" {
-			command := targetConfig.Command
+		fmt.Fprintf(notice, "Pod has been found. Connecting your SSH session %s to %s/%s container %s...\n",
+			GetSessionIDFromCtx(sess.Context()), pod.Namespace, pod.Name, target.Container)
+		if readOnly {
+			// Read-only users only watch the configured command or the
+			// console of the container, they never run their own commands.
 			if len(sess.Command()) > 0 {
-				command = sess.Command()
-			}
-			if len(command) == 0 {
-				// In the Exec mode there is no default command to run like
-				// in the Debug mode, where the docker image entry point
-				// could be used.
-				fmt.Fprintf(sess, "Command is not specified\n")
+				denied := targetAuditEvent(sess.Context(), audit.EventAuthzDenied, target, targetConfig)
+				denied.Command = sess.Command()
+				denied.Reason = "commands are not allowed in the read-only session"
+				audit.Log(denied)
+				Events.Emit(&pod, targetConfig, denied.User, corev1.EventTypeWarning, ReasonAuthorizationDenied,
+					"command %v is not allowed in the read-only session to container %s", sess.Command(), target.Container)
+				fmt.Fprintf(notice, "Commands are not allowed in the read-only session\n")
 				sess.Exit(2)
 				return
 			}
-			log.Infof("Executing %v in the container %s", command, target.Container)
-			err := k8s.ExecInContainer(kube, &pod, target.Container, sess, command)
-			if err != nil {
-				log.Errorln(err)
-				sess.Exit(3)
+			fmt.Fprintf(notice, "The session is read-only: your input is ignored, press Ctrl+C to detach\n")
+		}
+
+		// The session is terminated by cancelling the context, f.e. when the
+		// IngreSshSession resource is deleted.
+		ctx, cancel := context.WithCancel(trace.ContextWithSpan(kube.Ctx(), span))
+		defer cancel()
+
+		session := &ActiveSession{
+			ID:           GetSessionIDFromCtx(sess.Context()),
+			ConnectionID: GetSessionIDFromCtx(sess.Context()),
+			User:         GetUsernameFromCtx(sess.Context()),
+			Fingerprint:  gossh.FingerprintSHA256(sess.PublicKey()),
+			Target:       target,
+			Config:       targetConfig,
+			Started:      time.Now(),
+			cancel:       cancel,
+		}
+		if err := Sessions.Admit(session); err != nil {
+			Logger(sess.Context()).Info("Session rejected", "reason", err.Error(), "route", targetConfig.Route())
+			rejected := targetAuditEvent(sess.Context(), audit.EventLimitExceeded, target, targetConfig)
+			rejected.Reason = err.Error()
+			audit.Log(rejected)
+			fmt.Fprintf(notice, "Session rejected: %s\n", err)
+			sess.Exit(16)
+			return
+		}
+		defer Sessions.Remove(session)
+		SessionObjects.Create(sess, session, readOnly)
+		defer SessionObjects.Delete(session)
+
+		// The recording wraps the session streams, so everything the user
+		// sees in the session is recorded.
+		var stream ssh.Session = metrics.Meter(sess, targetConfig.Session, session.Started)
+		var recorded *recording.Session
+		if targetConfig.Record {
+			if recordings == nil {
+				Logger(sess.Context()).Error(nil, "Recording is enabled, but the recording sink is not configured",
+					"route", targetConfig.Route())
+				fmt.Fprintf(notice, "Session recording is not available\n")
+				sess.Exit(4)
 				return
 			}
-		} else {
-			// debug session mode
-			pod, accessContainerName, err := k8s.AttachAccessContainer(
-				kube, &pod, target.Container, targetConfig)
+			recorded, err = recording.Start(stream, recordings, recording.Metadata{
+				SessionID:   session.ID,
+				Route:       targetConfig.Route(),
+				User:        session.User,
+				Fingerprint: gossh.FingerprintSHA256(sess.PublicKey()),
+				Namespace:   target.Namespace,
+				Pod:         target.Pod,
+				Container:   target.Container,
+				Mode:        targetConfig.Session,
+				Command:     sess.Command(),
+			})
 			if err != nil {
-				log.Errorln(err)
-				sess.Exit(2)
+				Logger(sess.Context()).Error(err, "Unable to record the session")
+				fmt.Fprintf(notice, "Session recording is not available\n")
+				sess.Exit(4)
 				return
 			}
+			stream = recorded
+			fmt.Fprintf(notice, "The session is recorded\n")
+		}
 
-			if len(sess.Command()) > 0 {
-				// Execute command in the running debug container
-				log.Infof("Executing %v in the ephemeral container %s", sess.Command(), accessContainerName)
-				err = k8s.ExecInContainer(kube, pod, accessContainerName, sess, sess.Command())
-				if err != nil {
-					log.Errorln(err)
-					sess.Exit(3)
-					return
-				}
-			} else {
-				// Attach terminal session to the running debug container
-				log.Infof("Attaching SSH session into the container %s", accessContainerName)
-				err = k8s.AttachSshSessionTerminal(kube, pod, accessContainerName, sess)
-				if err != nil {
-					log.Errorln(err)
-					sess.Exit(3)
-					return
-				}
+		started := targetAuditEvent(sess.Context(), audit.EventSessionStart, target, targetConfig)
+		started.ReadOnly = readOnly
+		started.Command = sess.Command()
+		audit.Log(started)
+		Events.Emit(&pod, targetConfig, started.User, corev1.EventTypeNormal, ReasonSessionStarted,
+			"started %s session to container %s%s", targetConfig.Session, target.Container, sessionModeNote(readOnly))
+
+		exitCode := runSession(ctx, kube, stream, notice, session, targetPodConfig)
+		if session.Terminated() {
+			fmt.Fprintf(sess.Stderr(), "\r\nThe session has been terminated by the administrator\r\n")
+			exitCode = 14
+		}
+
+		span.SetAttributes(attribute.Int("ssh.exit_code", exitCode))
+
+		ended := targetAuditEvent(sess.Context(), audit.EventSessionEnd, target, targetConfig)
+		ended.Duration = time.Since(session.Started).Seconds()
+		ended.ExitCode = &exitCode
+		audit.Log(ended)
+		Events.Emit(&pod, targetConfig, ended.User, corev1.EventTypeNormal, ReasonSessionEnded,
+			"ended %s session to container %s after %s with exit code %d",
+			targetConfig.Session, target.Container, time.Since(session.Started).Round(time.Second), exitCode)
+
+		if recorded != nil {
+			if err := recorded.Finish(exitCode); err != nil {
+				Logger(sess.Context()).Error(err, "Unable to complete the recording")
 			}
 		}
+		sess.Exit(exitCode)
+	}
+}
+
+// runSession attaches the streams of the SSH session to the target container
+// accordingly to the session mode, until ctx is cancelled. Returns the exit
+// code for the session.
+func runSession(ctx context.Context, kube *k8s.ClientImpl, sess ssh.Session, notice io.Writer,
+	session *ActiveSession, targetPodConfig podSshConfig,
+) int {
+
+	logger := ctrllog.FromContext(ctx)
+	target := session.Target
+	targetConfig := targetPodConfig.config
+	pod := targetPodConfig.pod
+	readOnly := targetPodConfig.readOnly
+	timeouts := targetConfig.Timeouts()
+
+	// Session attach options vary depending on the mode
+	if targetConfig.Session == "Exec" {
+		command := targetConfig.Command
+		if len(sess.Command()) > 0 {
+			command = sess.Command()
+		}
+		if len(command) == 0 {
+			// In the Exec mode there is no default command to run like
+			// in the Debug mode, where the docker image entry point
+			// could be used.
+			fmt.Fprintf(notice, "Command is not specified\n")
+			return 2
+		}
+		logger.Info("Executing the command in the container", "command", command, "container", target.Container)
+		err := k8s.ExecInContainer(ctx, kube, &pod, target.Container, sess, command, readOnly, timeouts)
+		return sessionExitCode(logger, err)
+	}
+
+	// debug session mode
+	if readOnly {
+		// The viewers never change the pod: they mirror the output of the
+		// target container instead of creating a debug container.
+		logger.Info("Attaching read-only SSH session to the container", "container", target.Container)
+		err := k8s.AttachSshSessionTerminal(ctx, kube, &pod, target.Container, sess, readOnly, timeouts)
+		return sessionExitCode(logger, err)
+	}
+	debugPod, accessContainerName, created, err := k8s.AttachAccessContainer(
+		ctx, kube, &pod, target.Container, targetConfig, session.ID)
+	if err != nil {
+		logger.Error(err, "Unable to attach the debug container")
+		return 2
+	}
+	SessionObjects.SetDebugContainer(session, accessContainerName)
+	if created {
+		auditContainerCreated(sess.Context(), target, accessContainerName, targetConfig)
+		Events.Emit(debugPod, targetConfig, GetUsernameFromCtx(sess.Context()), corev1.EventTypeNormal,
+			ReasonDebugContainerCreated, "created debug container %s targeting container %s",
+			accessContainerName, target.Container)
+	}
+
+	if len(sess.Command()) > 0 {
+		// Execute command in the running debug container
+		logger.Info("Executing the command in the ephemeral container", "command", sess.Command(), "container", accessContainerName)
+		err = k8s.ExecInContainer(ctx, kube, debugPod, accessContainerName, sess, sess.Command(), readOnly, timeouts)
+	} else {
+		// Attach terminal session to the running debug container
+		logger.Info("Attaching SSH session into the ephemeral container", "container", accessContainerName)
+		err = k8s.AttachSshSessionTerminal(ctx, kube, debugPod, accessContainerName, sess, readOnly, timeouts)
+	}
+	return sessionExitCode(logger, err)
+}
+
+// sessionExitCode returns the exit code for the session completed with err.
+func sessionExitCode(logger logr.Logger, err error) int {
+	if errors.Is(err, k8s.ErrSessionTimeout) {
+		logger.Info("Session closed", "reason", err.Error())
+		return 15
+	}
+	if err != nil {
+		logger.Error(err, "Session failed")
+		return 3
+	}
+	return 0
+}
 
-		sess.Exit(0)
+// sessionModeNote returns the note on the session mode for the messages.
+func sessionModeNote(readOnly bool) string {
+	if readOnly {
+		return " (read-only)"
 	}
+	return ""
 }
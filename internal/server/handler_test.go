@@ -0,0 +1,204 @@
+package server
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/rest"
+
+	ingssh "kuberstein.io/ingressh/api/v1"
+	"kuberstein.io/ingressh/internal/k8s"
+	"kuberstein.io/ingressh/internal/types"
+)
+
+// loginSession is testSession with the login name, the input and the
+// keepalive requests of the interactive sessions. The output and the
+// messages of the session are collected together, to be read while the
+// session runs.
+type loginSession struct {
+	*testSession
+	user      string
+	exit      chan int
+	keepAlive chan struct{}
+	once      sync.Once
+	mutex     sync.Mutex
+	notices   bytes.Buffer
+}
+
+func (s *loginSession) User() string               { return s.user }
+func (s *loginSession) Read(p []byte) (int, error) { return 0, io.EOF }
+func (s *loginSession) Exit(code int) error        { s.exit <- code; return nil }
+func (s *loginSession) Stderr() io.ReadWriter      { return s }
+
+func (s *loginSession) Write(p []byte) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.notices.Write(p)
+}
+
+func (s *loginSession) Notices() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.notices.String()
+}
+
+func (s *loginSession) SendRequest(name string, wantReply bool, payload []byte) (bool, error) {
+	s.once.Do(func() { close(s.keepAlive) })
+	return true, nil
+}
+
+// debugAPIServer is the API server with the pod, which runs the debug
+// containers added to the pod. The debug containers are sent to
+// containers. The attach and exec requests fail once attached is closed.
+func debugAPIServer(t *testing.T, pod corev1.Pod, containers chan<- corev1.EphemeralContainer,
+	attached <-chan struct{},
+) *httptest.Server {
+
+	write := func(w http.ResponseWriter, obj any) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(obj)
+	}
+	var mutex sync.Mutex
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		podPath := "/api/v1/namespaces/" + pod.Namespace + "/pods"
+		switch {
+		case r.URL.Path == "/api/v1/namespaces":
+			write(w, corev1.NamespaceList{
+				TypeMeta: metav1.TypeMeta{Kind: "NamespaceList", APIVersion: "v1"},
+				Items:    []corev1.Namespace{{ObjectMeta: metav1.ObjectMeta{Name: pod.Namespace}}},
+			})
+		case r.URL.Path == podPath && r.URL.Query().Get("watch") == "true":
+			running := pod.DeepCopy()
+			running.Status.EphemeralContainerStatuses = nil
+			for _, c := range running.Spec.EphemeralContainers {
+				running.Status.EphemeralContainerStatuses = append(running.Status.EphemeralContainerStatuses,
+					corev1.ContainerStatus{Name: c.Name, State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}})
+			}
+			object, _ := json.Marshal(running)
+			write(w, metav1.WatchEvent{Type: "MODIFIED", Object: runtime.RawExtension{Raw: object}})
+			w.(http.Flusher).Flush()
+			mutex.Unlock()
+			<-r.Context().Done()
+			mutex.Lock()
+		case r.URL.Path == podPath:
+			write(w, corev1.PodList{TypeMeta: metav1.TypeMeta{Kind: "PodList", APIVersion: "v1"}, Items: []corev1.Pod{pod}})
+		case r.URL.Path == podPath+"/"+pod.Name+"/ephemeralcontainers":
+			var updated corev1.Pod
+			if err := json.NewDecoder(r.Body).Decode(&updated); err != nil {
+				t.Errorf("unexpected ephemeral containers update: %v", err)
+			}
+			added := updated.Spec.EphemeralContainers[len(updated.Spec.EphemeralContainers)-1]
+			containers <- added
+			pod.Spec.EphemeralContainers = updated.Spec.EphemeralContainers
+			pod.Status.EphemeralContainerStatuses = append(pod.Status.EphemeralContainerStatuses,
+				corev1.ContainerStatus{Name: added.Name})
+			write(w, pod)
+		case r.URL.Path == podPath+"/"+pod.Name && r.Method == http.MethodPatch:
+			write(w, pod)
+		case strings.HasSuffix(r.URL.Path, "/attach"), strings.HasSuffix(r.URL.Path, "/exec"):
+			mutex.Unlock()
+			<-attached
+			mutex.Lock()
+			http.Error(w, "attach failed", http.StatusInternalServerError)
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestHandlerDefaults(t *testing.T) {
+
+	pod := corev1.Pod{
+		TypeMeta:   metav1.TypeMeta{Kind: "Pod", APIVersion: "v1"},
+		ObjectMeta: metav1.ObjectMeta{Name: "api-1", Namespace: "prod"},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+		Status: corev1.PodStatus{
+			Phase:      corev1.PodRunning,
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+		},
+	}
+	containers := make(chan corev1.EphemeralContainer, 1)
+	attached := make(chan struct{})
+	api := debugAPIServer(t, pod, containers, attached)
+	var kube k8s.ClientImpl
+	if err := kube.Init(&rest.Config{Host: api.URL, ContentConfig: rest.ContentConfig{ContentType: "application/json"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The route sets neither the image nor the timeouts, the server
+	// defaults apply
+	conf := types.DefaultServerConf()
+	conf.Defaults.Image = "debug:1.0"
+	conf.Defaults.MaxSessionDuration = metav1.Duration{Duration: time.Second}
+	conf.Defaults.KeepAliveInterval = metav1.Duration{Duration: 100 * time.Millisecond}
+	prev := Config.Load()
+	Config.current.Store(conf)
+	t.Cleanup(func() { Config.current.Store(prev) })
+	config := types.SshConfig{Namespace: "prod", IngreSshSpec: ingssh.IngreSshSpec{Session: "Debug"}}
+
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := gossh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sess := &loginSession{
+		testSession: &testSession{ctx: newTestContext(), key: key},
+		user:        "prod:api-1:app",
+		exit:        make(chan int, 1),
+		keepAlive:   make(chan struct{}),
+	}
+	sess.ctx.SetValue(ctxKeySshConfigs, []*types.SshConfig{&config})
+	sess.ctx.SetValue(ctxKeyAuthorizedKey, "")
+	sess.ctx.SetValue(ctxKeyUsername, "alice")
+
+	go GetHandler(&kube, nil)(sess)
+
+	select {
+	case c := <-containers:
+		if c.Image != "debug:1.0" {
+			t.Errorf("expected the debug container of the default image, got %q", c.Image)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the debug container to be created")
+	}
+	// The attach hangs until the session is closed by the watchdog
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(sess.Notices(), "The session is closed") {
+		if time.Now().After(deadline) {
+			close(attached)
+			t.Fatalf("expected the default maximum session duration to close the session, got %q", sess.Notices())
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	close(attached)
+	if code := <-sess.exit; code != 15 {
+		t.Errorf("expected the session to time out with the exit code 15, got %d", code)
+	}
+	select {
+	case <-sess.keepAlive:
+	default:
+		t.Errorf("expected the default keepalive requests")
+	}
+}
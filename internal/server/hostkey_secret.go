@@ -0,0 +1,120 @@
+package server
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"strings"
+
+	gossh "golang.org/x/crypto/ssh"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// Keys of the ConfigMap publishing the host keys
+const (
+	configMapHostKeys     = "host_keys.pub"
+	configMapFingerprints = "fingerprints"
+)
+
+// EnsureHostKeySecret returns the host key stored in the Secret, generating
+// an Ed25519 key into the new Secret if it doesn't exist. The Secret is only
+// created if it is absent, so the replicas starting at the same time converge
+// on the key of the one creating it first.
+func EnsureHostKeySecret(ctx context.Context, c client.Client, name k8stypes.NamespacedName) (gossh.Signer, error) {
+
+	secret := &corev1.Secret{}
+	err := c.Get(ctx, name, secret)
+	if apierrors.IsNotFound(err) {
+		secret, err = createHostKeySecret(ctx, c, name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to get host key secret %s: %w", name, err)
+	}
+
+	signer, err := gossh.ParsePrivateKey(secret.Data[corev1.SSHAuthPrivateKey])
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse host key of secret %s: %w", name, err)
+	}
+	return signer, nil
+}
+
+// createHostKeySecret generates the host key into the new Secret. Returns
+// the Secret created by another replica if there is one already.
+func createHostKeySecret(ctx context.Context, c client.Client, name k8stypes.NamespacedName) (*corev1.Secret, error) {
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name.Name,
+			Namespace: name.Namespace,
+		},
+		Type: corev1.SecretTypeSSHAuth,
+		Data: map[string][]byte{
+			corev1.SSHAuthPrivateKey: pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}),
+		},
+	}
+	err = c.Create(ctx, secret)
+	if apierrors.IsAlreadyExists(err) {
+		secret = &corev1.Secret{}
+		return secret, c.Get(ctx, name, secret)
+	}
+	if err != nil {
+		return nil, err
+	}
+	ctrllog.FromContext(ctx).Info("Host key is generated", "secret", name.String())
+	return secret, nil
+}
+
+// publishHostKeys writes the public host keys and their fingerprints into
+// the ConfigMap, so the users can add them to known_hosts.
+func publishHostKeys(ctx context.Context, c client.Client, name k8stypes.NamespacedName, keys []gossh.PublicKey) error {
+
+	var lines, fingerprints []string
+	for _, key := range keys {
+		lines = append(lines, strings.TrimSpace(string(gossh.MarshalAuthorizedKey(key))))
+		fingerprints = append(fingerprints, gossh.FingerprintSHA256(key)+" "+key.Type())
+	}
+	data := map[string]string{
+		configMapHostKeys:     strings.Join(lines, "\n") + "\n",
+		configMapFingerprints: strings.Join(fingerprints, "\n") + "\n",
+	}
+
+	cm := &corev1.ConfigMap{}
+	err := c.Get(ctx, name, cm)
+	if apierrors.IsNotFound(err) {
+		cm = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: name.Name, Namespace: name.Namespace},
+			Data:       data,
+		}
+		err = c.Create(ctx, cm)
+		if apierrors.IsAlreadyExists(err) {
+			// Another replica published the same keys
+			return nil
+		}
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if cm.Data[configMapHostKeys] == data[configMapHostKeys] {
+		return nil
+	}
+	cm.Data = data
+	return c.Update(ctx, cm)
+}
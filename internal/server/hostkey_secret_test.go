@@ -0,0 +1,101 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"strings"
+	"testing"
+
+	gossh "golang.org/x/crypto/ssh"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"kuberstein.io/ingressh/internal/types"
+)
+
+func TestEnsureHostKeySecret(t *testing.T) {
+
+	ctx := context.Background()
+	name := k8stypes.NamespacedName{Namespace: "ingressh", Name: "ingressh-hostkey"}
+	c := fake.NewClientBuilder().Build()
+
+	// The first start generates the key into the Secret
+	generated, err := EnsureHostKeySecret(ctx, c, name)
+	if err != nil {
+		t.Fatalf("EnsureHostKeySecret() error = %v", err)
+	}
+	if generated.PublicKey().Type() != gossh.KeyAlgoED25519 {
+		t.Errorf("expected the Ed25519 key, got %s", generated.PublicKey().Type())
+	}
+	secret := &corev1.Secret{}
+	if err := c.Get(ctx, name, secret); err != nil {
+		t.Fatalf("expected the Secret to be created: %v", err)
+	}
+	if secret.Type != corev1.SecretTypeSSHAuth {
+		t.Errorf("expected the Secret of type %s, got %s", corev1.SecretTypeSSHAuth, secret.Type)
+	}
+
+	// The other replicas and the restarts get the same key
+	loaded, err := EnsureHostKeySecret(ctx, c, name)
+	if err != nil {
+		t.Fatalf("EnsureHostKeySecret() error = %v", err)
+	}
+	if !bytes.Equal(loaded.PublicKey().Marshal(), generated.PublicKey().Marshal()) {
+		t.Errorf("expected the key of the existing Secret")
+	}
+
+	// The Secret without the key is not overwritten
+	empty := k8stypes.NamespacedName{Namespace: "ingressh", Name: "empty"}
+	if err := c.Create(ctx, &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: empty.Name, Namespace: empty.Namespace}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := EnsureHostKeySecret(ctx, c, empty); err == nil {
+		t.Errorf("expected the Secret without the key to be rejected")
+	}
+}
+
+func TestHostKeyStorePublish(t *testing.T) {
+
+	ctx := context.Background()
+	name := k8stypes.NamespacedName{Namespace: "ingressh", Name: "ingressh-host-keys"}
+	c := fake.NewClientBuilder().Build()
+
+	_, ed, _ := ed25519.GenerateKey(rand.Reader)
+	generated, _ := gossh.NewSignerFromKey(ed)
+
+	var s HostKeyStore
+	s.SetGenerated(generated)
+	if err := s.Load(nil, nil); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	s.PublishTo(c, name)
+
+	cm := &corev1.ConfigMap{}
+	if err := c.Get(ctx, name, cm); err != nil {
+		t.Fatalf("expected the ConfigMap to be created: %v", err)
+	}
+	expected := string(gossh.MarshalAuthorizedKey(generated.PublicKey()))
+	if cm.Data[configMapHostKeys] != expected {
+		t.Errorf("expected the public key %q, got %q", expected, cm.Data[configMapHostKeys])
+	}
+	if !strings.HasPrefix(cm.Data[configMapFingerprints], gossh.FingerprintSHA256(generated.PublicKey())) {
+		t.Errorf("expected the fingerprint of the key, got %q", cm.Data[configMapFingerprints])
+	}
+
+	// The reloaded keys are published before the generated one
+	file, signer := writeHostKey(t, t.TempDir(), "ed25519", ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize)))
+	if err := s.Load([]types.HostKeyConfig{{Key: file}}, nil); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if err := c.Get(ctx, name, cm); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(cm.Data[configMapHostKeys]), "\n")
+	if len(lines) != 2 || lines[0]+"\n" != string(gossh.MarshalAuthorizedKey(signer.PublicKey())) {
+		t.Errorf("expected the key of the file and the generated one, got %q", lines)
+	}
+}
@@ -0,0 +1,416 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gliderlabs/ssh"
+	gossh "golang.org/x/crypto/ssh"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+
+	"kuberstein.io/ingressh/internal/types"
+)
+
+// Requests of the OpenSSH host key rotation extension
+const (
+	hostKeysRequest      = "hostkeys-00@openssh.com"
+	hostKeysProveRequest = "hostkeys-prove-00@openssh.com"
+)
+
+var ctxKeyHostKeysAnnounced = &contextKey{"host_keys_announced"}
+
+// HostKeyStore holds the host keys of the server, reloaded when the key
+// files change. The first key of every key type and the certificates are
+// used for the key exchange. All the keys are announced to the clients with
+// the hostkeys-00@openssh.com extension, so the clients learn a new key
+// added next to the old one, and the old one can be removed later without
+// the clients seeing the changed key.
+type HostKeyStore struct {
+	// signers are used for the key exchange
+	signers []gossh.Signer
+	// keys are announced to the clients
+	keys []gossh.Signer
+	// generated is the key generated into the Secret, if any, used after the
+	// keys of the files
+	generated gossh.Signer
+	srv       *ssh.Server
+	// serverKeys are the signers passed to the bound server, which keeps
+	// the key types which are removed
+	serverKeys []gossh.Signer
+
+	// client publishes the public keys to the ConfigMap, if it is set
+	client    client.Client
+	configMap k8stypes.NamespacedName
+
+	mutex sync.RWMutex
+	// bind serializes passing the keys to the bound server, which is done
+	// out of the lock: the server holds its own lock calling back
+	// hostKeyAlgorithms for the new connections.
+	bind sync.Mutex
+}
+
+// HostKeys are the host keys of the server. There are none until they are
+// loaded.
+var HostKeys = HostKeyStore{}
+
+// Load reads the host keys and their certificates, and passes them to the
+// bound server. The keys are left as they are if any of them is not valid.
+//
+// The keys and the certificates are restricted to the host key algorithms,
+// if they are not nil: the keys of the other algorithms are skipped, and the
+// RSA keys are offered with the allowed signature algorithms only.
+func (s *HostKeyStore) Load(keys []types.HostKeyConfig, algorithms []string) error {
+
+	allowed := func(signer gossh.Signer) gossh.Signer {
+		if algorithms == nil {
+			return signer
+		}
+		return allowedHostKey(algorithms, signer)
+	}
+
+	var signers, announced []gossh.Signer
+	seen := make(map[string]bool)
+	for _, key := range keys {
+		signer, cert, err := loadHostKey(key)
+		if err != nil {
+			return err
+		}
+		if signer = allowed(signer); signer == nil {
+			ctrllog.Log.WithName("ssh").Info("Host key is not allowed by the crypto policy", "key", key.Key)
+			continue
+		}
+		if !seen[signer.PublicKey().Type()] {
+			seen[signer.PublicKey().Type()] = true
+			signers = append(signers, signer)
+		}
+		if cert != nil {
+			cert = allowed(cert)
+		}
+		if cert != nil && !seen[cert.PublicKey().Type()] {
+			seen[cert.PublicKey().Type()] = true
+			signers = append(signers, cert)
+		}
+		announced = append(announced, signer)
+	}
+
+	s.bind.Lock()
+	defer s.bind.Unlock()
+
+	s.mutex.Lock()
+	if generated := s.generated; generated != nil {
+		if generated = allowed(generated); generated != nil {
+			if !seen[generated.PublicKey().Type()] {
+				signers = append(signers, generated)
+			}
+			announced = append(announced, generated)
+		}
+	}
+	if len(signers) == 0 {
+		s.mutex.Unlock()
+		return errors.New("no host keys allowed by the crypto policy")
+	}
+	s.signers = signers
+	s.keys = announced
+	srv := s.srv
+	if srv != nil {
+		s.bound(signers)
+	}
+	s.mutex.Unlock()
+
+	if srv != nil {
+		addHostKeys(srv, signers)
+	}
+	s.publish()
+	return nil
+}
+
+// SetGenerated adds the key generated into the Secret to the keys loaded
+// from the files.
+func (s *HostKeyStore) SetGenerated(signer gossh.Signer) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.generated = signer
+}
+
+// PublishTo publishes the public host keys and their fingerprints to the
+// ConfigMap, now and whenever the keys are reloaded.
+func (s *HostKeyStore) PublishTo(c client.Client, configMap k8stypes.NamespacedName) {
+
+	s.mutex.Lock()
+	s.client = c
+	s.configMap = configMap
+	s.mutex.Unlock()
+
+	s.publish()
+}
+
+// publish writes the public keys to the ConfigMap if it is set. The errors
+// are logged, as the users may pin the keys otherwise.
+func (s *HostKeyStore) publish() {
+
+	s.mutex.RLock()
+	c, configMap := s.client, s.configMap
+	s.mutex.RUnlock()
+
+	if c == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), sessionObjectTimeout)
+	defer cancel()
+	if err := publishHostKeys(ctx, c, configMap, s.publicKeys()); err != nil {
+		ctrllog.Log.WithName("ssh").Error(err, "Unable to publish the host keys", "configMap", configMap.String())
+	}
+}
+
+// publicKeys returns the public keys announced to the clients.
+func (s *HostKeyStore) publicKeys() []gossh.PublicKey {
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var keys []gossh.PublicKey
+	for _, key := range s.keys {
+		keys = append(keys, key.PublicKey())
+	}
+	return keys
+}
+
+// hostKeyAlgorithms returns the host key algorithms the library offers for
+// the host keys of the server.
+func (s *HostKeyStore) hostKeyAlgorithms() []string {
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	// The bound server keeps the key types which are removed
+	signers := s.signers
+	if s.srv != nil {
+		signers = s.serverKeys
+	}
+	var algorithms []string
+	for _, signer := range signers {
+		algorithms = append(algorithms, signerAlgorithms(signer)...)
+	}
+	return algorithms
+}
+
+// Bind makes the server present the host keys, including the ones loaded
+// later. The server keeps the key types which are removed until it is
+// restarted.
+func (s *HostKeyStore) Bind(srv *ssh.Server) {
+
+	s.bind.Lock()
+	defer s.bind.Unlock()
+
+	s.mutex.Lock()
+	s.srv = srv
+	signers := s.signers
+	s.bound(signers)
+	s.mutex.Unlock()
+
+	addHostKeys(srv, signers)
+}
+
+// bound accounts the signers passed to the bound server, which replace the
+// ones of the same type. The caller must hold the lock.
+func (s *HostKeyStore) bound(signers []gossh.Signer) {
+	for _, signer := range signers {
+		i := slices.IndexFunc(s.serverKeys, func(key gossh.Signer) bool {
+			return key.PublicKey().Type() == signer.PublicKey().Type()
+		})
+		if i < 0 {
+			s.serverKeys = append(s.serverKeys, signer)
+		} else {
+			s.serverKeys[i] = signer
+		}
+	}
+}
+
+// addHostKeys passes the signers to the server, replacing the ones of the
+// same type. The caller must hold the bind lock, but not the lock of the
+// store.
+func addHostKeys(srv *ssh.Server, signers []gossh.Signer) {
+	for _, signer := range signers {
+		srv.AddHostKey(signer)
+	}
+}
+
+// announce sends the host keys to the client once per connection, after the
+// user is authenticated. The clients not supporting the extension ignore it.
+func (s *HostKeyStore) announce(ctx ssh.Context) {
+
+	once, ok := ctx.Value(ctxKeyHostKeysAnnounced).(*sync.Once)
+	conn, connOk := ctx.Value(ssh.ContextKeyConn).(gossh.Conn)
+	if !ok || !connOk {
+		return
+	}
+	once.Do(func() {
+		s.mutex.RLock()
+		var payload []byte
+		for _, key := range s.keys {
+			payload = appendString(payload, key.PublicKey().Marshal())
+		}
+		s.mutex.RUnlock()
+
+		if _, _, err := conn.SendRequest(hostKeysRequest, false, payload); err != nil {
+			Logger(ctx).V(1).Info("Unable to announce the host keys", "reason", err.Error())
+		}
+	})
+}
+
+// HostKeysProveHandler proves the possession of the host keys the client
+// learned from the announcement, signing them with the session identifier.
+func HostKeysProveHandler(ctx ssh.Context, srv *ssh.Server, req *gossh.Request) (bool, []byte) {
+
+	handshakeDone(ctx)
+
+	conn, ok := ctx.Value(ssh.ContextKeyConn).(gossh.Conn)
+	if !ok {
+		return false, nil
+	}
+	var hostKeyAlgorithm string
+	if k, ok := ctx.Value(ctxKeyKexInit).(*kexInit); ok {
+		hostKeyAlgorithm = k.hostKeyAlgorithm()
+	}
+	payload, err := HostKeys.prove(conn.SessionID(), req.Payload, hostKeyAlgorithm)
+	if err != nil {
+		Logger(ctx).Info("Unable to prove the host keys", "reason", err.Error())
+		return false, nil
+	}
+	return true, payload
+}
+
+// prove returns the signatures of the host keys requested by the client.
+// The RSA keys are signed with the algorithm of the host key negotiated for
+// the connection, see proveAlgorithm.
+func (s *HostKeyStore) prove(sessionID []byte, request []byte, hostKeyAlgorithm string) ([]byte, error) {
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var payload []byte
+	for len(request) > 0 {
+		blob, rest, ok := parseString(request)
+		if !ok {
+			return nil, errors.New("malformed request")
+		}
+		request = rest
+
+		signer := s.find(blob)
+		if signer == nil {
+			return nil, errors.New("unknown host key requested")
+		}
+		data := gossh.Marshal(struct {
+			Request   string
+			SessionID []byte
+			Key       []byte
+		}{hostKeysProveRequest, sessionID, blob})
+
+		var sig *gossh.Signature
+		var err error
+		if as, ok := signer.(gossh.AlgorithmSigner); ok && signer.PublicKey().Type() == gossh.KeyAlgoRSA {
+			sig, err = as.SignWithAlgorithm(rand.Reader, data, proveAlgorithm(hostKeyAlgorithm))
+		} else {
+			sig, err = signer.Sign(rand.Reader, data)
+		}
+		if err != nil {
+			return nil, err
+		}
+		payload = appendString(payload, gossh.Marshal(sig))
+	}
+	return payload, nil
+}
+
+// proveAlgorithm returns the signature algorithm of the RSA keys in the
+// proofs. If an RSA host key algorithm is negotiated for the connection, the
+// clients verify the proofs with the same algorithm, as OpenSSH does, so it
+// is used. Otherwise the clients accept any of them, and SHA-2 is used.
+func proveAlgorithm(hostKeyAlgorithm string) string {
+	switch hostKeyAlgorithm {
+	case gossh.KeyAlgoRSA, gossh.CertAlgoRSAv01:
+		return gossh.KeyAlgoRSA
+	case gossh.KeyAlgoRSASHA256, gossh.CertAlgoRSASHA256v01:
+		return gossh.KeyAlgoRSASHA256
+	}
+	return gossh.KeyAlgoRSASHA512
+}
+
+// find returns the announced key with the public key blob, or nil. The
+// caller must hold the lock.
+func (s *HostKeyStore) find(blob []byte) gossh.Signer {
+	for _, key := range s.keys {
+		if bytes.Equal(key.PublicKey().Marshal(), blob) {
+			return key
+		}
+	}
+	return nil
+}
+
+// loadHostKey reads the host key, and its certificate if there is one.
+func loadHostKey(key types.HostKeyConfig) (gossh.Signer, gossh.Signer, error) {
+
+	pemBytes, err := os.ReadFile(key.Key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to read host key file %s: %w", key.Key, err)
+	}
+	signer, err := gossh.ParsePrivateKey(pemBytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to parse host key %s: %w", key.Key, err)
+	}
+
+	certFile, required := key.CertificateFile()
+	certBytes, err := os.ReadFile(certFile)
+	if errors.Is(err, os.ErrNotExist) && !required {
+		return signer, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to read host certificate file %s: %w", certFile, err)
+	}
+	pub, _, _, _, err := gossh.ParseAuthorizedKey(certBytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to parse host certificate %s: %w", certFile, err)
+	}
+	cert, ok := pub.(*gossh.Certificate)
+	if !ok || cert.CertType != gossh.HostCert {
+		return nil, nil, fmt.Errorf("%s is not a host certificate", certFile)
+	}
+	certSigner, err := gossh.NewCertSigner(cert, signer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("host certificate %s doesn't match the key %s: %w", certFile, key.Key, err)
+	}
+	if cert.ValidBefore != gossh.CertTimeInfinity && time.Now().After(time.Unix(int64(cert.ValidBefore), 0)) {
+		ctrllog.Log.WithName("ssh").Info("Host certificate is expired", "certificate", certFile)
+	}
+	return signer, certSigner, nil
+}
+
+// appendString appends the SSH wire encoding of the string.
+func appendString(buf []byte, s []byte) []byte {
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(s)))
+	return append(buf, s...)
+}
+
+// parseString returns the SSH string at the start of the buffer, and the
+// rest of the buffer.
+func parseString(buf []byte) ([]byte, []byte, bool) {
+	if len(buf) < 4 {
+		return nil, nil, false
+	}
+	n := binary.BigEndian.Uint32(buf)
+	if uint64(len(buf)-4) < uint64(n) {
+		return nil, nil, false
+	}
+	return buf[4 : 4+n], buf[4+n:], true
+}
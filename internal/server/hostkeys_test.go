@@ -0,0 +1,270 @@
+package server
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/gliderlabs/ssh"
+	gossh "golang.org/x/crypto/ssh"
+
+	"kuberstein.io/ingressh/internal/types"
+)
+
+// writeHostKey writes the private key in PEM into the directory, and returns
+// the file and the signer of the key.
+func writeHostKey(t *testing.T, dir string, name string, key crypto.Signer) (string, gossh.Signer) {
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, name)
+	if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	signer, err := gossh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return file, signer
+}
+
+func TestHostKeyStoreLoad(t *testing.T) {
+
+	dir := t.TempDir()
+	_, ed1, _ := ed25519.GenerateKey(rand.Reader)
+	_, ed2, _ := ed25519.GenerateKey(rand.Reader)
+	ec, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, ca, _ := ed25519.GenerateKey(rand.Reader)
+
+	edFile, edSigner := writeHostKey(t, dir, "ed25519", ed1)
+	nextFile, _ := writeHostKey(t, dir, "ed25519-next", ed2)
+	ecFile, _ := writeHostKey(t, dir, "ecdsa", ec)
+
+	// The certificate of the first key is found by the file name
+	caSigner, _ := gossh.NewSignerFromKey(ca)
+	cert := &gossh.Certificate{
+		Key:             edSigner.PublicKey(),
+		CertType:        gossh.HostCert,
+		ValidPrincipals: []string{"ingressh.example.com"},
+		ValidBefore:     gossh.CertTimeInfinity,
+	}
+	if err := cert.SignCert(rand.Reader, caSigner); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(edFile+"-cert.pub", gossh.MarshalAuthorizedKey(cert), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var s HostKeyStore
+	if err := s.Load([]types.HostKeyConfig{{Key: edFile}, {Key: nextFile}, {Key: ecFile}}, nil); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	var kex []string
+	for _, signer := range s.signers {
+		kex = append(kex, signer.PublicKey().Type())
+	}
+	expected := []string{gossh.KeyAlgoED25519, gossh.CertAlgoED25519v01, gossh.KeyAlgoECDSA256}
+	if len(kex) != len(expected) {
+		t.Fatalf("expected the key exchange with %v, got %v", expected, kex)
+	}
+	for i := range expected {
+		if kex[i] != expected[i] {
+			t.Errorf("expected the key exchange with %v, got %v", expected, kex)
+		}
+	}
+	if len(s.keys) != 3 {
+		t.Errorf("expected all 3 keys to be announced, got %d", len(s.keys))
+	}
+
+	// The keys are left as they are if any of them is not valid
+	if err := s.Load([]types.HostKeyConfig{{Key: edFile}, {Key: ecFile, Certificate: edFile + "-cert.pub"}}, nil); err == nil {
+		t.Errorf("expected the certificate of the other key to be rejected")
+	}
+	if err := s.Load([]types.HostKeyConfig{{Key: filepath.Join(dir, "missing")}}, nil); err == nil {
+		t.Errorf("expected the missing key to be rejected")
+	}
+	if len(s.keys) != 3 {
+		t.Errorf("expected the keys to be kept, got %d", len(s.keys))
+	}
+}
+
+func TestHostKeyStoreProve(t *testing.T) {
+
+	dir := t.TempDir()
+	_, ed, _ := ed25519.GenerateKey(rand.Reader)
+	ec, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	edFile, edSigner := writeHostKey(t, dir, "ed25519", ed)
+	ecFile, ecSigner := writeHostKey(t, dir, "ecdsa", ec)
+
+	var s HostKeyStore
+	if err := s.Load([]types.HostKeyConfig{{Key: edFile}, {Key: ecFile}}, nil); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	sessionID := []byte("session identifier")
+	var request []byte
+	for _, signer := range []gossh.Signer{ecSigner, edSigner} {
+		request = appendString(request, signer.PublicKey().Marshal())
+	}
+	reply, err := s.prove(sessionID, request, gossh.KeyAlgoED25519)
+	if err != nil {
+		t.Fatalf("prove() error = %v", err)
+	}
+
+	for _, signer := range []gossh.Signer{ecSigner, edSigner} {
+		blob, rest, ok := parseString(reply)
+		if !ok {
+			t.Fatalf("expected the signature of %s", signer.PublicKey().Type())
+		}
+		reply = rest
+
+		var sig gossh.Signature
+		if err := gossh.Unmarshal(blob, &sig); err != nil {
+			t.Fatalf("invalid signature: %v", err)
+		}
+		data := gossh.Marshal(struct {
+			Request   string
+			SessionID []byte
+			Key       []byte
+		}{hostKeysProveRequest, sessionID, signer.PublicKey().Marshal()})
+		if err := signer.PublicKey().Verify(data, &sig); err != nil {
+			t.Errorf("invalid signature of %s: %v", signer.PublicKey().Type(), err)
+		}
+	}
+	if len(reply) != 0 {
+		t.Errorf("expected no more signatures")
+	}
+
+	_, other, _ := ed25519.GenerateKey(rand.Reader)
+	otherSigner, _ := gossh.NewSignerFromKey(other)
+	if _, err := s.prove(sessionID, appendString(nil, otherSigner.PublicKey().Marshal()), gossh.KeyAlgoED25519); err == nil {
+		t.Errorf("expected the unknown key to be rejected")
+	}
+}
+
+func TestHostKeyStoreProveRSA(t *testing.T) {
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaFile, rsaSigner := writeHostKey(t, t.TempDir(), "rsa", key)
+
+	var s HostKeyStore
+	if err := s.Load([]types.HostKeyConfig{{Key: rsaFile}}, nil); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	tests := []struct {
+		hostKeyAlgorithm string
+		format           string
+	}{
+		{gossh.KeyAlgoRSASHA256, gossh.KeyAlgoRSASHA256},
+		{gossh.CertAlgoRSASHA256v01, gossh.KeyAlgoRSASHA256},
+		{gossh.KeyAlgoRSASHA512, gossh.KeyAlgoRSASHA512},
+		{gossh.KeyAlgoRSA, gossh.KeyAlgoRSA},
+		{gossh.KeyAlgoED25519, gossh.KeyAlgoRSASHA512},
+		{"", gossh.KeyAlgoRSASHA512},
+	}
+
+	sessionID := []byte("session identifier")
+	blob := rsaSigner.PublicKey().Marshal()
+	for _, tt := range tests {
+		reply, err := s.prove(sessionID, appendString(nil, blob), tt.hostKeyAlgorithm)
+		if err != nil {
+			t.Fatalf("prove(%q) error = %v", tt.hostKeyAlgorithm, err)
+		}
+		sigBlob, _, _ := parseString(reply)
+		var sig gossh.Signature
+		if err := gossh.Unmarshal(sigBlob, &sig); err != nil {
+			t.Fatalf("invalid signature: %v", err)
+		}
+		if sig.Format != tt.format {
+			t.Errorf("prove(%q): expected the %s signature, got %s", tt.hostKeyAlgorithm, tt.format, sig.Format)
+		}
+		data := gossh.Marshal(struct {
+			Request   string
+			SessionID []byte
+			Key       []byte
+		}{hostKeysProveRequest, sessionID, blob})
+		if err := rsaSigner.PublicKey().Verify(data, &sig); err != nil {
+			t.Errorf("prove(%q): invalid signature: %v", tt.hostKeyAlgorithm, err)
+		}
+	}
+}
+
+func TestHostKeyStoreReload(t *testing.T) {
+
+	dir := t.TempDir()
+	_, ed, _ := ed25519.GenerateKey(rand.Reader)
+	ec, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	edFile, _ := writeHostKey(t, dir, "ed25519", ed)
+	ecFile, _ := writeHostKey(t, dir, "ecdsa", ec)
+
+	var s HostKeyStore
+	if err := s.Load([]types.HostKeyConfig{{Key: edFile}, {Key: ecFile}}, nil); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	// The server asks for the algorithms holding its lock, while the keys
+	// are reloaded
+	connecting, loading := make(chan struct{}), make(chan struct{})
+	srv := &ssh.Server{}
+	srv.ServerConfigCallback = func(ctx ssh.Context) *gossh.ServerConfig {
+		close(connecting)
+		<-loading
+		time.Sleep(100 * time.Millisecond)
+		return &gossh.ServerConfig{ServerVersion: "SSH-2.0-" + fmt.Sprint(s.hostKeyAlgorithms())}
+	}
+	s.Bind(srv)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(ln)
+	defer ln.Close()
+
+	go gossh.Dial("tcp", ln.Addr().String(), &gossh.ClientConfig{
+		HostKeyCallback: gossh.InsecureIgnoreHostKey(),
+		Timeout:         time.Second,
+	})
+	<-connecting
+
+	done := make(chan error)
+	go func() {
+		close(loading)
+		done <- s.Load([]types.HostKeyConfig{{Key: edFile}}, nil)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Load() error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the keys to be reloaded during the handshake")
+	}
+
+	// The server keeps the removed key
+	algorithms := s.hostKeyAlgorithms()
+	if !slices.Contains(algorithms, gossh.KeyAlgoECDSA256) {
+		t.Errorf("expected the removed key to be kept by the server, got %v", algorithms)
+	}
+	if len(srv.HostSigners) != 2 {
+		t.Errorf("expected 2 host keys of the server, got %d", len(srv.HostSigners))
+	}
+}
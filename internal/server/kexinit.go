@@ -0,0 +1,200 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/go-logr/logr"
+
+	"kuberstein.io/ingressh/internal/types"
+)
+
+// The SSH library doesn't expose the negotiated algorithms, so they are
+// found from the first key exchange packet of the client, which is sent in
+// cleartext.
+const (
+	msgKexInit = 20
+	// kexInitMaxSize limits the data kept until the packet is complete
+	kexInitMaxSize = 64 * 1024
+)
+
+var ctxKeyKexInit = &contextKey{"kex_init"}
+
+// kexInit logs the algorithms negotiated with the client, found from the
+// version and the key exchange packet the client sends first.
+type kexInit struct {
+	logger logr.Logger
+	// server are the algorithms offered by the server
+	server types.CryptoAlgorithms
+	// allowedHostKeys are the host key algorithms of the crypto policy
+	allowedHostKeys []string
+
+	// hostKey is the host key algorithm negotiated with the client
+	hostKey string
+
+	buf   []byte
+	done  bool
+	mutex sync.Mutex
+}
+
+// setServer sets the algorithms offered by the server to the connection.
+// The host key algorithms are the ones offered by the library for the host
+// keys, which include ssh-rsa for the RSA keys.
+func (k *kexInit) setServer(algorithms types.CryptoAlgorithms, hostKeyAlgorithms []string) {
+
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	k.allowedHostKeys = algorithms.HostKeyAlgorithms
+	k.server = algorithms
+	k.server.HostKeyAlgorithms = hostKeyAlgorithms
+}
+
+// read accounts the data read from the client until the key exchange packet
+// is complete.
+func (k *kexInit) read(data []byte) {
+
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	if k.done {
+		return
+	}
+	k.buf = append(k.buf, data...)
+	version, client, ok := parseKexInit(k.buf)
+	if !ok && len(k.buf) < kexInitMaxSize {
+		return
+	}
+	k.done = true
+	k.buf = nil
+	if !ok {
+		k.logger.V(1).Info("Unable to parse the key exchange of the client")
+		return
+	}
+	k.log(version, client)
+}
+
+// log logs the algorithms negotiated with the client, or the ones the
+// client offered if there is no common algorithm. The caller must hold the
+// lock.
+func (k *kexInit) log(version string, client types.CryptoAlgorithms) {
+
+	negotiated := []any{"clientVersion", version}
+	for _, a := range []struct {
+		name   string
+		client []string
+		server []string
+	}{
+		{"kex", client.KeyExchanges, k.server.KeyExchanges},
+		{"hostKey", client.HostKeyAlgorithms, k.server.HostKeyAlgorithms},
+		{"cipher", client.Ciphers, k.server.Ciphers},
+		{"mac", client.MACs, k.server.MACs},
+	} {
+		common, ok := findCommon(a.client, a.server)
+		if !ok {
+			k.logger.Info("No common algorithm with the client", "clientVersion", version,
+				"algorithm", a.name, "clientOffered", a.client, "serverOffered", a.server)
+			return
+		}
+		negotiated = append(negotiated, a.name, common)
+		if a.name == "hostKey" {
+			k.hostKey = common
+		}
+		if a.name == "hostKey" && !slices.Contains(k.allowedHostKeys, common) {
+			// The server keeps the host keys of the types which are
+			// removed from the crypto policy until it is restarted.
+			k.logger.Info("The host key algorithm negotiated with the client is not allowed",
+				"clientVersion", version, "hostKey", common, "clientOffered", a.client)
+			return
+		}
+	}
+	k.logger.Info("Algorithms negotiated", negotiated...)
+}
+
+// hostKeyAlgorithm returns the host key algorithm negotiated with the
+// client, or an empty string if it is not known.
+func (k *kexInit) hostKeyAlgorithm() string {
+
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	return k.hostKey
+}
+
+// kexInitConn passes the data read from the client to the kexInit.
+type kexInitConn struct {
+	net.Conn
+	kexInit *kexInit
+}
+
+func (c *kexInitConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.kexInit.read(p[:n])
+	}
+	return n, err
+}
+
+// findCommon returns the first algorithm of the client the server supports,
+// as the SSH library does.
+func findCommon(client []string, server []string) (string, bool) {
+	for _, c := range client {
+		if slices.Contains(server, c) {
+			return c, true
+		}
+	}
+	return "", false
+}
+
+// parseKexInit parses the version of the client and the algorithms of its
+// key exchange packet, in the client to server direction. Returns false if
+// the data is not complete or not valid.
+func parseKexInit(buf []byte) (string, types.CryptoAlgorithms, bool) {
+
+	var algorithms types.CryptoAlgorithms
+
+	eol := bytes.IndexByte(buf, '\n')
+	if eol < 0 {
+		return "", algorithms, false
+	}
+	version := strings.TrimSuffix(string(buf[:eol]), "\r")
+	buf = buf[eol+1:]
+
+	// uint32 packet length, byte padding length, payload and padding
+	if len(buf) < 5 {
+		return "", algorithms, false
+	}
+	length := binary.BigEndian.Uint32(buf)
+	padding := uint32(buf[4])
+	if uint64(len(buf)-4) < uint64(length) || length < padding+1 {
+		return "", algorithms, false
+	}
+	payload := buf[5 : 4+length-padding]
+
+	// byte message type and 16 bytes cookie, followed by the name-lists
+	if len(payload) < 17 || payload[0] != msgKexInit {
+		return "", algorithms, false
+	}
+	rest := payload[17:]
+	var lists [6][]string
+	for i := range lists {
+		list, next, ok := parseString(rest)
+		if !ok {
+			return "", algorithms, false
+		}
+		if len(list) > 0 {
+			lists[i] = strings.Split(string(list), ",")
+		}
+		rest = next
+	}
+	// The client to server and the server to client lists alternate
+	algorithms.KeyExchanges = lists[0]
+	algorithms.HostKeyAlgorithms = lists[1]
+	algorithms.Ciphers = lists[2]
+	algorithms.MACs = lists[4]
+	return version, algorithms, true
+}
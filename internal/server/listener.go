@@ -0,0 +1,205 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pires/go-proxyproto"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+
+	"kuberstein.io/ingressh/internal/metrics"
+	"kuberstein.io/ingressh/internal/types"
+)
+
+// sourceLists are the parsed lists of the source filter.
+type sourceLists struct {
+	allow []netip.Prefix
+	deny  []netip.Prefix
+}
+
+// SourceFilter filters the connections by the source address. The lists can
+// be replaced at runtime, applying to the connections accepted afterwards.
+type SourceFilter struct {
+	lists atomic.Pointer[sourceLists]
+}
+
+// Sources filters the connections of the server. All addresses are allowed
+// until the lists are set.
+var Sources SourceFilter
+
+// SetLists sets the CIDRs of the addresses the connections are accepted from.
+// All addresses are allowed if the allow-list is empty. The deny-list takes
+// precedence.
+func (f *SourceFilter) SetLists(allowList []string, denyList []string) error {
+
+	allow, err := types.ParseCIDRs(allowList)
+	if err != nil {
+		return fmt.Errorf("invalid allow-list: %w", err)
+	}
+	deny, err := types.ParseCIDRs(denyList)
+	if err != nil {
+		return fmt.Errorf("invalid deny-list: %w", err)
+	}
+	f.lists.Store(&sourceLists{allow: allow, deny: deny})
+	return nil
+}
+
+// Allows returns true if the connections from the address are allowed.
+func (f *SourceFilter) Allows(addr netip.Addr) bool {
+
+	lists := f.lists.Load()
+	if lists == nil {
+		return true
+	}
+	if types.ContainsAddr(lists.deny, addr) {
+		return false
+	}
+	return len(lists.allow) == 0 || types.ContainsAddr(lists.allow, addr)
+}
+
+// filteredListener closes the accepted connections from the source addresses
+// which are not allowed, before the SSH handshake.
+type filteredListener struct {
+	net.Listener
+	filter *SourceFilter
+}
+
+// FilterListener returns the listener accepting the connections only from the
+// addresses allowed by the filter.
+func FilterListener(ln net.Listener, filter *SourceFilter) net.Listener {
+	return &filteredListener{Listener: ln, filter: filter}
+}
+
+func (l *filteredListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if l.filter.Allows(sourceAddr(conn.RemoteAddr())) {
+			return conn, nil
+		}
+		ctrllog.Log.WithName("ssh").V(1).Info("Connection rejected: the source address is not allowed",
+			"sourceIP", sourceIP(conn.RemoteAddr()))
+		metrics.Connections.WithLabelValues(metrics.ResultRejected).Inc()
+		conn.Close()
+	}
+}
+
+// proxyHeaderTimeout limits the time to receive the PROXY protocol header.
+const proxyHeaderTimeout = 10 * time.Second
+
+// acceptResult is the connection accepted by the proxy listener, or the error.
+type acceptResult struct {
+	conn net.Conn
+	err  error
+}
+
+// proxyListener accepts the connections with the PROXY protocol v1 or v2
+// header sent by the trusted upstreams, f.e. TCP load balancers, so the
+// remote address of the connection is the address of the client rather than
+// the address of the load balancer. The header is received by a goroutine of
+// the connection, so the slow clients don't block the accepting of the others.
+type proxyListener struct {
+	net.Listener
+	accepted  chan acceptResult
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// ProxyListener returns the listener accepting the PROXY protocol header from
+// the upstreams in the trusted CIDRs. The header is optional for the trusted
+// upstreams, and the connections from the other upstreams sending the header
+// are rejected.
+func ProxyListener(ln net.Listener, trustedList []string) (net.Listener, error) {
+
+	trusted, err := types.ParseCIDRs(trustedList)
+	if err != nil {
+		return nil, err
+	}
+	if len(trusted) == 0 {
+		return nil, errors.New("no trusted upstreams for the PROXY protocol")
+	}
+
+	pl := &proxyproto.Listener{
+		Listener: ln,
+		ConnPolicy: func(opts proxyproto.ConnPolicyOptions) (proxyproto.Policy, error) {
+			if types.ContainsAddr(trusted, sourceAddr(opts.Upstream)) {
+				return proxyproto.USE, nil
+			}
+			return proxyproto.REJECT, nil
+		},
+		ReadHeaderTimeout: proxyHeaderTimeout,
+	}
+	l := &proxyListener{
+		Listener: pl,
+		accepted: make(chan acceptResult),
+		closed:   make(chan struct{}),
+	}
+	go l.serve()
+	return l, nil
+}
+
+// serve accepts the connections and receives their headers until the
+// listener is closed.
+func (l *proxyListener) serve() {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			if !l.deliver(acceptResult{err: err}) || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		go l.receiveHeader(conn)
+	}
+}
+
+// receiveHeader receives the header of the connection, and passes the
+// connection to Accept if the header is valid or absent.
+func (l *proxyListener) receiveHeader(conn net.Conn) {
+
+	if pc, ok := conn.(*proxyproto.Conn); ok {
+		// Empty read receives the header without consuming the data
+		if _, err := pc.Read(nil); err != nil {
+			ctrllog.Log.WithName("ssh").V(1).Info("Connection rejected: invalid PROXY protocol header",
+				"upstream", sourceIP(pc.Raw().RemoteAddr()), "reason", err.Error())
+			metrics.Connections.WithLabelValues(metrics.ResultRejected).Inc()
+			conn.Close()
+			return
+		}
+	}
+	if !l.deliver(acceptResult{conn: conn}) {
+		conn.Close()
+	}
+}
+
+// deliver passes the result to Accept. Returns false if the listener is
+// closed.
+func (l *proxyListener) deliver(result acceptResult) bool {
+	select {
+	case l.accepted <- result:
+		return true
+	case <-l.closed:
+		return false
+	}
+}
+
+func (l *proxyListener) Accept() (net.Conn, error) {
+	select {
+	case result := <-l.accepted:
+		return result.conn, result.err
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *proxyListener) Close() error {
+	l.closeOnce.Do(func() { close(l.closed) })
+	return l.Listener.Close()
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/pires/go-proxyproto"
+
+	ingssh "kuberstein.io/ingressh/api/v1"
+	"kuberstein.io/ingressh/internal/types"
+)
+
+func TestSourceFilter(t *testing.T) {
+
+	var f SourceFilter
+	if !f.Allows(netip.MustParseAddr("203.0.113.7")) {
+		t.Errorf("expected all addresses to be allowed until the lists are set")
+	}
+	if err := f.SetLists([]string{"10.0.0.0/33"}, nil); err == nil {
+		t.Errorf("expected the invalid allow-list to be rejected")
+	}
+
+	if err := f.SetLists([]string{"10.0.0.0/8", "192.168.0.0/16"}, []string{"10.66.0.0/16"}); err != nil {
+		t.Fatalf("SetLists() error = %v", err)
+	}
+	tests := []struct {
+		addr    string
+		allowed bool
+	}{
+		{"10.1.2.3", true},
+		{"192.168.1.1", true},
+		{"10.66.1.1", false},
+		{"172.16.0.1", false},
+	}
+	for _, tt := range tests {
+		if got := f.Allows(netip.MustParseAddr(tt.addr)); got != tt.allowed {
+			t.Errorf("Allows(%s) = %v, expected %v", tt.addr, got, tt.allowed)
+		}
+	}
+
+	// The lists are replaced on the reload of the configuration
+	if err := f.SetLists(nil, []string{"10.0.0.0/8"}); err != nil {
+		t.Fatalf("SetLists() error = %v", err)
+	}
+	if f.Allows(netip.MustParseAddr("10.1.2.3")) || !f.Allows(netip.MustParseAddr("172.16.0.1")) {
+		t.Errorf("expected the new lists to apply")
+	}
+}
+
+func TestSshConfigAllowsSource(t *testing.T) {
+
+	tests := []struct {
+		ranges  []string
+		addr    string
+		allowed bool
+	}{
+		{nil, "203.0.113.7", true},
+		{[]string{"10.0.0.0/8"}, "10.1.2.3", true},
+		{[]string{"10.0.0.0/8"}, "203.0.113.7", false},
+		{[]string{"invalid", "203.0.113.0/24"}, "203.0.113.7", true},
+		{[]string{"invalid"}, "203.0.113.7", false},
+	}
+	for _, tt := range tests {
+		config := &types.SshConfig{}
+		config.SourceRanges = tt.ranges
+		if got := config.AllowsSource(netip.MustParseAddr(tt.addr)); got != tt.allowed {
+			t.Errorf("AllowsSource(%s) with %v = %v, expected %v", tt.addr, tt.ranges, got, tt.allowed)
+		}
+	}
+
+	configs := filterSourceRanges([]*types.SshConfig{
+		{Name: "any"},
+		{Name: "vpn", IngreSshSpec: ingssh.IngreSshSpec{SourceRanges: []string{"10.0.0.0/8"}}},
+	}, netip.MustParseAddr("203.0.113.7"))
+	if len(configs) != 1 || configs[0].Name != "any" {
+		t.Errorf("expected only the unrestricted route, got %v", configs)
+	}
+}
+
+func TestProxyListener(t *testing.T) {
+
+	v2 := &proxyproto.Header{
+		Version:           2,
+		Command:           proxyproto.PROXY,
+		TransportProtocol: proxyproto.TCPv6,
+		SourceAddr:        &net.TCPAddr{IP: net.ParseIP("2001:db8::7"), Port: 51234},
+		DestinationAddr:   &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 8022},
+	}
+	v2Header, err := v2.Format()
+	if err != nil {
+		t.Fatalf("unable to format v2 header: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		trusted  []string
+		header   string
+		remoteIP string
+		accepted bool
+	}{
+		{"v1 from trusted upstream", []string{"127.0.0.0/8"}, "PROXY TCP4 203.0.113.7 10.0.0.1 51234 8022\r\n", "203.0.113.7", true},
+		{"v2 from trusted upstream", []string{"127.0.0.1"}, string(v2Header), "2001:db8::7", true},
+		{"no header from trusted upstream", []string{"127.0.0.0/8"}, "", "127.0.0.1", true},
+		{"invalid header from trusted upstream", []string{"127.0.0.0/8"}, "PROXY TCP4 nonsense\r\n", "", false},
+		{"header from untrusted upstream", []string{"10.0.0.0/8"}, "PROXY TCP4 203.0.113.7 10.0.0.1 51234 8022\r\n", "", false},
+		{"no header from untrusted upstream", []string{"10.0.0.0/8"}, "", "127.0.0.1", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("unable to listen: %v", err)
+			}
+			pl, err := ProxyListener(ln, tt.trusted)
+			if err != nil {
+				t.Fatalf("ProxyListener() error = %v", err)
+			}
+			defer pl.Close()
+
+			client, err := net.Dial("tcp", ln.Addr().String())
+			if err != nil {
+				t.Fatalf("unable to connect: %v", err)
+			}
+			defer client.Close()
+			if _, err := client.Write([]byte(tt.header + "SSH-2.0-test\r\n")); err != nil {
+				t.Fatalf("unable to write: %v", err)
+			}
+
+			accepted := make(chan net.Conn, 1)
+			go func() {
+				if conn, err := pl.Accept(); err == nil {
+					accepted <- conn
+				}
+			}()
+
+			select {
+			case conn := <-accepted:
+				defer conn.Close()
+				if !tt.accepted {
+					t.Fatalf("expected the connection to be rejected")
+				}
+				if got := sourceIP(conn.RemoteAddr()); got != tt.remoteIP {
+					t.Errorf("RemoteAddr() = %s, expected %s", got, tt.remoteIP)
+				}
+				buf := make([]byte, len("SSH-2.0-test\r\n"))
+				if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "SSH-2.0-test\r\n" {
+					t.Errorf("expected the data after the header, got %q, %v", buf, err)
+				}
+			case <-time.After(500 * time.Millisecond):
+				if tt.accepted {
+					t.Fatalf("expected the connection to be accepted")
+				}
+			}
+		})
+	}
+
+	if _, err := ProxyListener(nil, nil); err == nil {
+		t.Errorf("expected the error without the trusted upstreams")
+	}
+}
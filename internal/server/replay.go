@@ -0,0 +1,396 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/gliderlabs/ssh"
+	"github.com/go-logr/logr"
+
+	"kuberstein.io/ingressh/internal/audit"
+	"kuberstein.io/ingressh/internal/recording"
+	"kuberstein.io/ingressh/internal/types"
+)
+
+// replayUser is the login name reserved for the auditors replaying the
+// recorded sessions.
+const replayUser = "replay"
+
+// replay serves the session of the auditor: lists the recordings of the
+// routes the user audits, searches them, and plays them back. Returns the
+// exit code for the session.
+//
+// Supported commands are:
+//
+//	search <text>  lists the recordings with the text in the output
+//	cat <name>     prints the recording in asciicast v2 format
+func replay(sess ssh.Session, recordings recording.Sink) int {
+
+	if recordings == nil {
+		fmt.Fprintf(sess, "Session recording is not configured\n")
+		return 4
+	}
+
+	routes := auditedRoutes(GetSshConfigsFromCtx(sess.Context()), GetAuthorizedKeyFromCtx(sess.Context()))
+	if len(routes) == 0 {
+		denied := auditEvent(sess.Context(), audit.EventAuthzDenied)
+		denied.Reason = "not an auditor"
+		audit.Log(denied)
+		fmt.Fprintf(sess, "Not authorized to replay the sessions\n")
+		return 10
+	}
+
+	all, err := recordings.List()
+	if err != nil {
+		Logger(sess.Context()).Error(err, "Unable to list the recordings")
+		fmt.Fprintf(sess, "Unable to list the recordings\n")
+		return 3
+	}
+	authorized := []recording.Recording{}
+	for _, r := range all {
+		if routes[r.Route] {
+			authorized = append(authorized, r)
+		}
+	}
+	sort.Slice(authorized, func(i, j int) bool {
+		return authorized[i].StartTime.After(authorized[j].StartTime)
+	})
+
+	title := "Select a recording"
+	command := sess.Command()
+	switch {
+	case len(command) == 0:
+
+	case command[0] == "search" && len(command) > 1:
+		text := strings.Join(command[1:], " ")
+		authorized = searchRecordings(Logger(sess.Context()), recordings, authorized, text)
+		title = fmt.Sprintf("Recordings with '%s'", text)
+
+	case command[0] == "cat" && len(command) == 2:
+		return catRecording(sess, recordings, authorized, command[1])
+
+	default:
+		fmt.Fprintf(sess.Stderr(), "Usage: ssh %s@server [search <text> | cat <name>]\n", replayUser)
+		return 2
+	}
+
+	_, _, isPty := sess.Pty()
+	if !isPty {
+		for _, r := range authorized {
+			fmt.Fprintf(sess, "%s\t%s\n", r.Name, recordingTitle(r))
+		}
+		return 0
+	}
+	if len(authorized) == 0 {
+		fmt.Fprintf(sess, "No recordings found\n")
+		return 0
+	}
+
+	// The input is read by the single reader for both the selection list
+	// and the player, so no keystrokes are lost when switching between them.
+	input := make(chan []byte)
+	go readInput(sess.Context(), sess, input)
+
+	selected, err := selectRecording(sess, input, authorized, title)
+	if err != nil {
+		Logger(sess.Context()).Error(err, "Error on interactive recording select")
+		return 3
+	}
+	if selected == nil {
+		return 0
+	}
+
+	Logger(sess.Context()).Info("User replays the recording", "recording", selected.Name)
+	auditReplay(sess.Context(), *selected)
+	cast, err := openCast(recordings, selected.Name)
+	if err != nil {
+		Logger(sess.Context()).Error(err, "Unable to replay the recording")
+		fmt.Fprintf(sess, "Unable to open the recording\n")
+		return 3
+	}
+
+	fmt.Fprintf(sess, "Replaying %s: space to pause, +/- to change speed, arrows to seek, q to quit\r\n", selected.Name)
+	controls := make(chan recording.Control)
+	go readControls(sess.Context(), input, controls)
+
+	err = recording.NewPlayer(cast, sess, 1).Play(sess.Context(), controls)
+	if err != nil {
+		Logger(sess.Context()).Error(err, "Unable to replay the recording", "recording", selected.Name)
+		return 3
+	}
+	fmt.Fprintf(sess, "\r\nReplay finished\r\n")
+	return 0
+}
+
+// auditedRoutes returns the set of the routes which recordings the user
+// authenticated with the authorizedKey may replay.
+func auditedRoutes(configs []*types.SshConfig, authorizedKey string) map[string]bool {
+	routes := map[string]bool{}
+	for _, c := range configs {
+		if c.IsAuditor(authorizedKey) {
+			routes[c.Route()] = true
+		}
+	}
+	return routes
+}
+
+func openCast(recordings recording.Sink, name string) (*recording.Cast, error) {
+	r, err := recordings.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open recording %s: %w", name, err)
+	}
+	defer r.Close()
+
+	cast, err := recording.ReadCast(r)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read recording %s: %w", name, err)
+	}
+	return cast, nil
+}
+
+// searchRecordings returns the recordings with all the words of the text in
+// the output. The indexed recordings are matched by their index, so only the
+// recordings stored without the index are read, and both are matched the
+// same way: the whole words, in any order.
+func searchRecordings(logger logr.Logger, recordings recording.Sink, candidates []recording.Recording, text string) []recording.Recording {
+	words := recording.Words(text)
+	found := []recording.Recording{}
+	for _, r := range candidates {
+		if r.Indexed {
+			index, err := recordings.Index(r.Name)
+			if err == nil {
+				if recording.ContainsWords(index, words) {
+					found = append(found, r)
+				}
+				continue
+			}
+			logger.Error(err, "Unable to read the index of the recording", "recording", r.Name)
+		}
+		cast, err := openCast(recordings, r.Name)
+		if err != nil {
+			logger.Error(err, "Unable to search the recording")
+			continue
+		}
+		if cast.ContainsWords(words) {
+			found = append(found, r)
+		}
+	}
+	return found
+}
+
+func catRecording(sess ssh.Session, recordings recording.Sink, authorized []recording.Recording, name string) int {
+	for _, r := range authorized {
+		if r.Name != name {
+			continue
+		}
+		auditReplay(sess.Context(), r)
+		cast, err := recordings.Open(name)
+		if err != nil {
+			Logger(sess.Context()).Error(err, "Unable to open the recording", "recording", name)
+			fmt.Fprintf(sess.Stderr(), "Unable to open the recording\n")
+			return 3
+		}
+		defer cast.Close()
+		if _, err := io.Copy(sess, cast); err != nil {
+			Logger(sess.Context()).Error(err, "Unable to read the recording", "recording", name)
+			return 3
+		}
+		return 0
+	}
+	fmt.Fprintf(sess.Stderr(), "Recording %s is not found\n", name)
+	return 13
+}
+
+// auditReplay logs the access of the auditor to the recording.
+func auditReplay(ctx ssh.Context, r recording.Recording) {
+	e := auditEvent(ctx, audit.EventRecordingReplay)
+	e.Route = r.Route
+	e.Namespace = r.Namespace
+	e.Pod = r.Pod
+	e.Container = r.Container
+	e.Recording = r.Name
+	audit.Log(e)
+}
+
+// recordingTitle describes the recording in the lists.
+func recordingTitle(r recording.Recording) string {
+	duration := r.EndTime.Sub(r.StartTime).Round(time.Second)
+	return fmt.Sprintf("%s %s@%s/%s/%s %s exit %d",
+		r.StartTime.Local().Format("2006-01-02 15:04"), r.User, r.Namespace, r.Pod, r.Container,
+		duration, r.ExitCode)
+}
+
+// readInput reads the session input into the channel until the end of it.
+func readInput(ctx context.Context, sess io.Reader, input chan<- []byte) {
+	defer close(input)
+	for {
+		buf := make([]byte, 256)
+		n, err := sess.Read(buf)
+		if n > 0 {
+			select {
+			case input <- buf[:n]:
+			case <-ctx.Done():
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// inputReader reads the session input from the channel until it is stopped.
+// A stopped reader never consumes the input, so it can be handed over to
+// the next consumer.
+type inputReader struct {
+	input   <-chan []byte
+	pending []byte
+	stopped chan struct{}
+}
+
+func (r *inputReader) Read(p []byte) (int, error) {
+	if len(r.pending) == 0 {
+		select {
+		case data, ok := <-r.input:
+			if !ok {
+				return 0, io.EOF
+			}
+			r.pending = data
+		case <-r.stopped:
+			return 0, io.EOF
+		}
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+// readControls translates the keys pressed by the user into the controls
+// of the player.
+func readControls(ctx context.Context, input <-chan []byte, controls chan<- recording.Control) {
+	defer close(controls)
+
+	keys := map[string]recording.Control{
+		" ":      recording.ControlPause,
+		"+":      recording.ControlFaster,
+		"=":      recording.ControlFaster,
+		"-":      recording.ControlSlower,
+		"\x1b[C": recording.ControlForward,
+		"l":      recording.ControlForward,
+		"\x1b[D": recording.ControlBackward,
+		"h":      recording.ControlBackward,
+		"q":      recording.ControlQuit,
+		"\x03":   recording.ControlQuit,
+		"\x04":   recording.ControlQuit,
+	}
+
+	// Arrow keys are sent as the escape sequences, which may be split
+	// between the reads.
+	escape := ""
+	for data := range input {
+		for _, b := range data {
+			key := escape + string(b)
+			switch key {
+			case "\x1b", "\x1b[":
+				escape = key
+				continue
+			}
+			escape = ""
+
+			control, ok := keys[key]
+			if !ok {
+				continue
+			}
+			select {
+			case controls <- control:
+			case <-ctx.Done():
+				return
+			}
+			if control == recording.ControlQuit {
+				return
+			}
+		}
+	}
+}
+
+type replayModel struct {
+	list       list.Model
+	recordings []recording.Recording
+	selected   *recording.Recording
+	quitting   bool
+}
+
+func (m replayModel) Init() tea.Cmd {
+	return nil
+}
+
+func (m replayModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.list.SetWidth(msg.Width)
+		return m, nil
+
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "ctrl+c", "esc", "q":
+			m.quitting = true
+			return m, tea.Quit
+		case "enter":
+			m.selected = &m.recordings[m.list.Index()]
+			return m, tea.Quit
+		}
+	}
+
+	var cmd tea.Cmd
+	m.list, cmd = m.list.Update(msg)
+	return m, cmd
+}
+
+func (m replayModel) View() string {
+	if m.selected != nil || m.quitting {
+		return ""
+	}
+	return "\n" + m.list.View()
+}
+
+// selectRecording returns the recording selected by the user, or nil if the
+// user has cancelled the selection.
+func selectRecording(sess ssh.Session, input <-chan []byte, recordings []recording.Recording, title string) (
+	*recording.Recording, error,
+) {
+
+	items := []list.Item{}
+	for _, r := range recordings {
+		items = append(items, item(recordingTitle(r)))
+	}
+
+	l := list.New(items, itemDelegate{}, defaultListWidth, defaultListHeight)
+	l.Title = title
+	l.SetShowStatusBar(false)
+	l.SetFilteringEnabled(false)
+	l.Styles.Title = titleStyle
+	l.Styles.PaginationStyle = paginationStyle
+	l.Styles.HelpStyle = helpStyle
+
+	reader := &inputReader{input: input, stopped: make(chan struct{})}
+	defer close(reader.stopped)
+
+	p := tea.NewProgram(replayModel{list: l, recordings: recordings},
+		tea.WithOutput(sess), tea.WithInput(reader), tea.WithContext(sess.Context()))
+	result, err := p.Run()
+	if err != nil {
+		return nil, err
+	}
+
+	m, ok := result.(replayModel)
+	if !ok {
+		return nil, nil
+	}
+	return m.selected, nil
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+
+	"kuberstein.io/ingressh/internal/recording"
+)
+
+// castSink serves the recordings and their indexes from memory and counts
+// the opened ones.
+type castSink struct {
+	recording.Sink
+	casts   map[string]string
+	indexes map[string][]string
+	opened  []string
+	indexed []string
+}
+
+func (s *castSink) Open(name string) (io.ReadCloser, error) {
+	s.opened = append(s.opened, name)
+	cast, ok := s.casts[name]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return io.NopCloser(strings.NewReader(cast)), nil
+}
+
+func (s *castSink) Index(name string) ([]string, error) {
+	s.indexed = append(s.indexed, name)
+	index, ok := s.indexes[name]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return index, nil
+}
+
+func TestSearchRecordings(t *testing.T) {
+
+	// The same session recorded with and without the index
+	output := "$ kubectl delete pod nginx\r\npod \"nginx\" deleted\r\n"
+	cast := `{"version":2,"width":80,"height":24,"timestamp":1700000000}
+[0.5,"o","$ kubectl delete pod nginx\r\npod \"nginx\" deleted\r\n"]
+`
+	sink := &castSink{
+		casts: map[string]string{"old": cast, "unindexed": cast},
+		indexes: map[string][]string{
+			"indexed": recording.Words(output),
+			"other":   recording.Words("kubectl get pods"),
+		},
+	}
+	candidates := []recording.Recording{
+		{Name: "indexed", Metadata: recording.Metadata{Indexed: true}},
+		{Name: "other", Metadata: recording.Metadata{Indexed: true}},
+		{Name: "old"},
+		// The index is lost, the recording is searched by its output
+		{Name: "unindexed", Metadata: recording.Metadata{Indexed: true}},
+		{Name: "missing"},
+	}
+
+	tests := []struct {
+		text     string
+		expected string
+	}{
+		{"Kubectl Delete", "indexed,old,unindexed"},
+		{"deleted nginx", "indexed,old,unindexed"},
+		{"kube", ""},
+		{"ctl delete", ""},
+		{"kubectl get", "other"},
+	}
+	for _, tt := range tests {
+		sink.opened, sink.indexed = nil, nil
+		found := searchRecordings(logr.Discard(), sink, candidates, tt.text)
+		names := []string{}
+		for _, r := range found {
+			names = append(names, r.Name)
+		}
+		if strings.Join(names, ",") != tt.expected {
+			t.Errorf("%q: unexpected recordings found %q", tt.text, names)
+		}
+		// Only the indexes of the indexed recordings are read, and only the
+		// recordings without the index are read
+		if strings.Join(sink.indexed, ",") != "indexed,other,unindexed" {
+			t.Errorf("%q: unexpected indexes read %q", tt.text, sink.indexed)
+		}
+		if strings.Join(sink.opened, ",") != "old,unindexed,missing" {
+			t.Errorf("%q: unexpected recordings read %q", tt.text, sink.opened)
+		}
+	}
+}
@@ -4,7 +4,7 @@ import (
 	"errors"
 	"sync"
 
-	log "github.com/sirupsen/logrus"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
 
 	"kuberstein.io/ingressh/internal/types"
 )
@@ -22,6 +22,8 @@ var Routes = RoutingTable{
 	routes:  make(map[string][]*types.SshConfig),
 }
 
+var routesLog = ctrllog.Log.WithName("routes")
+
 func (r *RoutingTable) GetUsername(authorizedKey string) (string, error) {
 
 	r.mutex.RLock()
@@ -29,7 +31,7 @@ func (r *RoutingTable) GetUsername(authorizedKey string) (string, error) {
 
 	existing, ok := r.routes[authorizedKey]
 	if !ok {
-		log.Errorf("No user with the authorized key: %s", authorizedKey)
+		routesLog.Info("No user with the authorized key", "fingerprint", fingerprint(authorizedKey))
 		return "", errors.New("authentication failure")
 	}
 
@@ -118,7 +120,7 @@ func (r *RoutingTable) Get(authorizedKey string) ([]*types.SshConfig, error) {
 
 	existing, ok := r.routes[authorizedKey]
 	if !ok {
-		log.Errorf("No user with the authorized key: %s", authorizedKey)
+		routesLog.Info("No user with the authorized key", "fingerprint", fingerprint(authorizedKey))
 		return nil, errors.New("authentication failure")
 	}
 
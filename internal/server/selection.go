@@ -0,0 +1,118 @@
+package server
+
+import (
+	"hash/fnv"
+	"math/rand/v2"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+
+	ing "kuberstein.io/ingressh/api/v1"
+	"kuberstein.io/ingressh/internal/types"
+)
+
+// usablePod returns true if the pod could host a new SSH session: it is
+// running, not terminating, and none of its containers is crash looping.
+func usablePod(pod corev1.Pod) bool {
+	if pod.DeletionTimestamp != nil {
+		return false
+	}
+	if pod.Status.Phase != corev1.PodRunning {
+		return false
+	}
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.State.Waiting != nil && status.State.Waiting.Reason == "CrashLoopBackOff" {
+			return false
+		}
+	}
+	return true
+}
+
+// readyPod returns true if the pod has the Ready condition.
+func readyPod(pod corev1.Pod) bool {
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// OrderPods filters out the pods which can't host a new session and orders
+// the rest, the ready pods first, accordingly to the pod selection policy, so
+// the first pod is the one to choose automatically.
+//
+// The pod exactly named by the user's hint is never filtered out, as the user
+// may want to debug it regardless of its state.
+//
+// When the pods are authorized by several resources, the policy of the first
+// one applies.
+func (a authz) OrderPods(podConfigs []podSshConfig, hintPod string) []podSshConfig {
+
+	if len(podConfigs) == 0 || types.ParsePodRef(hintPod).IsExact() {
+		return podConfigs
+	}
+
+	usable := []podSshConfig{}
+	for _, p := range podConfigs {
+		if usablePod(p.pod) {
+			usable = append(usable, p)
+		}
+	}
+
+	// Start with the stable order, which the policies are refining.
+	sort.SliceStable(usable, func(i, j int) bool {
+		return usable[i].pod.Name < usable[j].pod.Name
+	})
+
+	switch podConfigs[0].config.PodSelection {
+	case ing.PodSelectionRandom:
+		rand.Shuffle(len(usable), func(i, j int) {
+			usable[i], usable[j] = usable[j], usable[i]
+		})
+
+	case ing.PodSelectionLeastSessions:
+		sessions := make(map[string]int, len(usable))
+		for _, p := range usable {
+			sessions[p.pod.Name] = Sessions.CountByPod(p.pod.Namespace, p.pod.Name)
+		}
+		sort.SliceStable(usable, func(i, j int) bool {
+			return sessions[usable[i].pod.Name] < sessions[usable[j].pod.Name]
+		})
+
+	case ing.PodSelectionSticky:
+		// Rendezvous hashing keeps the user on the same pod as long as it
+		// exists, and moves only the users of the gone pods.
+		user := a.stickyIdentity()
+		weights := make(map[string]uint64, len(usable))
+		for _, p := range usable {
+			h := fnv.New64a()
+			h.Write([]byte(user + "/" + p.pod.Name))
+			weights[p.pod.Name] = h.Sum64()
+		}
+		sort.SliceStable(usable, func(i, j int) bool {
+			return weights[usable[i].pod.Name] > weights[usable[j].pod.Name]
+		})
+	}
+
+	// The ready pods go first with any policy, the policy orders the pods of
+	// the same readiness.
+	sort.SliceStable(usable, func(i, j int) bool {
+		return readyPod(usable[i].pod) && !readyPod(usable[j].pod)
+	})
+
+	return usable
+}
+
+// stickyIdentity returns the identity to stick the user's sessions with:
+// the login name configured for the authorized key, or the key itself.
+func (a authz) stickyIdentity() string {
+	for _, c := range a.authorizedConfigs {
+		for _, auth := range c.AuthorizedKeys {
+			if auth.Key == a.authorizedKey && auth.User != "" {
+				return auth.User
+			}
+		}
+	}
+	return a.authorizedKey
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/gliderlabs/ssh"
+	"github.com/go-logr/logr"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+
+	ing "kuberstein.io/ingressh/api/v1"
+)
+
+// sessionObjectTimeout limits the API calls managing the session objects,
+// so the sessions don't hang on the unavailable API server.
+const sessionObjectTimeout = 10 * time.Second
+
+// SessionObjectStore materializes the active sessions as IngreSshSession
+// resources, so the sessions could be listed and terminated with kubectl.
+type SessionObjectStore struct {
+	client client.Client
+	// server is the name of the server pod the sessions belong to
+	server string
+}
+
+// SessionObjects manages the resources of the sessions. No resources are
+// created until the client is set.
+var SessionObjects = SessionObjectStore{}
+
+// Init sets the client to manage the resources, labelling them with the name
+// of the server.
+func (o *SessionObjectStore) Init(c client.Client, server string) {
+	o.client = c
+	o.server = server
+}
+
+// Create creates the resource of the session in the namespace of its route.
+// The session must be registered already, so the resource is never
+// considered stale.
+func (o *SessionObjectStore) Create(sess ssh.Session, session *ActiveSession, readOnly bool) {
+
+	if o.client == nil {
+		return
+	}
+
+	obj := &ing.IngreSshSession{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      sessionObjectName(session.ID),
+			Namespace: session.Config.Namespace,
+			Labels:    map[string]string{ing.LabelServer: o.server},
+		},
+		Spec: ing.IngreSshSessionSpec{
+			SessionID:    session.ID,
+			ConnectionID: session.ConnectionID,
+			User:         session.User,
+			Fingerprint:  fingerprint(GetAuthorizedKeyFromCtx(sess.Context())),
+			SourceIP:     sourceIP(sess.RemoteAddr()),
+			Namespace:    session.Target.Namespace,
+			Pod:          session.Target.Pod,
+			Container:    session.Target.Container,
+			Mode:         session.Config.Session,
+			ReadOnly:     readOnly,
+			Command:      sess.Command(),
+			StartTime:    metav1.NewTime(session.Started),
+		},
+	}
+	if session.Config.UID != "" {
+		obj.OwnerReferences = []metav1.OwnerReference{{
+			APIVersion: ing.GroupVersion.String(),
+			Kind:       "IngreSsh",
+			Name:       session.Config.Name,
+			UID:        session.Config.UID,
+		}}
+	}
+
+	session.Object = k8stypes.NamespacedName{Namespace: obj.Namespace, Name: obj.Name}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sessionObjectTimeout)
+	defer cancel()
+	if err := o.client.Create(ctx, obj); err != nil {
+		sessionLog(session).Error(err, "Unable to create session resource", "object", session.Object)
+	}
+}
+
+// SetDebugContainer records the ephemeral container the session is attached
+// to in the resource of the session.
+func (o *SessionObjectStore) SetDebugContainer(session *ActiveSession, container string) {
+
+	if o.client == nil || session.Object.Name == "" {
+		return
+	}
+
+	obj := &ing.IngreSshSession{ObjectMeta: metav1.ObjectMeta{
+		Name:      session.Object.Name,
+		Namespace: session.Object.Namespace,
+	}}
+	patch, err := json.Marshal(map[string]interface{}{
+		"spec": map[string]string{"debugContainer": container},
+	})
+	if err != nil {
+		sessionLog(session).Error(err, "Unable to encode session resource patch")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sessionObjectTimeout)
+	defer cancel()
+	if err := o.client.Patch(ctx, obj, client.RawPatch(k8stypes.MergePatchType, patch)); err != nil {
+		sessionLog(session).Error(err, "Unable to update session resource", "object", session.Object)
+	}
+}
+
+// Delete deletes the resource of the ended session.
+func (o *SessionObjectStore) Delete(session *ActiveSession) {
+
+	if o.client == nil || session.Object.Name == "" {
+		return
+	}
+
+	obj := &ing.IngreSshSession{ObjectMeta: metav1.ObjectMeta{
+		Name:      session.Object.Name,
+		Namespace: session.Object.Namespace,
+	}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sessionObjectTimeout)
+	defer cancel()
+	if err := o.client.Delete(ctx, obj); client.IgnoreNotFound(err) != nil {
+		sessionLog(session).Error(err, "Unable to delete session resource", "object", session.Object)
+	}
+}
+
+// sessionObjectName returns the name of the session resource: the prefix of
+// the session ID with a random suffix, as several sessions multiplexed over
+// the same connection share the ID.
+func sessionObjectName(sessionID string) string {
+	if len(sessionID) > 12 {
+		sessionID = sessionID[:12]
+	}
+	return sessionID + "-" + utilrand.String(5)
+}
+
+// sessionLog returns the logger of the session.
+func sessionLog(session *ActiveSession) logr.Logger {
+	return ctrllog.Log.WithName("ssh").WithValues("session", session.ID)
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	k8stypes "k8s.io/apimachinery/pkg/types"
+
+	"kuberstein.io/ingressh/internal/types"
+)
+
+func TestSessionObjectName(t *testing.T) {
+
+	tests := []struct {
+		id     string
+		prefix string
+	}{
+		{"3f9a1c0b7d2e5a6b8c9d0e1f", "3f9a1c0b7d2e-"},
+		{"abc", "abc-"},
+	}
+
+	for _, tt := range tests {
+		name := sessionObjectName(tt.id)
+		if !strings.HasPrefix(name, tt.prefix) || len(name) != len(tt.prefix)+5 {
+			t.Errorf("sessionObjectName(%q) = %q, expected prefix %q and 5 random chars", tt.id, name, tt.prefix)
+		}
+	}
+
+	// Sessions multiplexed over the same connection share the ID
+	if sessionObjectName("abc") == sessionObjectName("abc") {
+		t.Errorf("expected distinct names for the same session ID")
+	}
+}
+
+func TestSessionRegistryTerminate(t *testing.T) {
+
+	r := SessionRegistry{
+		sessions:  make(map[*ActiveSession]struct{}),
+		lastLogin: make(map[string]time.Time),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	object := k8stypes.NamespacedName{Namespace: "ns", Name: "abc-x7k2p"}
+	session := &ActiveSession{
+		ID:      "abc",
+		Config:  &types.SshConfig{Name: "route", Namespace: "ns"},
+		Started: time.Now(),
+		Object:  object,
+		cancel:  cancel,
+	}
+	r.Add(session)
+
+	if !r.HasObject(object) {
+		t.Fatalf("expected the session of %s", object)
+	}
+	if r.Terminate(k8stypes.NamespacedName{Namespace: "ns", Name: "other"}) {
+		t.Errorf("expected no session of the other resource")
+	}
+	if session.Terminated() || ctx.Err() != nil {
+		t.Fatalf("expected the session to be active")
+	}
+
+	if !r.Terminate(object) {
+		t.Errorf("expected the session to be terminated")
+	}
+	if !session.Terminated() || ctx.Err() == nil {
+		t.Errorf("expected the session context to be cancelled")
+	}
+
+	r.Remove(session)
+	if r.HasObject(object) {
+		t.Errorf("expected no session of %s after removal", object)
+	}
+}
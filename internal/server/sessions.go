@@ -0,0 +1,316 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	k8stypes "k8s.io/apimachinery/pkg/types"
+
+	"kuberstein.io/ingressh/internal/metrics"
+	"kuberstein.io/ingressh/internal/types"
+)
+
+// ActiveSession describes the SSH session attached to the target container.
+type ActiveSession struct {
+	ID string
+	// ConnectionID is the session ID of the SSH connection, shared by the
+	// targets of a fan-out command
+	ConnectionID string
+	User         string
+	// Fingerprint of the key the user is authenticated with
+	Fingerprint string
+	Target      types.SshTarget
+	Config      *types.SshConfig
+	Started     time.Time
+
+	// Object is the IngreSshSession resource representing the session.
+	Object k8stypes.NamespacedName
+
+	cancel     context.CancelFunc
+	terminated atomic.Bool
+}
+
+// Terminate stops the streams of the session.
+func (s *ActiveSession) Terminate() {
+	s.terminated.Store(true)
+	if s.cancel != nil {
+		s.cancel()
+	}
+}
+
+// Terminated returns true if the session has been terminated.
+func (s *ActiveSession) Terminated() bool {
+	return s.terminated.Load()
+}
+
+// userKey identifies the user of the session for the limits: the user name
+// of the authorized key, or the fingerprint of the key if it has no name.
+func (s *ActiveSession) userKey() string {
+	if s.User != "" {
+		return s.User
+	}
+	return s.Fingerprint
+}
+
+// SessionRegistry keeps track of the active SSH sessions of the server.
+// Several sessions could share the same ID when multiplexed over a single
+// SSH connection, so the sessions are registered by reference.
+//
+// The registry enforces the limits of the concurrent sessions and of the
+// handshakes in flight.
+type SessionRegistry struct {
+	sessions map[*ActiveSession]struct{}
+	// lastLogin is the start time of the latest session of the route
+	lastLogin map[string]time.Time
+	onChange  func(config *types.SshConfig)
+	limits    types.LimitsConfig
+	// handshakes is the number of the connections not authenticated yet
+	handshakes int
+	mutex      sync.RWMutex
+}
+
+// LimitError is returned when the session would exceed one of the limits of
+// the concurrent sessions.
+type LimitError struct {
+	// Scope of the exceeded limit: user, pod, route or server
+	Scope string
+	Max   int
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("too many concurrent sessions per %s, the limit is %d", e.Scope, e.Max)
+}
+
+// Scopes of the session limits
+const (
+	LimitScopeUser   = "user"
+	LimitScopePod    = "pod"
+	LimitScopeRoute  = "route"
+	LimitScopeServer = "server"
+)
+
+var Sessions = SessionRegistry{
+	sessions:  make(map[*ActiveSession]struct{}),
+	lastLogin: make(map[string]time.Time),
+}
+
+// OnChange sets the callback invoked with the route configuration whenever
+// a session of the route starts or ends.
+func (r *SessionRegistry) OnChange(f func(config *types.SshConfig)) {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.onChange = f
+}
+
+// SetLimits sets the limits of the concurrent sessions and handshakes. The
+// limits apply to the new sessions only.
+func (r *SessionRegistry) SetLimits(limits types.LimitsConfig) {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.limits = limits
+}
+
+// Add registers the session as active.
+func (r *SessionRegistry) Add(session *ActiveSession) {
+
+	r.mutex.Lock()
+	onChange := r.add(session)
+	r.mutex.Unlock()
+
+	r.added(session, onChange)
+}
+
+// Admit registers the session as active, unless it exceeds one of the limits
+// of the concurrent sessions. Returns LimitError in this case.
+func (r *SessionRegistry) Admit(session *ActiveSession) error {
+
+	r.mutex.Lock()
+	if err := r.checkLimits(session); err != nil {
+		r.mutex.Unlock()
+		return err
+	}
+	onChange := r.add(session)
+	r.mutex.Unlock()
+
+	r.added(session, onChange)
+	return nil
+}
+
+// checkLimits returns LimitError if the session exceeds one of the limits.
+// The caller must hold the lock.
+func (r *SessionRegistry) checkLimits(session *ActiveSession) error {
+
+	var user, pod, route int
+	for s := range r.sessions {
+		if s.userKey() == session.userKey() {
+			user++
+		}
+		if s.Target.Namespace == session.Target.Namespace && s.Target.Pod == session.Target.Pod {
+			pod++
+		}
+		if s.Config.Route() == session.Config.Route() {
+			route++
+		}
+	}
+
+	checks := []struct {
+		scope string
+		count int
+		max   int
+	}{
+		{LimitScopeServer, len(r.sessions), r.limits.Sessions},
+		{LimitScopeUser, user, r.limits.SessionsPerUser},
+		{LimitScopePod, pod, r.limits.SessionsPerPod},
+		{LimitScopeRoute, route, r.limits.SessionsPerRoute},
+	}
+	for _, c := range checks {
+		if c.max > 0 && c.count >= c.max {
+			return &LimitError{Scope: c.scope, Max: c.max}
+		}
+	}
+	return nil
+}
+
+// add registers the session and returns the change callback to invoke. The
+// caller must hold the lock.
+func (r *SessionRegistry) add(session *ActiveSession) func(config *types.SshConfig) {
+
+	r.sessions[session] = struct{}{}
+	route := session.Config.Route()
+	if session.Started.After(r.lastLogin[route]) {
+		r.lastLogin[route] = session.Started
+	}
+	return r.onChange
+}
+
+// added accounts the registered session, out of the lock.
+func (r *SessionRegistry) added(session *ActiveSession, onChange func(config *types.SshConfig)) {
+
+	metrics.ActiveSessions.WithLabelValues(session.Target.Namespace, session.Config.Session).Inc()
+	if onChange != nil {
+		onChange(session.Config)
+	}
+}
+
+// Remove unregisters the session.
+func (r *SessionRegistry) Remove(session *ActiveSession) {
+
+	r.mutex.Lock()
+	delete(r.sessions, session)
+	onChange := r.onChange
+	r.mutex.Unlock()
+
+	metrics.ActiveSessions.WithLabelValues(session.Target.Namespace, session.Config.Session).Dec()
+	metrics.SessionDuration.WithLabelValues(session.Target.Namespace, session.Config.Session).
+		Observe(time.Since(session.Started).Seconds())
+
+	if onChange != nil {
+		onChange(session.Config)
+	}
+}
+
+// ByRoute returns the active sessions of the route.
+func (r *SessionRegistry) ByRoute(route string) []*ActiveSession {
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	sessions := []*ActiveSession{}
+	for s := range r.sessions {
+		if s.Config.Route() == route {
+			sessions = append(sessions, s)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].Started.Before(sessions[j].Started)
+	})
+	return sessions
+}
+
+// Terminate terminates the session represented by the IngreSshSession
+// resource. Returns false if there is no such session.
+func (r *SessionRegistry) Terminate(object k8stypes.NamespacedName) bool {
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	for s := range r.sessions {
+		if s.Object == object {
+			s.Terminate()
+			return true
+		}
+	}
+	return false
+}
+
+// HasObject returns true if the IngreSshSession resource represents an
+// active session of the server.
+func (r *SessionRegistry) HasObject(object k8stypes.NamespacedName) bool {
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	for s := range r.sessions {
+		if s.Object == object {
+			return true
+		}
+	}
+	return false
+}
+
+// LastLogin returns the start time of the latest session of the route since
+// the server start, or zero time if there were none.
+func (r *SessionRegistry) LastLogin(route string) time.Time {
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return r.lastLogin[route]
+}
+
+// CountByPod returns the number of active sessions attached to the pod.
+func (r *SessionRegistry) CountByPod(namespace string, pod string) int {
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	count := 0
+	for s := range r.sessions {
+		if s.Target.Namespace == namespace && s.Target.Pod == pod {
+			count++
+		}
+	}
+	return count
+}
+
+// StartHandshake accounts the new connection until the user is authenticated.
+// Returns false if the limit of the handshakes in flight is reached.
+func (r *SessionRegistry) StartHandshake() bool {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.limits.Handshakes > 0 && r.handshakes >= r.limits.Handshakes {
+		return false
+	}
+	r.handshakes++
+	return true
+}
+
+// EndHandshake releases the place of the connection among the handshakes in
+// flight, once the user is authenticated or the connection is closed.
+func (r *SessionRegistry) EndHandshake() {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.handshakes--
+}
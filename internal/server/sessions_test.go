@@ -0,0 +1,91 @@
+package server
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"kuberstein.io/ingressh/internal/types"
+)
+
+func TestSessionRegistryAdmit(t *testing.T) {
+
+	routeA := &types.SshConfig{Name: "a", Namespace: "ns"}
+	routeB := &types.SshConfig{Name: "b", Namespace: "ns"}
+	pod1 := types.SshTarget{Namespace: "ns", Pod: "pod-1", Container: "app"}
+	pod2 := types.SshTarget{Namespace: "ns", Pod: "pod-2", Container: "app"}
+
+	active := []*ActiveSession{
+		{User: "alice", Target: pod1, Config: routeA},
+		{User: "alice", Target: pod2, Config: routeA},
+		{User: "", Fingerprint: "SHA256:bob", Target: pod1, Config: routeB},
+	}
+
+	tests := []struct {
+		name    string
+		limits  types.LimitsConfig
+		session *ActiveSession
+		scope   string
+	}{
+		{"no limits", types.LimitsConfig{}, &ActiveSession{User: "alice", Target: pod1, Config: routeA}, ""},
+		{"server", types.LimitsConfig{Sessions: 3}, &ActiveSession{User: "carol", Target: pod2, Config: routeB}, LimitScopeServer},
+		{"user", types.LimitsConfig{SessionsPerUser: 2}, &ActiveSession{User: "alice", Target: pod2, Config: routeB}, LimitScopeUser},
+		{"other user", types.LimitsConfig{SessionsPerUser: 2}, &ActiveSession{User: "carol", Target: pod1, Config: routeA}, ""},
+		{"unnamed key", types.LimitsConfig{SessionsPerUser: 1}, &ActiveSession{Fingerprint: "SHA256:bob", Target: pod2, Config: routeA}, LimitScopeUser},
+		{"pod", types.LimitsConfig{SessionsPerPod: 2}, &ActiveSession{User: "carol", Target: pod1, Config: routeB}, LimitScopePod},
+		{"other pod", types.LimitsConfig{SessionsPerPod: 2}, &ActiveSession{User: "carol", Target: pod2, Config: routeB}, ""},
+		{"route", types.LimitsConfig{SessionsPerRoute: 2}, &ActiveSession{User: "carol", Target: pod2, Config: routeA}, LimitScopeRoute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			r := SessionRegistry{
+				sessions:  make(map[*ActiveSession]struct{}),
+				lastLogin: make(map[string]time.Time),
+			}
+			for _, s := range active {
+				s.Started = time.Now()
+				r.Add(s)
+			}
+			r.SetLimits(tt.limits)
+
+			tt.session.Started = time.Now()
+			err := r.Admit(tt.session)
+
+			var limitErr *LimitError
+			if tt.scope == "" {
+				if err != nil {
+					t.Fatalf("Admit() = %v, expected the session to be admitted", err)
+				}
+				if len(r.sessions) != len(active)+1 {
+					t.Errorf("expected the session to be registered")
+				}
+				return
+			}
+			if !errors.As(err, &limitErr) || limitErr.Scope != tt.scope {
+				t.Fatalf("Admit() = %v, expected the limit per %s", err, tt.scope)
+			}
+			if len(r.sessions) != len(active) {
+				t.Errorf("expected the rejected session not to be registered")
+			}
+		})
+	}
+}
+
+func TestSessionRegistryHandshakes(t *testing.T) {
+
+	r := SessionRegistry{}
+	r.SetLimits(types.LimitsConfig{Handshakes: 2})
+
+	if !r.StartHandshake() || !r.StartHandshake() {
+		t.Fatalf("expected 2 handshakes in flight")
+	}
+	if r.StartHandshake() {
+		t.Fatalf("expected the third handshake to be rejected")
+	}
+	r.EndHandshake()
+	if !r.StartHandshake() {
+		t.Errorf("expected the handshake to be admitted after the other one ended")
+	}
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"kuberstein.io/ingressh/internal/tracing"
+)
+
+var ctxKeyConnTrace = &contextKey{"conn_trace"}
+
+// errHandshakeFailed marks the handshake span of the connection closed
+// before the user has been authenticated.
+var errHandshakeFailed = errors.New("handshake failed")
+
+// connTrace holds the spans of the SSH connection: the connection span
+// lasting until the connection is closed, and its handshake child span
+// lasting until the user is authenticated.
+type connTrace struct {
+	span      trace.Span
+	handshake trace.Span
+
+	handshakeOnce sync.Once
+	closeOnce     sync.Once
+}
+
+// endHandshake ends the handshake span, failed if err is not nil.
+func (t *connTrace) endHandshake(err error) {
+	t.handshakeOnce.Do(func() {
+		tracing.EndSpan(t.handshake, err)
+	})
+}
+
+// tracedConn ends the spans of the connection when it is closed.
+type tracedConn struct {
+	net.Conn
+	trace *connTrace
+}
+
+func (c *tracedConn) Close() error {
+	c.trace.closeOnce.Do(func() {
+		c.trace.endHandshake(errHandshakeFailed)
+		c.trace.span.End()
+	})
+	return c.Conn.Close()
+}
+
+// startConnTrace starts the spans of the accepted connection. Returns the
+// context with the connection span.
+func startConnTrace(sessionID string, conn net.Conn) (context.Context, *connTrace) {
+
+	ctx, span := tracing.Tracer().Start(context.Background(), "ssh.connection",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("ingressh.session.id", sessionID),
+			attribute.String("client.address", sourceIP(conn.RemoteAddr())),
+		),
+	)
+	_, handshake := tracing.Tracer().Start(ctx, "ssh.handshake")
+
+	return ctx, &connTrace{span: span, handshake: handshake}
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracedConn(t *testing.T) {
+
+	tests := []struct {
+		name          string
+		authenticated bool
+		status        codes.Code
+	}{
+		{"authenticated", true, codes.Unset},
+		{"handshake failed", false, codes.Error},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			recorder := tracetest.NewSpanRecorder()
+			tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test")
+
+			ctx, span := tracer.Start(context.Background(), "ssh.connection")
+			_, handshake := tracer.Start(ctx, "ssh.handshake")
+			trace := &connTrace{span: span, handshake: handshake}
+
+			client, server := net.Pipe()
+			defer client.Close()
+			conn := &tracedConn{Conn: server, trace: trace}
+
+			if tt.authenticated {
+				trace.endHandshake(nil)
+			}
+			conn.Close()
+			conn.Close()
+
+			ended := recorder.Ended()
+			if len(ended) != 2 {
+				t.Fatalf("expected 2 ended spans, got %d", len(ended))
+			}
+			if ended[0].Name() != "ssh.handshake" || ended[1].Name() != "ssh.connection" {
+				t.Errorf("unexpected spans order: %s, %s", ended[0].Name(), ended[1].Name())
+			}
+			if ended[0].Status().Code != tt.status {
+				t.Errorf("expected handshake status %v, got %v", tt.status, ended[0].Status().Code)
+			}
+			if ended[0].Parent().SpanID() != ended[1].SpanContext().SpanID() {
+				t.Errorf("expected handshake to be the child of the connection span")
+			}
+		})
+	}
+}
@@ -1,12 +1,37 @@
 package server
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"sort"
+	"strings"
 
 	"github.com/gliderlabs/ssh"
 	"kuberstein.io/ingressh/internal/types"
 )
 
+// strictEnv is the environment variable the client sets to request the
+// strict mode of the target selection.
+const strictEnv = "INGRESSH_STRICT"
+
+var (
+	// ErrAmbiguousTarget is returned in the strict mode if the user's request
+	// matches several targets.
+	ErrAmbiguousTarget = errors.New("ambiguous target")
+)
+
+// candidate is a target matching the user's request. The list of candidates
+// is reported in JSON when the strict mode fails to choose the target.
+type candidate struct {
+	Namespace string `json:"namespace"`
+	Pod       string `json:"pod"`
+	Container string `json:"container"`
+	// Login is the login string to select the candidate unambiguously.
+	Login string `json:"login"`
+}
+
 // Returns attach target and pod+configuration as a result of the
 // automatic selection.
 func automatic(sess ssh.Session, targetAuth authz, hint types.SshTarget) (
@@ -16,8 +41,9 @@ func automatic(sess ssh.Session, targetAuth authz, hint types.SshTarget) (
 	var target types.SshTarget
 	var targetPodConfig podSshConfig
 
-	fmt.Fprintf(sess, "Hello %s, please wait while we are searching pods to set SSH connection to\n", sess.User())
-	fmt.Fprintf(sess, "Note that at present you will connect to the first authorized pod\n")
+	fmt.Fprintf(sess, "Hello %s, your session ID is %s\n", sess.User(), GetSessionIDFromCtx(sess.Context()))
+	fmt.Fprintf(sess, "Please wait while we are searching pods to set SSH connection to\n")
+	fmt.Fprintf(sess, "Note that the pod is chosen automatically accordingly to the pod selection policy\n")
 
 	namespaces, err := targetAuth.GetNamespaces(hint.Namespace)
 	if err != nil {
@@ -27,13 +53,30 @@ func automatic(sess ssh.Session, targetAuth authz, hint types.SshTarget) (
 		return types.SshTarget{}, podSshConfig{}, nil
 	}
 
-	target.Namespace = namespaces[0]
-	podConfigs, err := targetAuth.GetPods(target.Namespace, hint.Pod)
-	if err != nil {
-		return target, podSshConfig{}, err
+	// The pods hinted without the namespace, like `deploy/api`, are searched
+	// through all the authorized namespaces.
+	// Authorization failure in one namespace is reported only if no
+	// authorized pods are found in the others.
+	sort.Strings(namespaces)
+	var podConfigs []podSshConfig
+	var podsErr error
+	for _, ns := range namespaces {
+		target.Namespace = ns
+		podConfigs, err = targetAuth.GetPods(target.Namespace, hint.Pod)
+		if errors.Is(err, ErrAuthorizationFailed) && len(namespaces) > 1 {
+			podsErr = err
+			continue
+		}
+		if err != nil {
+			return target, podSshConfig{}, err
+		}
+		podConfigs = targetAuth.OrderPods(podConfigs, hint.Pod)
+		if len(podConfigs) > 0 || hint.Pod == "" {
+			break
+		}
 	}
 	if len(podConfigs) == 0 {
-		return target, podSshConfig{}, nil
+		return target, podSshConfig{}, podsErr
 	}
 
 	targetPodConfig = podConfigs[0]
@@ -47,5 +90,104 @@ func automatic(sess ssh.Session, targetAuth authz, hint types.SshTarget) (
 	}
 
 	target.Container = containers[0]
+	if targetPodConfig.readOnly {
+		fmt.Fprintf(sess, "Target %s/%s/%s is read-only\n", target.Namespace, target.Pod, target.Container)
+	}
 	return target, targetPodConfig, nil
 }
+
+// strictRequested returns true if the client has requested the strict mode
+// with the environment variable.
+func strictRequested(environ []string) bool {
+	for _, env := range environ {
+		name, value, _ := strings.Cut(env, "=")
+		if name == strictEnv {
+			switch strings.ToLower(value) {
+			case "1", "true", "yes":
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// hasStrictRoutes returns true if any of the authorized resources is
+// configured with the strict mode.
+func (a authz) hasStrictRoutes() bool {
+	for _, c := range a.authorizedConfigs {
+		if c.Strict {
+			return true
+		}
+	}
+	return false
+}
+
+// allStrictRoutes returns true if all the authorized resources are
+// configured with the strict mode.
+func (a authz) allStrictRoutes() bool {
+	for _, c := range a.authorizedConfigs {
+		if !c.Strict {
+			return false
+		}
+	}
+	return len(a.authorizedConfigs) > 0
+}
+
+// strictAutomatic selects the target in the strict mode, if either the
+// client has requested it or the resources of the targets matching the
+// user's request are configured so. The request matching no targets is
+// strict if all the authorized resources are.
+//
+// Returns attach target and pod+configuration if the user's request matches
+// exactly one target. If the request matches several targets, prints the
+// candidate targets to out and returns ErrAmbiguousTarget. Returns false if
+// the request is not strict, so the target is selected automatically.
+func strictAutomatic(out io.Writer, targetAuth authz, hint types.SshTarget, requested bool) (
+	types.SshTarget, podSshConfig, bool, error,
+) {
+
+	targets, err := targetAuth.ResolveTargets(hint)
+	if err != nil {
+		return types.SshTarget{}, podSshConfig{}, requested || targetAuth.allStrictRoutes(), err
+	}
+
+	strict := requested
+	for _, t := range targets {
+		strict = strict || t.podConfig.config.Strict
+	}
+
+	switch len(targets) {
+	case 0:
+		return types.SshTarget{}, podSshConfig{}, requested || targetAuth.allStrictRoutes(), nil
+	case 1:
+		return targets[0].target, targets[0].podConfig, strict, nil
+	}
+	if !strict {
+		return types.SshTarget{}, podSshConfig{}, false, nil
+	}
+
+	candidates := []candidate{}
+	for _, t := range targets {
+		candidates = append(candidates, candidate{
+			Namespace: t.target.Namespace,
+			Pod:       t.target.Pod,
+			Container: t.target.Container,
+			Login:     t.target.Login(),
+		})
+	}
+
+	report, err := json.MarshalIndent(struct {
+		Error      string      `json:"error"`
+		Candidates []candidate `json:"candidates"`
+	}{
+		Error:      ErrAmbiguousTarget.Error(),
+		Candidates: candidates,
+	}, "", "  ")
+	if err != nil {
+		return types.SshTarget{}, podSshConfig{}, true, err
+	}
+	fmt.Fprintf(out, "%s\n", report)
+
+	return types.SshTarget{}, podSshConfig{}, true, fmt.Errorf(
+		"%w: %d targets match the request", ErrAmbiguousTarget, len(candidates))
+}
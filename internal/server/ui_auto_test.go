@@ -0,0 +1,128 @@
+package server
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	ingssh "kuberstein.io/ingressh/api/v1"
+	"kuberstein.io/ingressh/internal/types"
+)
+
+func TestStrictRequested(t *testing.T) {
+
+	tests := []struct {
+		environ  []string
+		expected bool
+	}{
+		{nil, false},
+		{[]string{"LANG=C"}, false},
+		{[]string{"INGRESSH_STRICT=1"}, true},
+		{[]string{"INGRESSH_STRICT=True"}, true},
+		{[]string{"INGRESSH_STRICT=0"}, false},
+	}
+	for _, tc := range tests {
+		if strictRequested(tc.environ) != tc.expected {
+			t.Errorf("strictRequested(%v) != %v", tc.environ, tc.expected)
+		}
+	}
+}
+
+func TestStrictAutomatic(t *testing.T) {
+
+	pod := func(name string) struct {
+		pod      corev1.Pod
+		selector string
+	} {
+		return struct {
+			pod      corev1.Pod
+			selector string
+		}{pod: corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+		}}
+	}
+	kube := clientPodMock{
+		pods: []struct {
+			pod      corev1.Pod
+			selector string
+		}{pod("api-1"), pod("api-2")},
+		namespaces: []string{"prod", "dev"},
+	}
+	strict := types.SshConfig{Namespace: "prod", IngreSshSpec: ingssh.IngreSshSpec{Strict: true}}
+	relaxed := types.SshConfig{Namespace: "dev"}
+
+	tests := []struct {
+		name      string
+		configs   []*types.SshConfig
+		hint      types.SshTarget
+		requested bool
+		target    types.SshTarget
+		strict    bool
+		ambiguous bool
+	}{
+		{
+			name:    "ambiguous strict route",
+			configs: []*types.SshConfig{&strict, &relaxed},
+			hint:    types.SshTarget{Namespace: "prod"},
+			strict:  true, ambiguous: true,
+		},
+		{
+			name:    "ambiguous relaxed route",
+			configs: []*types.SshConfig{&strict, &relaxed},
+			hint:    types.SshTarget{Namespace: "dev"},
+		},
+		{
+			name:      "ambiguous requested by client",
+			configs:   []*types.SshConfig{&relaxed},
+			hint:      types.SshTarget{Namespace: "dev"},
+			requested: true,
+			strict:    true, ambiguous: true,
+		},
+		{
+			name:    "single candidate",
+			configs: []*types.SshConfig{&strict, &relaxed},
+			hint:    types.SshTarget{Namespace: "prod", Pod: "api-1"},
+			target:  types.SshTarget{Namespace: "prod", Pod: "api-1", Container: "app"},
+			strict:  true,
+		},
+		{
+			name:    "empty with relaxed routes",
+			configs: []*types.SshConfig{&strict, &relaxed},
+			hint:    types.SshTarget{Namespace: "prod", Pod: "missing"},
+		},
+		{
+			name:    "empty with strict routes only",
+			configs: []*types.SshConfig{&strict},
+			hint:    types.SshTarget{Pod: "missing"},
+			strict:  true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var out strings.Builder
+			a := GetAuthz(tc.configs, "", kube)
+			target, _, strict, err := strictAutomatic(&out, a, tc.hint, tc.requested)
+			if errors.Is(err, ErrAmbiguousTarget) != tc.ambiguous {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if !tc.ambiguous && err != nil {
+				t.Fatalf("strictAutomatic() error = %v", err)
+			}
+			if strict != tc.strict {
+				t.Errorf("strict = %v, expected %v", strict, tc.strict)
+			}
+			if target != tc.target {
+				t.Errorf("unexpected target %+v", target)
+			}
+			if tc.ambiguous != strings.Contains(out.String(), `:api-2:app"`) {
+				t.Errorf("unexpected candidates report %q", out.String())
+			}
+		})
+	}
+}
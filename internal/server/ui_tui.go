@@ -11,7 +11,6 @@ import (
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/gliderlabs/ssh"
-	log "github.com/sirupsen/logrus"
 	"kuberstein.io/ingressh/internal/types"
 )
 
@@ -206,8 +205,12 @@ func (m model) View() string {
 	}
 
 	if m.choiceContainer != "" {
+		mode := ""
+		if m.choicePodConfig.readOnly {
+			mode = " (read-only)"
+		}
 		return quitTextStyle.Render(fmt.Sprintf(
-			"Proceed with %s/%s/%s...\n", m.choiceNamespace, m.choicePod, m.choiceContainer))
+			"Proceed with %s/%s/%s%s...\n", m.choiceNamespace, m.choicePod, m.choiceContainer, mode))
 	}
 	if m.quittingWithError != nil {
 		return quitTextStyle.Render(fmt.Sprintf("Error setting up SSH session: %v\n", m.quittingWithError))
@@ -231,16 +234,18 @@ func (m *model) startSelectPodScreen() error {
 		return fmt.Errorf("No authorized pods in ns %s", targetNamespace)
 	}
 
+	// The list starts with the pod the automatic selection would choose.
+	podConfigs = m.targetAuth.OrderPods(podConfigs, m.hint.Pod)
+	if len(podConfigs) == 0 {
+		return fmt.Errorf("No running pods in ns %s", targetNamespace)
+	}
+
 	m.choiceNamespace = targetNamespace
 	m.listPodsConfigs = podConfigs
 
-	sort.Slice(podConfigs, func(i, j int) bool {
-		return podConfigs[i].pod.Name < podConfigs[j].pod.Name
-	})
-
 	items := []list.Item{}
 	for _, p := range podConfigs {
-		items = append(items, item(p.pod.Name))
+		items = append(items, item(podItemTitle(p)))
 	}
 
 	m.listPods = m.setupList(items, fmt.Sprintf("Select a pod in the ns '%s'", targetNamespace))
@@ -258,6 +263,15 @@ func (m *model) startSelectPodScreen() error {
 	return nil
 }
 
+// podItemTitle returns the title of the pod in the selection list, marking
+// the pods the user can only watch.
+func podItemTitle(p podSshConfig) string {
+	if p.readOnly {
+		return p.pod.Name + " [read-only]"
+	}
+	return p.pod.Name
+}
+
 func (m *model) startSelectContainerScreen() error {
 
 	podConfigIdx := m.listPods.Index()
@@ -274,8 +288,8 @@ func (m *model) startSelectContainerScreen() error {
 		return fmt.Errorf("No authorized containers in pod %s", pod.Name)
 	}
 
-	m.choicePod = string(m.listPods.SelectedItem().(item))
-	m.choicePodConfig = m.listPodsConfigs[podConfigIdx]
+	m.choicePod = pod.Name
+	m.choicePodConfig = selectedPodConfig
 
 	sort.Strings(containers)
 	items := []list.Item{}
@@ -365,7 +379,7 @@ func interactive(sess ssh.Session, targetAuth authz, hint types.SshTarget) (
 	p := tea.NewProgram(m, tea.WithOutput(sess), tea.WithInput(sess))
 	result, err := p.Run()
 	if err != nil {
-		log.Errorf("Error on interactive access target select: %v", err)
+		Logger(sess.Context()).Error(err, "Error on interactive access target select")
 		return types.SshTarget{}, podSshConfig{}, err
 	}
 
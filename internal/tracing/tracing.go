@@ -0,0 +1,104 @@
+// Package tracing sets up the OpenTelemetry tracing of the SSH server. The
+// spans follow the connection lifecycle: the handshake, the authentication,
+// the authorization calls, the ephemeral container startup and the stream
+// setup, so a slow connection could be attributed to one of them.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"kuberstein.io/ingressh/internal/types"
+)
+
+const (
+	serviceName = "ingressh"
+	tracerName  = "kuberstein.io/ingressh"
+)
+
+// Setup installs the global tracer provider exporting the spans to the
+// configured exporter. Returns the function flushing and stopping the
+// exporter. Nothing is traced if the exporter is none.
+func Setup(ctx context.Context, conf types.TracingConfig) (func(context.Context) error, error) {
+
+	var exporter sdktrace.SpanExporter
+	var err error
+
+	switch conf.Exporter {
+	case types.TracingExporterNone, "":
+		return func(context.Context) error { return nil }, nil
+	case types.TracingExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case types.TracingExporterStderr:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stderr))
+	case types.TracingExporterOtlp:
+		options := []otlptracehttp.Option{}
+		if conf.Endpoint != "" {
+			options = append(options, otlptracehttp.WithEndpoint(conf.Endpoint))
+		}
+		if conf.Insecure {
+			options = append(options, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(ctx, options...)
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter %q", conf.Exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to create %s trace exporter: %w", conf.Exporter, err)
+	}
+
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName(serviceName)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("unable to describe trace resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(conf.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	return provider.Shutdown, nil
+}
+
+// Tracer returns the tracer of the server. It follows the global provider,
+// so the spans are dropped until the tracing is set up.
+func Tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// TraceID returns the ID of the trace of the span in the context, or empty
+// string if the context is not traced.
+func TraceID(ctx context.Context) string {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.HasTraceID() {
+		return ""
+	}
+	return sc.TraceID().String()
+}
+
+// EndSpan records the error if any, and ends the span.
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
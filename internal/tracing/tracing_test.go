@@ -0,0 +1,52 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+
+	"kuberstein.io/ingressh/internal/types"
+)
+
+func TestSetup(t *testing.T) {
+
+	tests := []struct {
+		exporter string
+		wantErr  bool
+	}{
+		{"", false},
+		{types.TracingExporterNone, false},
+		{types.TracingExporterStdout, false},
+		{types.TracingExporterStderr, false},
+		{"jaeger", true},
+	}
+
+	for _, tt := range tests {
+		shutdown, err := Setup(context.Background(), types.TracingConfig{Exporter: tt.exporter, SampleRatio: 1})
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Setup(%q) error = %v, wantErr %v", tt.exporter, err, tt.wantErr)
+			continue
+		}
+		if err == nil {
+			if err := shutdown(context.Background()); err != nil {
+				t.Errorf("shutdown of %q: %v", tt.exporter, err)
+			}
+		}
+	}
+}
+
+func TestTraceID(t *testing.T) {
+
+	if id := TraceID(context.Background()); id != "" {
+		t.Errorf("expected no trace ID without span, got %q", id)
+	}
+
+	tracer := sdktrace.NewTracerProvider().Tracer("test")
+	ctx, span := tracer.Start(context.Background(), "test")
+	defer span.End()
+
+	if id := TraceID(ctx); id != span.SpanContext().TraceID().String() || len(id) != 32 {
+		t.Errorf("unexpected trace ID %q", id)
+	}
+}
@@ -0,0 +1,41 @@
+package types
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestParseCIDRs(t *testing.T) {
+
+	prefixes, err := ParseCIDRs([]string{"10.0.0.0/8", " 192.168.1.7 ", "", "2001:db8::/32", "172.16.5.1/12"})
+	if err != nil {
+		t.Fatalf("ParseCIDRs() error = %v", err)
+	}
+	if len(prefixes) != 4 {
+		t.Fatalf("expected 4 prefixes, got %v", prefixes)
+	}
+
+	tests := []struct {
+		addr     string
+		contains bool
+	}{
+		{"10.1.2.3", true},
+		{"::ffff:10.1.2.3", true},
+		{"192.168.1.7", true},
+		{"192.168.1.8", false},
+		{"2001:db8::1", true},
+		{"172.31.255.255", true},
+		{"8.8.8.8", false},
+	}
+	for _, tt := range tests {
+		if got := ContainsAddr(prefixes, netip.MustParseAddr(tt.addr)); got != tt.contains {
+			t.Errorf("ContainsAddr(%s) = %v, expected %v", tt.addr, got, tt.contains)
+		}
+	}
+
+	for _, invalid := range []string{"10.0.0.0/33", "not-an-ip", "10.0.0/8"} {
+		if _, err := ParseCIDRs([]string{invalid}); err == nil {
+			t.Errorf("ParseCIDRs(%q) expected error", invalid)
+		}
+	}
+}
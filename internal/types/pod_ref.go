@@ -0,0 +1,96 @@
+package types
+
+import (
+	"path"
+	"strings"
+)
+
+// Kinds of the workloads which could be referenced in the login string
+const (
+	KindPod         = "Pod"
+	KindDeployment  = "Deployment"
+	KindStatefulSet = "StatefulSet"
+	KindDaemonSet   = "DaemonSet"
+	KindReplicaSet  = "ReplicaSet"
+	KindJob         = "Job"
+)
+
+// workloadKinds maps the kind names and the short names accepted in the
+// login string (similar to kubectl) to the kinds of the objects.
+var workloadKinds = map[string]string{
+	"po":          KindPod,
+	"pod":         KindPod,
+	"pods":        KindPod,
+	"deploy":      KindDeployment,
+	"deployment":  KindDeployment,
+	"deployments": KindDeployment,
+	"sts":         KindStatefulSet,
+	"statefulset": KindStatefulSet,
+	"ds":          KindDaemonSet,
+	"daemonset":   KindDaemonSet,
+	"rs":          KindReplicaSet,
+	"replicaset":  KindReplicaSet,
+	"job":         KindJob,
+	"jobs":        KindJob,
+}
+
+// lookupKind returns the kind of the object for the name used in the login
+// string, or an empty string if the name is not a known kind.
+func lookupKind(name string) string {
+	return workloadKinds[strings.ToLower(name)]
+}
+
+// PodRef is a reference to the target pods as specified by the user: an
+// exact pod name, a glob pattern for the pod names (like `nginx-*`), or a
+// workload the pods belong to (like `deploy/nginx`). A stateful set
+// reference also matches the pod of the stateful set by its name, so
+// `sts/db-0` is the pod db-0 of the stateful set db.
+type PodRef struct {
+	// Kind of the referenced object, KindPod for the pod names and patterns.
+	Kind string
+	// Name is the name or the glob pattern of the referenced object.
+	Name string
+}
+
+// ParsePodRef parses the pod component of the SSH target. The string is
+// either `kind/name` for the workload references or the name (pattern) of
+// the pod. An unknown kind makes the whole string to be treated as a name.
+func ParsePodRef(s string) PodRef {
+	if kind, name, found := strings.Cut(s, "/"); found {
+		if k := lookupKind(kind); k != "" {
+			return PodRef{Kind: k, Name: name}
+		}
+	}
+	return PodRef{Kind: KindPod, Name: s}
+}
+
+// IsEmpty returns true if the reference doesn't restrict the pods.
+func (p PodRef) IsEmpty() bool {
+	return p.Name == ""
+}
+
+// IsExact returns true if the reference names exactly one pod.
+func (p PodRef) IsExact() bool {
+	return p.Kind == KindPod && p.Name != "" && !isPattern(p.Name)
+}
+
+// IsWorkload returns true if the reference names a workload controlling
+// the pods.
+func (p PodRef) IsWorkload() bool {
+	return p.Kind != KindPod && p.Name != ""
+}
+
+// MatchName reports whether the name of the object matches the reference,
+// which might be a glob pattern. Malformed patterns match nothing.
+func (p PodRef) MatchName(name string) bool {
+	if p.Name == "" {
+		return true
+	}
+	matched, err := path.Match(p.Name, name)
+	return err == nil && matched
+}
+
+// isPattern returns true if the string contains glob pattern characters.
+func isPattern(s string) bool {
+	return strings.ContainsAny(s, "*?[")
+}
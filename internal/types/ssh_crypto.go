@@ -0,0 +1,183 @@
+package types
+
+import (
+	"slices"
+)
+
+// Profiles of the algorithms of the SSH transport and the authentication
+const (
+	// CryptoProfileCompatible allows the algorithms of the SSH library
+	// defaults, including SHA-1 for the old clients.
+	CryptoProfileCompatible = "compatible"
+	// CryptoProfileModern allows the algorithms without SHA-1 nor the
+	// CBC ciphers, supported by OpenSSH 7.2 and later.
+	CryptoProfileModern = "modern"
+	// CryptoProfileFips allows the algorithms approved by FIPS 140 only:
+	// the NIST curves, AES and SHA-2.
+	CryptoProfileFips = "fips"
+)
+
+// CryptoAlgorithms are the names of the algorithms as they are negotiated in
+// the SSH protocol, in the order of preference.
+type CryptoAlgorithms struct {
+	KeyExchanges []string `json:"keyExchanges"`
+	Ciphers      []string `json:"ciphers"`
+	MACs         []string `json:"macs"`
+	// HostKeyAlgorithms are the signature algorithms of the host keys, f.e.
+	// rsa-sha2-512 rather than ssh-rsa for the SHA-2 signatures of the RSA
+	// keys.
+	HostKeyAlgorithms []string `json:"hostKeyAlgorithms"`
+	// PublicKeyAlgorithms are the algorithms of the keys of the users.
+	PublicKeyAlgorithms []string `json:"publicKeyAlgorithms"`
+}
+
+// CryptoConfig selects the profile of the algorithms. The lists of the
+// algorithms which are set replace the ones of the profile.
+type CryptoConfig struct {
+	Profile          string `json:"profile"`
+	CryptoAlgorithms `json:",inline"`
+}
+
+// cryptoSupported are the algorithms supported by the SSH library.
+var cryptoSupported = CryptoAlgorithms{
+	KeyExchanges: []string{
+		"curve25519-sha256", "curve25519-sha256@libssh.org",
+		"ecdh-sha2-nistp256", "ecdh-sha2-nistp384", "ecdh-sha2-nistp521",
+		"diffie-hellman-group14-sha256", "diffie-hellman-group16-sha512",
+		"diffie-hellman-group14-sha1", "diffie-hellman-group1-sha1",
+	},
+	Ciphers: []string{
+		"aes128-gcm@openssh.com", "aes256-gcm@openssh.com", "chacha20-poly1305@openssh.com",
+		"aes128-ctr", "aes192-ctr", "aes256-ctr",
+		"aes128-cbc", "3des-cbc", "arcfour256", "arcfour128", "arcfour",
+	},
+	MACs: []string{
+		"hmac-sha2-256-etm@openssh.com", "hmac-sha2-512-etm@openssh.com",
+		"hmac-sha2-256", "hmac-sha2-512", "hmac-sha1", "hmac-sha1-96",
+	},
+	HostKeyAlgorithms: []string{
+		"ssh-ed25519", "ecdsa-sha2-nistp256", "ecdsa-sha2-nistp384", "ecdsa-sha2-nistp521",
+		"rsa-sha2-512", "rsa-sha2-256", "ssh-rsa", "ssh-dss",
+		"ssh-ed25519-cert-v01@openssh.com",
+		"ecdsa-sha2-nistp256-cert-v01@openssh.com",
+		"ecdsa-sha2-nistp384-cert-v01@openssh.com",
+		"ecdsa-sha2-nistp521-cert-v01@openssh.com",
+		"rsa-sha2-512-cert-v01@openssh.com", "rsa-sha2-256-cert-v01@openssh.com",
+		"ssh-rsa-cert-v01@openssh.com", "ssh-dss-cert-v01@openssh.com",
+	},
+	PublicKeyAlgorithms: []string{
+		"ssh-ed25519", "sk-ssh-ed25519@openssh.com",
+		"ecdsa-sha2-nistp256", "ecdsa-sha2-nistp384", "ecdsa-sha2-nistp521",
+		"sk-ecdsa-sha2-nistp256@openssh.com",
+		"rsa-sha2-512", "rsa-sha2-256", "ssh-rsa", "ssh-dss",
+	},
+}
+
+// cryptoProfiles are the algorithms of the profiles.
+var cryptoProfiles = map[string]CryptoAlgorithms{
+	CryptoProfileCompatible: {
+		KeyExchanges: []string{
+			"curve25519-sha256", "curve25519-sha256@libssh.org",
+			"ecdh-sha2-nistp256", "ecdh-sha2-nistp384", "ecdh-sha2-nistp521",
+			"diffie-hellman-group14-sha256", "diffie-hellman-group14-sha1",
+		},
+		Ciphers: []string{
+			"aes128-gcm@openssh.com", "aes256-gcm@openssh.com", "chacha20-poly1305@openssh.com",
+			"aes128-ctr", "aes192-ctr", "aes256-ctr",
+		},
+		MACs:                cryptoSupported.MACs,
+		HostKeyAlgorithms:   cryptoSupported.HostKeyAlgorithms,
+		PublicKeyAlgorithms: cryptoSupported.PublicKeyAlgorithms,
+	},
+	CryptoProfileModern: {
+		KeyExchanges: []string{
+			"curve25519-sha256", "curve25519-sha256@libssh.org",
+			"ecdh-sha2-nistp256", "ecdh-sha2-nistp384", "ecdh-sha2-nistp521",
+			"diffie-hellman-group14-sha256", "diffie-hellman-group16-sha512",
+		},
+		Ciphers: []string{
+			"chacha20-poly1305@openssh.com", "aes256-gcm@openssh.com", "aes128-gcm@openssh.com",
+			"aes256-ctr", "aes192-ctr", "aes128-ctr",
+		},
+		MACs: []string{
+			"hmac-sha2-256-etm@openssh.com", "hmac-sha2-512-etm@openssh.com", "hmac-sha2-256", "hmac-sha2-512",
+		},
+		HostKeyAlgorithms: []string{
+			"ssh-ed25519", "ecdsa-sha2-nistp256", "ecdsa-sha2-nistp384", "ecdsa-sha2-nistp521",
+			"rsa-sha2-512", "rsa-sha2-256",
+			"ssh-ed25519-cert-v01@openssh.com",
+			"ecdsa-sha2-nistp256-cert-v01@openssh.com",
+			"ecdsa-sha2-nistp384-cert-v01@openssh.com",
+			"ecdsa-sha2-nistp521-cert-v01@openssh.com",
+			"rsa-sha2-512-cert-v01@openssh.com", "rsa-sha2-256-cert-v01@openssh.com",
+		},
+		PublicKeyAlgorithms: []string{
+			"ssh-ed25519", "sk-ssh-ed25519@openssh.com",
+			"ecdsa-sha2-nistp256", "ecdsa-sha2-nistp384", "ecdsa-sha2-nistp521",
+			"sk-ecdsa-sha2-nistp256@openssh.com",
+			"rsa-sha2-512", "rsa-sha2-256",
+		},
+	},
+	CryptoProfileFips: {
+		KeyExchanges: []string{
+			"ecdh-sha2-nistp256", "ecdh-sha2-nistp384", "ecdh-sha2-nistp521",
+			"diffie-hellman-group14-sha256", "diffie-hellman-group16-sha512",
+		},
+		Ciphers: []string{
+			"aes256-gcm@openssh.com", "aes128-gcm@openssh.com", "aes256-ctr", "aes192-ctr", "aes128-ctr",
+		},
+		MACs: []string{
+			"hmac-sha2-256-etm@openssh.com", "hmac-sha2-512-etm@openssh.com", "hmac-sha2-256", "hmac-sha2-512",
+		},
+		HostKeyAlgorithms: []string{
+			"ecdsa-sha2-nistp256", "ecdsa-sha2-nistp384", "ecdsa-sha2-nistp521",
+			"rsa-sha2-512", "rsa-sha2-256",
+			"ecdsa-sha2-nistp256-cert-v01@openssh.com",
+			"ecdsa-sha2-nistp384-cert-v01@openssh.com",
+			"ecdsa-sha2-nistp521-cert-v01@openssh.com",
+			"rsa-sha2-512-cert-v01@openssh.com", "rsa-sha2-256-cert-v01@openssh.com",
+		},
+		PublicKeyAlgorithms: []string{
+			"ecdsa-sha2-nistp256", "ecdsa-sha2-nistp384", "ecdsa-sha2-nistp521",
+			"rsa-sha2-512", "rsa-sha2-256",
+		},
+	},
+}
+
+// Algorithms returns the algorithms of the profile, replaced by the lists
+// which are set. The unknown profile has no algorithms.
+func (c CryptoConfig) Algorithms() CryptoAlgorithms {
+	algorithms := cryptoProfiles[c.Profile]
+	override := func(list *[]string, value []string) {
+		if len(value) > 0 {
+			*list = value
+		}
+	}
+	override(&algorithms.KeyExchanges, c.KeyExchanges)
+	override(&algorithms.Ciphers, c.Ciphers)
+	override(&algorithms.MACs, c.MACs)
+	override(&algorithms.HostKeyAlgorithms, c.HostKeyAlgorithms)
+	override(&algorithms.PublicKeyAlgorithms, c.PublicKeyAlgorithms)
+	return algorithms
+}
+
+// validate reports the unknown profile and the algorithms not supported by
+// the SSH library.
+func (c CryptoConfig) validate(invalid func(field string, format string, args ...any)) {
+
+	if _, ok := cryptoProfiles[c.Profile]; !ok {
+		invalid("crypto.profile", "unknown profile %q", c.Profile)
+	}
+	supported := func(field string, list []string, supported []string) {
+		for _, algorithm := range list {
+			if !slices.Contains(supported, algorithm) {
+				invalid(field, "unsupported algorithm %q", algorithm)
+			}
+		}
+	}
+	supported("crypto.keyExchanges", c.KeyExchanges, cryptoSupported.KeyExchanges)
+	supported("crypto.ciphers", c.Ciphers, cryptoSupported.Ciphers)
+	supported("crypto.macs", c.MACs, cryptoSupported.MACs)
+	supported("crypto.hostKeyAlgorithms", c.HostKeyAlgorithms, cryptoSupported.HostKeyAlgorithms)
+	supported("crypto.publicKeyAlgorithms", c.PublicKeyAlgorithms, cryptoSupported.PublicKeyAlgorithms)
+}
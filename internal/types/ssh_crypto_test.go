@@ -0,0 +1,38 @@
+package types
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestCryptoConfigAlgorithms(t *testing.T) {
+
+	conf, err := LoadServerConf(writeConf(t, `
+crypto:
+  profile: modern
+  ciphers: [aes256-ctr]
+`))
+	if err != nil {
+		t.Fatalf("LoadServerConf() error = %v", err)
+	}
+	algorithms := conf.Crypto.Algorithms()
+	if !reflect.DeepEqual(algorithms.Ciphers, []string{"aes256-ctr"}) {
+		t.Errorf("expected the ciphers to replace the ones of the profile, got %v", algorithms.Ciphers)
+	}
+	if !reflect.DeepEqual(algorithms.KeyExchanges, cryptoProfiles[CryptoProfileModern].KeyExchanges) {
+		t.Errorf("expected the key exchanges of the profile, got %v", algorithms.KeyExchanges)
+	}
+
+	// The profiles without SHA-1 and CBC
+	for _, profile := range []string{CryptoProfileModern, CryptoProfileFips} {
+		a := CryptoConfig{Profile: profile}.Algorithms()
+		for _, list := range [][]string{a.KeyExchanges, a.Ciphers, a.MACs, a.HostKeyAlgorithms, a.PublicKeyAlgorithms} {
+			for _, algorithm := range list {
+				if slices.Contains([]string{"ssh-rsa", "hmac-sha1", "diffie-hellman-group14-sha1", "aes128-cbc"}, algorithm) {
+					t.Errorf("unexpected algorithm %s in the %s profile", algorithm, profile)
+				}
+			}
+		}
+	}
+}
@@ -1,6 +1,12 @@
 package types
 
 import (
+	"net/netip"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+
 	ing "kuberstein.io/ingressh/api/v1"
 )
 
@@ -11,12 +17,111 @@ type SshConfig struct {
 	ing.IngreSshSpec
 	Name      string
 	Namespace string
+	// UID identifies the IngreSsh resource of the route, f.e. for events
+	UID k8stypes.UID
 }
 
-// ApplyDefaults adds default values taken from the server configuration
-// for the fields having no values.
-func (c *SshConfig) ApplyDefaults(serverConfig ServerConfig) {
-	if c.Image == "" {
-		c.Image = serverConfig.DebugImage
+// WithDefaults returns a copy of the configuration with the default values
+// taken from the server configuration for the fields having no values. The
+// configuration itself is shared by the sessions of the route and is never
+// changed, so the reloaded defaults apply to the new sessions.
+func (c *SshConfig) WithDefaults(serverConfig ServerConfig) *SshConfig {
+	resolved := *c
+	if resolved.Image == "" {
+		resolved.Image = serverConfig.Defaults.Image
+	}
+	if resolved.IdleTimeout == nil {
+		resolved.IdleTimeout = &metav1.Duration{Duration: serverConfig.Defaults.IdleTimeout.Duration}
+	}
+	if resolved.MaxSessionDuration == nil {
+		resolved.MaxSessionDuration = &metav1.Duration{Duration: serverConfig.Defaults.MaxSessionDuration.Duration}
 	}
+	if resolved.KeepAliveInterval == nil {
+		resolved.KeepAliveInterval = &metav1.Duration{Duration: serverConfig.Defaults.KeepAliveInterval.Duration}
+	}
+	return &resolved
+}
+
+// SessionTimeouts are the timeouts of the session streams. Zero disables
+// the timeout.
+type SessionTimeouts struct {
+	Idle              time.Duration
+	MaxDuration       time.Duration
+	KeepAliveInterval time.Duration
+}
+
+// Timeouts returns the timeouts of the sessions of the route.
+func (c *SshConfig) Timeouts() SessionTimeouts {
+	return SessionTimeouts{
+		Idle:              duration(c.IdleTimeout),
+		MaxDuration:       duration(c.MaxSessionDuration),
+		KeepAliveInterval: duration(c.KeepAliveInterval),
+	}
+}
+
+// duration returns the value of the optional duration, zero if not set.
+func duration(d *metav1.Duration) time.Duration {
+	if d == nil || d.Duration < 0 {
+		return 0
+	}
+	return d.Duration
+}
+
+// IsReadOnly returns true if the session authenticated with the
+// authorizedKey must only mirror the output of the target container. This
+// is the case when either the whole route or the key itself is configured
+// as read-only.
+func (c *SshConfig) IsReadOnly(authorizedKey string) bool {
+	if c.ReadOnly {
+		return true
+	}
+	for _, auth := range c.AuthorizedKeys {
+		if auth.Key == authorizedKey {
+			return auth.ReadOnly
+		}
+	}
+	return false
+}
+
+// HasAlias returns true if the route is named with the alias.
+func (c *SshConfig) HasAlias(alias string) bool {
+	for _, a := range c.Aliases {
+		if a == alias {
+			return true
+		}
+	}
+	return false
+}
+
+// IsAuditor returns true if the user authenticated with the authorizedKey
+// may replay the recorded sessions of the route.
+func (c *SshConfig) IsAuditor(authorizedKey string) bool {
+	for _, auth := range c.AuthorizedKeys {
+		if auth.Key == authorizedKey {
+			return auth.Auditor
+		}
+	}
+	return false
+}
+
+// AllowsSource returns true if the users may connect to the route from the
+// address. The routes without the source ranges allow any address, and the
+// invalid ranges allow none.
+func (c *SshConfig) AllowsSource(addr netip.Addr) bool {
+	if len(c.SourceRanges) == 0 {
+		return true
+	}
+	for _, cidr := range c.SourceRanges {
+		prefixes, err := ParseCIDRs([]string{cidr})
+		if err == nil && ContainsAddr(prefixes, addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Route returns the name of the route, which is the namespaced name of the
+// IngreSsh resource.
+func (c *SshConfig) Route() string {
+	return c.Namespace + "/" + c.Name
 }
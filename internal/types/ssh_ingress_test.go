@@ -0,0 +1,37 @@
+package types
+
+import (
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	ing "kuberstein.io/ingressh/api/v1"
+)
+
+func TestWithDefaults(t *testing.T) {
+
+	conf := DefaultServerConf()
+	conf.Defaults.Image = "busybox"
+	conf.Defaults.IdleTimeout = metav1.Duration{Duration: 30 * time.Minute}
+
+	route := &SshConfig{Name: "route", Namespace: "ns", IngreSshSpec: ing.IngreSshSpec{
+		MaxSessionDuration: &metav1.Duration{Duration: time.Hour},
+	}}
+
+	resolved := route.WithDefaults(*conf)
+	if resolved.Image != "busybox" || resolved.Timeouts().Idle != 30*time.Minute || resolved.Timeouts().MaxDuration != time.Hour {
+		t.Errorf("unexpected resolved configuration %+v", resolved.Timeouts())
+	}
+	if route.Image != "" || route.IdleTimeout != nil || route.KeepAliveInterval != nil {
+		t.Errorf("expected the route configuration to stay unchanged, got %+v", route.IngreSshSpec)
+	}
+
+	// The reloaded defaults apply to the next sessions
+	conf.Defaults.Image = "alpine"
+	conf.Defaults.IdleTimeout = metav1.Duration{Duration: time.Minute}
+	resolved = route.WithDefaults(*conf)
+	if resolved.Image != "alpine" || resolved.Timeouts().Idle != time.Minute {
+		t.Errorf("expected the reloaded defaults, got %q %+v", resolved.Image, resolved.Timeouts())
+	}
+}
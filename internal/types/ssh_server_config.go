@@ -1,28 +1,608 @@
 package types
 
 import (
+	"errors"
+	"fmt"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
 )
 
-// ServerConfig contains cluster-wide SSH parameters
+// ServerConfig contains cluster-wide SSH parameters. They are read from the
+// YAML configuration file, if any, and the environment variables override
+// the values of the file.
 type ServerConfig struct {
-	BindAddress string
-	HostKeyFile string
-	DebugImage  string
+	Listener ListenerConfig `json:"listener"`
+	// HostKeys are presented to the clients, the first one of every key
+	// type in the key exchange. All of them are announced to the clients
+	// supporting the hostkeys-00@openssh.com extension, so a new key can be
+	// added before the old one is removed.
+	HostKeys []HostKeyConfig `json:"hostKeys"`
+	// HostKeySecret is the Secret in the namespace of the server holding the
+	// host key used after the host keys, generated on the first start if the
+	// Secret doesn't exist. The host keys may be empty if it is set.
+	HostKeySecret string `json:"hostKeySecret"`
+	// HostKeyConfigMap is the ConfigMap in the namespace of the server the
+	// public host keys and their fingerprints are published to, if it is set.
+	HostKeyConfigMap string `json:"hostKeyConfigMap"`
+	// Defaults apply to the IngreSsh resources not specifying their own.
+	Defaults DefaultsConfig `json:"defaults"`
+
+	// FanOutParallelism limits the number of commands running concurrently
+	// for the fan-out sessions.
+	FanOutParallelism int `json:"fanOutParallelism"`
+
+	// PublicAddress is the host[:port] the users connect to, f.e. the
+	// address of the load balancer, printed by the built-in command into the
+	// ssh_config and known_hosts lines. The users pass the address to the
+	// command if it is not set.
+	PublicAddress string `json:"publicAddress"`
+
+	// ServerName and ServerNamespace identify the pod of the server, so the
+	// resources of the sessions of the other replicas are left alone.
+	ServerName      string `json:"-"`
+	ServerNamespace string `json:"-"`
+
+	Limits    LimitsConfig    `json:"limits"`
+	Guard     GuardConfig     `json:"guard"`
+	Recording RecordingConfig `json:"recording"`
+	Audit     AuditConfig     `json:"audit"`
+	Tracing   TracingConfig   `json:"tracing"`
+	Crypto    CryptoConfig    `json:"crypto"`
+}
+
+// ListenerConfig configures the SSH listener.
+type ListenerConfig struct {
+	BindAddress string `json:"bindAddress"`
+
+	// ProxyProtocol enables the PROXY protocol v1 and v2 headers on the SSH
+	// listener, accepted from the upstreams in ProxyTrustedCIDRs only, f.e.
+	// from the TCP load balancers.
+	ProxyProtocol     bool     `json:"proxyProtocol"`
+	ProxyTrustedCIDRs []string `json:"proxyTrustedCIDRs"`
+
+	// SourceAllowList and SourceDenyList are the CIDRs of the addresses the
+	// connections are accepted from, checked before the SSH handshake. All
+	// addresses are allowed if the allow-list is empty. The deny-list takes
+	// precedence.
+	SourceAllowList []string `json:"sourceAllowCIDRs"`
+	SourceDenyList  []string `json:"sourceDenyCIDRs"`
+
+	// IdleTimeout closes the connections without any traffic, including the
+	// keepalive requests, f.e. the stalled handshakes. MaxTimeout closes the
+	// connections lasting for longer than the duration. Zero disables the
+	// timeout.
+	IdleTimeout metav1.Duration `json:"idleTimeout"`
+	MaxTimeout  metav1.Duration `json:"maxTimeout"`
+
+	// HandshakeTimeout closes the connections not authenticated within the
+	// duration, so the slow clients can't hold the handshake slots. Zero
+	// disables the timeout.
+	HandshakeTimeout metav1.Duration `json:"handshakeTimeout"`
+}
+
+// HostKeyConfig is the file of the host private key in PEM, and optionally
+// its OpenSSH host certificate signed by the CA, so the clients trusting the
+// CA with @cert-authority in known_hosts accept the key.
+type HostKeyConfig struct {
+	Key string `json:"key"`
+	// Certificate is the file of the certificate, by default the key file
+	// with the -cert.pub suffix if it exists, like ssh-keygen names it.
+	Certificate string `json:"certificate,omitempty"`
+}
+
+// CertificateFile returns the file of the certificate of the key, and true
+// if the certificate is required to exist.
+func (k HostKeyConfig) CertificateFile() (string, bool) {
+	if k.Certificate != "" {
+		return k.Certificate, true
+	}
+	return k.Key + "-cert.pub", false
+}
+
+// DefaultsConfig contains the defaults for the IngreSsh resources. Zero
+// disables the timeout.
+type DefaultsConfig struct {
+	// Image is the container image of the debug sessions.
+	Image              string          `json:"image"`
+	IdleTimeout        metav1.Duration `json:"idleTimeout"`
+	MaxSessionDuration metav1.Duration `json:"maxSessionDuration"`
+	KeepAliveInterval  metav1.Duration `json:"keepAliveInterval"`
+}
+
+// LimitsConfig limits the number of the concurrent sessions, so a runaway
+// script can't exhaust the exec capacity of the API server. Zero disables
+// the limit.
+type LimitsConfig struct {
+	// Sessions is the limit for the whole server.
+	Sessions         int `json:"sessions"`
+	SessionsPerUser  int `json:"sessionsPerUser"`
+	SessionsPerPod   int `json:"sessionsPerPod"`
+	SessionsPerRoute int `json:"sessionsPerRoute"`
+	// Handshakes limits the connections not authenticated yet.
+	Handshakes int `json:"handshakes"`
+}
+
+// GuardConfig protects the server against the brute-forcing of the keys. The
+// connections from the source IP are rate limited, and the source IP is
+// blocked for a while after the failed authentications.
+type GuardConfig struct {
+	// ConnectionRate is the rate of the new connections per second from the
+	// source IP, with the bursts of ConnectionBurst. Zero disables the limit.
+	ConnectionRate  float64 `json:"connectionRate"`
+	ConnectionBurst int     `json:"connectionBurst"`
+
+	// Backoff blocks the source IP after the connection failed to
+	// authenticate, doubling for every failed connection in a row.
+	Backoff metav1.Duration `json:"backoff"`
+	// BanAfter failed connections in a row ban the source IP for BanDuration.
+	BanAfter    int             `json:"banAfter"`
+	BanDuration metav1.Duration `json:"banDuration"`
+
+	// AllowList is the list of the CIDRs never limited nor banned.
+	AllowList []string `json:"allowCIDRs"`
+}
+
+// Sinks to store the session recordings
+const (
+	RecordingSinkLocal = "local"
+	RecordingSinkS3    = "s3"
+)
+
+// RecordingConfig configures the storage of the session recordings. The
+// recording is disabled if the sink is not specified.
+type RecordingConfig struct {
+	Sink string `json:"sink"`
+	// Dir is the directory of the local sink, f.e. a mounted volume.
+	Dir string   `json:"dir"`
+	S3  S3Config `json:"s3"`
+}
+
+// S3Config configures the bucket of S3-compatible object storage.
+type S3Config struct {
+	Endpoint  string `json:"endpoint"`
+	Region    string `json:"region"`
+	Bucket    string `json:"bucket"`
+	Prefix    string `json:"prefix"`
+	AccessKey string `json:"accessKey"`
+	SecretKey string `json:"secretKey"`
+	// Insecure disables TLS for the connections to the storage.
+	Insecure bool `json:"insecure"`
 }
 
-func GetServerConf() *ServerConfig {
+// Sinks of the audit log
+const (
+	AuditSinkNone   = "none"
+	AuditSinkStdout = "stdout"
+	AuditSinkFile   = "file"
+	AuditSinkSyslog = "syslog"
+)
+
+// AuditConfig configures the destination of the audit log.
+type AuditConfig struct {
+	Sink string `json:"sink"`
+
+	// File sink rotates the log file by size, keeping the limited number
+	// of the old files for the limited time.
+	File           string `json:"file"`
+	FileMaxSizeMB  int    `json:"fileMaxSizeMB"`
+	FileMaxBackups int    `json:"fileMaxBackups"`
+	FileMaxAgeDays int    `json:"fileMaxAgeDays"`
+
+	// Syslog sink sends the events over tcp or udp network.
+	SyslogNetwork string `json:"syslogNetwork"`
+	SyslogAddress string `json:"syslogAddress"`
+}
+
+// Exporters of the traces
+const (
+	TracingExporterNone   = "none"
+	TracingExporterStdout = "stdout"
+	TracingExporterStderr = "stderr"
+	TracingExporterOtlp   = "otlp"
+)
+
+// TracingConfig configures the export of the OpenTelemetry traces.
+type TracingConfig struct {
+	Exporter string `json:"exporter"`
+
+	// Endpoint is the host:port of the OTLP/HTTP collector. The standard
+	// OTEL_EXPORTER_OTLP_* variables apply if it is not set.
+	Endpoint string `json:"endpoint"`
+	Insecure bool   `json:"insecure"`
+
+	// SampleRatio is the fraction of the connections traced, from 0 to 1.
+	SampleRatio float64 `json:"sampleRatio"`
+}
+
+// DefaultServerConf returns the configuration used for the values set
+// neither in the configuration file nor in the environment variables.
+func DefaultServerConf() *ServerConfig {
 	return &ServerConfig{
-		BindAddress: getEnv("SSH_BIND_ADDRESS", ":8022"),
-		HostKeyFile: getEnv("HOST_KEY_FILE", "/secret/ssh-privatekey"),
-		DebugImage: getEnv("DEBUG_IMAGE", "busybox"),
+		Listener: ListenerConfig{
+			BindAddress:      ":8022",
+			HandshakeTimeout: metav1.Duration{Duration: time.Minute},
+		},
+		HostKeys: []HostKeyConfig{
+			{Key: "/secret/ssh-privatekey"},
+		},
+		Defaults: DefaultsConfig{
+			Image:             "busybox",
+			KeepAliveInterval: metav1.Duration{Duration: 30 * time.Second},
+		},
+		FanOutParallelism: 10,
+		ServerName:        hostname(),
+		Guard: GuardConfig{
+			ConnectionRate:  2,
+			ConnectionBurst: 20,
+			Backoff:         metav1.Duration{Duration: time.Second},
+			BanAfter:        10,
+			BanDuration:     metav1.Duration{Duration: time.Hour},
+		},
+		Recording: RecordingConfig{
+			Dir: "/recordings",
+		},
+		Audit: AuditConfig{
+			Sink:           AuditSinkStdout,
+			File:           "/var/log/ingressh/audit.log",
+			FileMaxSizeMB:  100,
+			FileMaxBackups: 5,
+			FileMaxAgeDays: 30,
+			SyslogNetwork:  "udp",
+		},
+		Tracing: TracingConfig{
+			Exporter:    TracingExporterNone,
+			SampleRatio: 1,
+		},
+		Crypto: CryptoConfig{
+			Profile: CryptoProfileCompatible,
+		},
+	}
+}
+
+// LoadServerConf returns the defaults overridden by the configuration file,
+// if the path is not empty, and then by the environment variables. The
+// unknown fields of the file are rejected, as they are likely typos.
+func LoadServerConf(path string) (*ServerConfig, error) {
+
+	conf := DefaultServerConf()
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the configuration file: %w", err)
+		}
+		if err := yaml.UnmarshalStrict(data, conf); err != nil {
+			return nil, fmt.Errorf("unable to parse the configuration file %s: %w", path, err)
+		}
+	}
+	conf.applyEnv()
+
+	if err := conf.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	return conf, nil
+}
+
+// applyEnv overrides the configuration with the environment variables set.
+func (c *ServerConfig) applyEnv() {
+
+	c.Listener.BindAddress = getEnv("SSH_BIND_ADDRESS", c.Listener.BindAddress)
+	c.Listener.ProxyProtocol = getEnvBool("PROXY_PROTOCOL", c.Listener.ProxyProtocol)
+	c.Listener.ProxyTrustedCIDRs = getEnvList("PROXY_TRUSTED_CIDRS", c.Listener.ProxyTrustedCIDRs)
+	c.Listener.SourceAllowList = getEnvList("SOURCE_ALLOW_CIDRS", c.Listener.SourceAllowList)
+	c.Listener.SourceDenyList = getEnvList("SOURCE_DENY_CIDRS", c.Listener.SourceDenyList)
+	c.Listener.IdleTimeout.Duration = getEnvDuration("CONNECTION_IDLE_TIMEOUT", c.Listener.IdleTimeout.Duration)
+	c.Listener.MaxTimeout.Duration = getEnvDuration("CONNECTION_MAX_TIMEOUT", c.Listener.MaxTimeout.Duration)
+	c.Listener.HandshakeTimeout.Duration = getEnvDuration("CONNECTION_HANDSHAKE_TIMEOUT", c.Listener.HandshakeTimeout.Duration)
+
+	if file, exists := os.LookupEnv("HOST_KEY_FILE"); exists {
+		c.HostKeys = []HostKeyConfig{{Key: file}}
+	}
+	if _, exists := os.LookupEnv("HOST_KEY_FILES"); exists {
+		c.HostKeys = nil
+		for _, file := range getEnvList("HOST_KEY_FILES", nil) {
+			c.HostKeys = append(c.HostKeys, HostKeyConfig{Key: file})
+		}
+	}
+	c.HostKeySecret = getEnv("HOST_KEY_SECRET", c.HostKeySecret)
+	c.HostKeyConfigMap = getEnv("HOST_KEY_CONFIGMAP", c.HostKeyConfigMap)
+	c.Defaults.Image = getEnv("DEBUG_IMAGE", c.Defaults.Image)
+	c.Defaults.IdleTimeout.Duration = getEnvDuration("IDLE_TIMEOUT", c.Defaults.IdleTimeout.Duration)
+	c.Defaults.MaxSessionDuration.Duration = getEnvDuration("MAX_SESSION_DURATION", c.Defaults.MaxSessionDuration.Duration)
+	c.Defaults.KeepAliveInterval.Duration = getEnvDuration("KEEPALIVE_INTERVAL", c.Defaults.KeepAliveInterval.Duration)
+	c.FanOutParallelism = getEnvInt("FANOUT_PARALLELISM", c.FanOutParallelism)
+	c.PublicAddress = getEnv("PUBLIC_ADDRESS", c.PublicAddress)
+	c.ServerName = getEnv("POD_NAME", c.ServerName)
+	c.ServerNamespace = getEnv("POD_NAMESPACE", c.ServerNamespace)
+
+	c.Limits.Sessions = getEnvInt("MAX_SESSIONS", c.Limits.Sessions)
+	c.Limits.SessionsPerUser = getEnvInt("MAX_SESSIONS_PER_USER", c.Limits.SessionsPerUser)
+	c.Limits.SessionsPerPod = getEnvInt("MAX_SESSIONS_PER_POD", c.Limits.SessionsPerPod)
+	c.Limits.SessionsPerRoute = getEnvInt("MAX_SESSIONS_PER_ROUTE", c.Limits.SessionsPerRoute)
+	c.Limits.Handshakes = getEnvInt("MAX_HANDSHAKES", c.Limits.Handshakes)
+
+	c.Guard.ConnectionRate = getEnvFloat("GUARD_CONNECTION_RATE", c.Guard.ConnectionRate)
+	c.Guard.ConnectionBurst = getEnvInt("GUARD_CONNECTION_BURST", c.Guard.ConnectionBurst)
+	c.Guard.Backoff.Duration = getEnvDuration("GUARD_BACKOFF", c.Guard.Backoff.Duration)
+	c.Guard.BanAfter = getEnvInt("GUARD_BAN_AFTER", c.Guard.BanAfter)
+	c.Guard.BanDuration.Duration = getEnvDuration("GUARD_BAN_DURATION", c.Guard.BanDuration.Duration)
+	c.Guard.AllowList = getEnvList("GUARD_ALLOW_CIDRS", c.Guard.AllowList)
+
+	c.Recording.Sink = getEnv("RECORDING_SINK", c.Recording.Sink)
+	c.Recording.Dir = getEnv("RECORDING_DIR", c.Recording.Dir)
+	c.Recording.S3.Endpoint = getEnv("RECORDING_S3_ENDPOINT", c.Recording.S3.Endpoint)
+	c.Recording.S3.Region = getEnv("RECORDING_S3_REGION", c.Recording.S3.Region)
+	c.Recording.S3.Bucket = getEnv("RECORDING_S3_BUCKET", c.Recording.S3.Bucket)
+	c.Recording.S3.Prefix = getEnv("RECORDING_S3_PREFIX", c.Recording.S3.Prefix)
+	c.Recording.S3.AccessKey = getEnv("RECORDING_S3_ACCESS_KEY", c.Recording.S3.AccessKey)
+	c.Recording.S3.SecretKey = getEnv("RECORDING_S3_SECRET_KEY", c.Recording.S3.SecretKey)
+	c.Recording.S3.Insecure = getEnvBool("RECORDING_S3_INSECURE", c.Recording.S3.Insecure)
+
+	c.Audit.Sink = getEnv("AUDIT_SINK", c.Audit.Sink)
+	c.Audit.File = getEnv("AUDIT_FILE", c.Audit.File)
+	c.Audit.FileMaxSizeMB = getEnvInt("AUDIT_FILE_MAX_SIZE_MB", c.Audit.FileMaxSizeMB)
+	c.Audit.FileMaxBackups = getEnvInt("AUDIT_FILE_MAX_BACKUPS", c.Audit.FileMaxBackups)
+	c.Audit.FileMaxAgeDays = getEnvInt("AUDIT_FILE_MAX_AGE_DAYS", c.Audit.FileMaxAgeDays)
+	c.Audit.SyslogNetwork = getEnv("AUDIT_SYSLOG_NETWORK", c.Audit.SyslogNetwork)
+	c.Audit.SyslogAddress = getEnv("AUDIT_SYSLOG_ADDRESS", c.Audit.SyslogAddress)
+
+	c.Tracing.Exporter = getEnv("TRACING_EXPORTER", c.Tracing.Exporter)
+	c.Tracing.Endpoint = getEnv("TRACING_OTLP_ENDPOINT", c.Tracing.Endpoint)
+	c.Tracing.Insecure = getEnvBool("TRACING_OTLP_INSECURE", c.Tracing.Insecure)
+	c.Tracing.SampleRatio = getEnvRatio("TRACING_SAMPLE_RATIO", c.Tracing.SampleRatio)
+
+	c.Crypto.Profile = getEnv("CRYPTO_PROFILE", c.Crypto.Profile)
+	c.Crypto.KeyExchanges = getEnvList("CRYPTO_KEY_EXCHANGES", c.Crypto.KeyExchanges)
+	c.Crypto.Ciphers = getEnvList("CRYPTO_CIPHERS", c.Crypto.Ciphers)
+	c.Crypto.MACs = getEnvList("CRYPTO_MACS", c.Crypto.MACs)
+	c.Crypto.HostKeyAlgorithms = getEnvList("CRYPTO_HOST_KEY_ALGORITHMS", c.Crypto.HostKeyAlgorithms)
+	c.Crypto.PublicKeyAlgorithms = getEnvList("CRYPTO_PUBLIC_KEY_ALGORITHMS", c.Crypto.PublicKeyAlgorithms)
+}
+
+// Files returns the files the configuration refers to, which are reloaded
+// when they change: the host keys and their certificates.
+func (c *ServerConfig) Files() []string {
+	var files []string
+	for _, key := range c.HostKeys {
+		cert, _ := key.CertificateFile()
+		files = append(files, key.Key, cert)
+	}
+	return files
+}
+
+// Validate returns the errors of all the invalid fields joined, or nil.
+func (c *ServerConfig) Validate() error {
+
+	var errs []error
+	invalid := func(field string, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: %s", field, fmt.Sprintf(format, args...)))
+	}
+	cidrs := func(field string, list []string) {
+		if _, err := ParseCIDRs(list); err != nil {
+			invalid(field, "%v", err)
+		}
+	}
+	durations := map[string]metav1.Duration{
+		"listener.idleTimeout":        c.Listener.IdleTimeout,
+		"listener.maxTimeout":         c.Listener.MaxTimeout,
+		"listener.handshakeTimeout":   c.Listener.HandshakeTimeout,
+		"defaults.idleTimeout":        c.Defaults.IdleTimeout,
+		"defaults.maxSessionDuration": c.Defaults.MaxSessionDuration,
+		"defaults.keepAliveInterval":  c.Defaults.KeepAliveInterval,
+		"guard.backoff":               c.Guard.Backoff,
+		"guard.banDuration":           c.Guard.BanDuration,
+	}
+	for field, d := range durations {
+		if d.Duration < 0 {
+			invalid(field, "must not be negative")
+		}
+	}
+	counts := map[string]int{
+		"limits.sessions":         c.Limits.Sessions,
+		"limits.sessionsPerUser":  c.Limits.SessionsPerUser,
+		"limits.sessionsPerPod":   c.Limits.SessionsPerPod,
+		"limits.sessionsPerRoute": c.Limits.SessionsPerRoute,
+		"limits.handshakes":       c.Limits.Handshakes,
+		"guard.connectionBurst":   c.Guard.ConnectionBurst,
+		"guard.banAfter":          c.Guard.BanAfter,
+	}
+	for field, n := range counts {
+		if n < 0 {
+			invalid(field, "must not be negative")
+		}
+	}
+
+	if c.Listener.BindAddress == "" {
+		invalid("listener.bindAddress", "must be set")
 	}
+	cidrs("listener.proxyTrustedCIDRs", c.Listener.ProxyTrustedCIDRs)
+	if c.Listener.ProxyProtocol && len(c.Listener.ProxyTrustedCIDRs) == 0 {
+		invalid("listener.proxyTrustedCIDRs", "must be set for the PROXY protocol")
+	}
+	cidrs("listener.sourceAllowCIDRs", c.Listener.SourceAllowList)
+	cidrs("listener.sourceDenyCIDRs", c.Listener.SourceDenyList)
+	if len(c.HostKeys) == 0 && c.HostKeySecret == "" {
+		invalid("hostKeys", "must be set unless hostKeySecret is set")
+	}
+	for i, key := range c.HostKeys {
+		if key.Key == "" {
+			invalid(fmt.Sprintf("hostKeys[%d].key", i), "must be set")
+		}
+	}
+	if c.HostKeySecret != "" && c.ServerNamespace == "" {
+		invalid("hostKeySecret", "requires POD_NAMESPACE to be set")
+	}
+	if c.HostKeyConfigMap != "" && c.ServerNamespace == "" {
+		invalid("hostKeyConfigMap", "requires POD_NAMESPACE to be set")
+	}
+	if c.FanOutParallelism <= 0 {
+		invalid("fanOutParallelism", "must be positive")
+	}
+	if c.Guard.ConnectionRate < 0 {
+		invalid("guard.connectionRate", "must not be negative")
+	}
+	cidrs("guard.allowCIDRs", c.Guard.AllowList)
+
+	switch c.Recording.Sink {
+	case "", RecordingSinkLocal:
+	case RecordingSinkS3:
+		if c.Recording.S3.Bucket == "" {
+			invalid("recording.s3.bucket", "must be set for the s3 sink")
+		}
+	default:
+		invalid("recording.sink", "unknown sink %q", c.Recording.Sink)
+	}
+	switch c.Audit.Sink {
+	case "", AuditSinkNone, AuditSinkStdout:
+	case AuditSinkFile:
+		if c.Audit.File == "" {
+			invalid("audit.file", "must be set for the file sink")
+		}
+	case AuditSinkSyslog:
+		if c.Audit.SyslogAddress == "" {
+			invalid("audit.syslogAddress", "must be set for the syslog sink")
+		}
+	default:
+		invalid("audit.sink", "unknown sink %q", c.Audit.Sink)
+	}
+	switch c.Tracing.Exporter {
+	case "", TracingExporterNone, TracingExporterStderr, TracingExporterOtlp:
+	case TracingExporterStdout:
+		// The spans would be mixed into the audit events
+		if c.Audit.Sink == "" || c.Audit.Sink == AuditSinkStdout {
+			invalid("tracing.exporter", "must not be stdout with the stdout audit sink, use stderr")
+		}
+	default:
+		invalid("tracing.exporter", "unknown exporter %q", c.Tracing.Exporter)
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		invalid("tracing.sampleRatio", "must be from 0 to 1")
+	}
+	c.Crypto.validate(invalid)
+
+	// The maps above are iterated in random order
+	slices.SortFunc(errs, func(a, b error) int {
+		return strings.Compare(a.Error(), b.Error())
+	})
+	return errors.Join(errs...)
+}
+
+// RestartRequired returns the fields differing in the next configuration
+// which are only applied on the restart of the server: the listener, the
+// Kubernetes objects of the host key and the sinks. The other fields are
+// applied to the new connections and sessions as soon as the configuration
+// is reloaded.
+func (c *ServerConfig) RestartRequired(next *ServerConfig) []string {
+
+	var fields []string
+	changed := func(field string, differs bool) {
+		if differs {
+			fields = append(fields, field)
+		}
+	}
+	changed("listener.bindAddress", c.Listener.BindAddress != next.Listener.BindAddress)
+	changed("listener.proxyProtocol", c.Listener.ProxyProtocol != next.Listener.ProxyProtocol)
+	changed("listener.proxyTrustedCIDRs", !slices.Equal(c.Listener.ProxyTrustedCIDRs, next.Listener.ProxyTrustedCIDRs))
+	changed("listener.idleTimeout", c.Listener.IdleTimeout != next.Listener.IdleTimeout)
+	changed("listener.maxTimeout", c.Listener.MaxTimeout != next.Listener.MaxTimeout)
+	changed("hostKeySecret", c.HostKeySecret != next.HostKeySecret)
+	changed("hostKeyConfigMap", c.HostKeyConfigMap != next.HostKeyConfigMap)
+	changed("recording", c.Recording != next.Recording)
+	changed("audit", c.Audit != next.Audit)
+	changed("tracing", c.Tracing != next.Tracing)
+	return fields
 }
 
 func getEnv(key string, defaultVal string) string {
-    if value, exists := os.LookupEnv(key); exists {
+	if value, exists := os.LookupEnv(key); exists {
 		return value
-    }
+	}
+
+	return defaultVal
+}
+
+// getEnvBool returns true if the environment variable is "true", or the
+// default value if the variable is not set.
+func getEnvBool(key string, defaultVal bool) bool {
+	if value, exists := os.LookupEnv(key); exists {
+		return value == "true"
+	}
+
+	return defaultVal
+}
+
+// getEnvInt returns the positive integer value of the environment variable,
+// or the default value if the variable is not set or is not valid.
+func getEnvInt(key string, defaultVal int) int {
+	if value, exists := os.LookupEnv(key); exists {
+		if i, err := strconv.Atoi(value); err == nil && i > 0 {
+			return i
+		}
+	}
+
+	return defaultVal
+}
+
+// hostname returns the host name, which is the pod name in Kubernetes.
+func hostname() string {
+	name, _ := os.Hostname()
+	return name
+}
 
-    return defaultVal
+// getEnvRatio returns the value of the environment variable as a ratio from
+// 0 to 1, or the default value if the variable is not set or is not valid.
+func getEnvRatio(key string, defaultVal float64) float64 {
+	if value, exists := os.LookupEnv(key); exists {
+		if f, err := strconv.ParseFloat(value, 64); err == nil && f >= 0 && f <= 1 {
+			return f
+		}
+	}
+
+	return defaultVal
+}
+
+// getEnvDuration returns the value of the environment variable as a duration,
+// f.e. 30m, or the default value if the variable is not set or is not valid.
+func getEnvDuration(key string, defaultVal time.Duration) time.Duration {
+	if value, exists := os.LookupEnv(key); exists {
+		if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+			return d
+		}
+	}
+
+	return defaultVal
+}
+
+// getEnvFloat returns the non-negative value of the environment variable, or
+// the default value if the variable is not set or is not valid.
+func getEnvFloat(key string, defaultVal float64) float64 {
+	if value, exists := os.LookupEnv(key); exists {
+		if f, err := strconv.ParseFloat(value, 64); err == nil && f >= 0 {
+			return f
+		}
+	}
+
+	return defaultVal
+}
+
+// getEnvList returns the comma-separated list of the environment variable,
+// or the default value if the variable is not set. The variable set to the
+// empty string clears the list.
+func getEnvList(key string, defaultVal []string) []string {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultVal
+	}
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
 }
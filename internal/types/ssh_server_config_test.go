@@ -0,0 +1,116 @@
+package types
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConf(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("unable to write the configuration: %v", err)
+	}
+	return path
+}
+
+func TestLoadServerConf(t *testing.T) {
+
+	path := writeConf(t, `
+listener:
+  bindAddress: ":2222"
+  sourceAllowCIDRs: ["10.0.0.0/8"]
+defaults:
+  image: alpine
+  idleTimeout: 30m
+limits:
+  sessionsPerUser: 5
+guard:
+  banDuration: 15m
+`)
+	t.Setenv("DEBUG_IMAGE", "busybox:musl")
+	t.Setenv("SOURCE_ALLOW_CIDRS", "192.168.0.0/16")
+
+	conf, err := LoadServerConf(path)
+	if err != nil {
+		t.Fatalf("LoadServerConf() error = %v", err)
+	}
+
+	if conf.Listener.BindAddress != ":2222" || conf.Limits.SessionsPerUser != 5 {
+		t.Errorf("expected the values of the file, got %+v", conf)
+	}
+	if conf.Defaults.IdleTimeout.Duration != 30*time.Minute || conf.Guard.BanDuration.Duration != 15*time.Minute {
+		t.Errorf("expected the durations of the file, got %s and %s",
+			conf.Defaults.IdleTimeout.Duration, conf.Guard.BanDuration.Duration)
+	}
+	if conf.Defaults.Image != "busybox:musl" {
+		t.Errorf("expected the environment to override the file, got image %q", conf.Defaults.Image)
+	}
+	if len(conf.Listener.SourceAllowList) != 1 || conf.Listener.SourceAllowList[0] != "192.168.0.0/16" {
+		t.Errorf("expected the environment to override the list, got %v", conf.Listener.SourceAllowList)
+	}
+	if conf.Guard.ConnectionBurst != 20 || conf.Defaults.KeepAliveInterval.Duration != 30*time.Second {
+		t.Errorf("expected the defaults for the fields not set, got %+v", conf)
+	}
+}
+
+func TestLoadServerConfErrors(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		content string
+		errors  []string
+	}{
+		{"unknown field", "limits:\n  session: 5\n", []string{"unknown field"}},
+		{"invalid duration", "guard:\n  backoff: 5\n", []string{"unable to parse"}},
+		{"invalid values", `
+listener:
+  proxyProtocol: true
+  sourceDenyCIDRs: ["10.0.0.0/33"]
+limits:
+  sessions: -1
+recording:
+  sink: ftp
+`, []string{"listener.proxyTrustedCIDRs", "listener.sourceDenyCIDRs", "limits.sessions", "recording.sink"}},
+		{"no host keys", "hostKeys: []\n", []string{"hostKeys: must be set"}},
+		{"invalid crypto", "crypto:\n  profile: legacy\n  ciphers: [aes256-cbc]\n",
+			[]string{"crypto.profile", "crypto.ciphers"}},
+		{"tracing and audit to stdout", "tracing:\n  exporter: stdout\n", []string{"tracing.exporter: must not be stdout"}},
+		{"host key secret without namespace", "hostKeys: []\nhostKeySecret: ingressh-hostkey\n", []string{"hostKeySecret: requires POD_NAMESPACE"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadServerConf(writeConf(t, tt.content))
+			if err == nil {
+				t.Fatalf("expected the configuration to be rejected")
+			}
+			for _, expected := range tt.errors {
+				if !strings.Contains(err.Error(), expected) {
+					t.Errorf("expected the error about %s, got %v", expected, err)
+				}
+			}
+		})
+	}
+}
+
+func TestServerConfigRestartRequired(t *testing.T) {
+
+	conf := DefaultServerConf()
+	next := DefaultServerConf()
+	next.Limits.Sessions = 10
+	next.Guard.ConnectionRate = 5
+	next.Listener.SourceDenyList = []string{"10.0.0.0/8"}
+	if fields := conf.RestartRequired(next); len(fields) != 0 {
+		t.Errorf("expected the limits, the guard and the source lists to be reloadable, got %v", fields)
+	}
+
+	next.Listener.BindAddress = ":2222"
+	next.Audit.Sink = AuditSinkNone
+	fields := conf.RestartRequired(next)
+	if strings.Join(fields, ",") != "listener.bindAddress,audit" {
+		t.Errorf("expected the listener and the audit to require the restart, got %v", fields)
+	}
+}
@@ -1,14 +1,26 @@
 package types
 
-import "regexp"
+import (
+	"regexp"
+	"strings"
+)
 
 // SshTarget specifies the target K8s object to route the SSH session to.
 type SshTarget struct {
+	Alias     string
 	Namespace string
+	// Pod is the name of the pod. As a hint supplied by the user it could be
+	// a glob pattern or a workload reference, see PodRef.
 	Pod       string
 	Container string
+	// FanOut requests to run the command on all the matching targets.
+	FanOut bool
 }
 
+// fanOutPrefix is the prefix of the login string requesting to run the
+// command on all the matching targets.
+const fanOutPrefix = "all:"
+
 // Regular expression to extract target object hints from the login string
 // supplied by the user for SSH connection.
 // The format is namespace?:pod?:container?
@@ -16,15 +28,65 @@ var hintsRe = regexp.MustCompile(
 	`^(?P<ns>[^:]*)?:(?P<pod>[^:]*)?:(?P<container>[^:]*)?$`,
 )
 
+// Regular expression to detect the login string which could name an alias
+// of the IngreSsh resource.
+var aliasRe = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
 // InitFromUsername configures the object to assign target "hints" extracted
 // from the user-supplied login string.
 //
-// If the user supplied namespace?:pod?:container? in the login part of the
-// connection string as a hint, relevant values will be assigned to the fields
-// of this SshTarget object. The corresponding fields remains empty if the
-// username doesn't contain hint information.
+// The following forms of the login string are recognized:
+//   - namespace?:pod?:container? where the pod could be a glob pattern or a
+//     workload reference, like `prod:deploy/api:app`
+//   - the slash form namespace/pod/container, namespace/pod, where the pod
+//     could be a workload reference too, like `prod/sts/db-0/postgres`
+//   - the workload reference with an optional container, like `deploy/api`
+//     or `job/migrate/app`
+//   - the glob pattern of the pod names, like `nginx-*`
+//
+// Any of the forms above (or an alias) prefixed with `all:` requests the
+// fan-out of the command to all the matching targets, like `all:deploy/api`.
+// The prefix is not recognized in the all:pod:container form, which is the
+// regular hint for the namespace "all".
+//
+// If the user supplied hints in the login part of the connection string,
+// relevant values will be assigned to the fields of this SshTarget object.
+// The corresponding fields remains empty if the username doesn't contain
+// hint information.
+//
+// A login string without the hint information is kept as a possible alias
+// of the route. Whether it is an alias or just the user's login name is up
+// to the routing configuration.
 func (s *SshTarget) InitFromUsername(username string) {
 
+	if rest, found := strings.CutPrefix(username, fanOutPrefix); found && strings.Count(username, ":") != 2 {
+		s.FanOut = true
+		username = rest
+	}
+
+	if aliasRe.MatchString(username) {
+		s.Alias = username
+		return
+	}
+
+	if strings.Contains(username, ":") {
+		s.initFromColonHints(username)
+		return
+	}
+
+	if strings.Contains(username, "/") {
+		s.initFromSlashHints(username)
+		return
+	}
+
+	if isPattern(username) {
+		s.Pod = username
+	}
+}
+
+// initFromColonHints parses the namespace?:pod?:container? login string.
+func (s *SshTarget) initFromColonHints(username string) {
+
 	matches := hintsRe.FindStringSubmatch(username)
 	if len(matches) == 0 {
 		return
@@ -39,7 +101,63 @@ func (s *SshTarget) InitFromUsername(username string) {
 	s.Container = matches[idxContainer]
 }
 
+// initFromSlashHints parses the login string with slash-separated
+// components. The workload kinds make the form unambiguous: the component
+// following the kind is always the workload name.
+func (s *SshTarget) initFromSlashHints(username string) {
+
+	parts := strings.Split(username, "/")
+
+	// Starts with a workload: kind/name[/container]
+	if lookupKind(parts[0]) != "" {
+		switch len(parts) {
+		case 2:
+			s.Pod = parts[0] + "/" + parts[1]
+		case 3:
+			s.Pod = parts[0] + "/" + parts[1]
+			s.Container = parts[2]
+		}
+		return
+	}
+
+	// Namespace followed by a workload: namespace/kind/name[/container]
+	if len(parts) > 2 && lookupKind(parts[1]) != "" {
+		switch len(parts) {
+		case 3:
+			s.Namespace = parts[0]
+			s.Pod = parts[1] + "/" + parts[2]
+		case 4:
+			s.Namespace = parts[0]
+			s.Pod = parts[1] + "/" + parts[2]
+			s.Container = parts[3]
+		}
+		return
+	}
+
+	// Namespace and pod: namespace/pod[/container]
+	switch len(parts) {
+	case 2:
+		s.Namespace = parts[0]
+		s.Pod = parts[1]
+	case 3:
+		s.Namespace = parts[0]
+		s.Pod = parts[1]
+		s.Container = parts[2]
+	}
+}
+
+// Login returns the login string selecting the target, in the
+// namespace:pod:container form, which keeps the workload references and the
+// patterns of the pod unambiguous.
+func (s SshTarget) Login() string {
+	login := s.Namespace + ":" + s.Pod + ":" + s.Container
+	if s.FanOut {
+		return fanOutPrefix + login
+	}
+	return login
+}
+
 // IsComplete returns true if all components of the target are known
 func (s SshTarget) IsComplete() bool {
-	return s.Namespace != "" && s.Pod != "" && s.Container != ""
+	return s.Namespace != "" && s.Container != "" && ParsePodRef(s.Pod).IsExact()
 }
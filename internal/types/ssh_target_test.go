@@ -0,0 +1,79 @@
+package types
+
+import (
+	"testing"
+)
+
+func TestInitFromUsername(t *testing.T) {
+
+	tests := []struct {
+		username string
+		result   SshTarget
+	}{
+		{username: "kooper", result: SshTarget{Alias: "kooper"}},
+		{username: "ns:pod:container", result: SshTarget{Namespace: "ns", Pod: "pod", Container: "container"}},
+		{username: "::container", result: SshTarget{Container: "container"}},
+		{username: ":deploy/api:", result: SshTarget{Pod: "deploy/api"}},
+		{username: "nginx-*", result: SshTarget{Pod: "nginx-*"}},
+		{username: "deploy/nginx", result: SshTarget{Pod: "deploy/nginx"}},
+		{username: "sts/db-0/postgres", result: SshTarget{Pod: "sts/db-0", Container: "postgres"}},
+		{username: "prod/job/migrate", result: SshTarget{Namespace: "prod", Pod: "job/migrate"}},
+		{username: "prod/deploy/api/app", result: SshTarget{Namespace: "prod", Pod: "deploy/api", Container: "app"}},
+		{username: "prod/api-0", result: SshTarget{Namespace: "prod", Pod: "api-0"}},
+		{username: "prod/api-0/app", result: SshTarget{Namespace: "prod", Pod: "api-0", Container: "app"}},
+		{username: "prod/api-0/app/extra", result: SshTarget{}},
+		{username: "Kooper.Name", result: SshTarget{}},
+		{username: "all:deploy/api", result: SshTarget{Pod: "deploy/api", FanOut: true}},
+		{username: "all:", result: SshTarget{FanOut: true}},
+		{username: "all:nginx-debug", result: SshTarget{Alias: "nginx-debug", FanOut: true}},
+		{username: "all:prod:nginx-*:", result: SshTarget{Namespace: "prod", Pod: "nginx-*", FanOut: true}},
+		{username: "all:pod:container", result: SshTarget{Namespace: "all", Pod: "pod", Container: "container"}},
+	}
+
+	for _, tc := range tests {
+		target := SshTarget{}
+		target.InitFromUsername(tc.username)
+		if target != tc.result {
+			t.Errorf("Unexpected target for '%s': %+v instead of %+v", tc.username, target, tc.result)
+		}
+	}
+}
+
+func TestIsComplete(t *testing.T) {
+
+	tests := []struct {
+		target   SshTarget
+		complete bool
+	}{
+		{target: SshTarget{Namespace: "ns", Pod: "pod", Container: "container"}, complete: true},
+		{target: SshTarget{Namespace: "ns", Pod: "pod/pod", Container: "container"}, complete: true},
+		{target: SshTarget{Namespace: "ns", Pod: "pod-*", Container: "container"}, complete: false},
+		{target: SshTarget{Namespace: "ns", Pod: "deploy/api", Container: "container"}, complete: false},
+		{target: SshTarget{Pod: "pod", Container: "container"}, complete: false},
+	}
+
+	for _, tc := range tests {
+		if tc.target.IsComplete() != tc.complete {
+			t.Errorf("Unexpected completeness of %+v", tc.target)
+		}
+	}
+}
+
+func TestLogin(t *testing.T) {
+
+	tests := []SshTarget{
+		{Namespace: "ns", Pod: "pod", Container: "container"},
+		{Namespace: "prod", Pod: "deploy/api", Container: "app"},
+		{Namespace: "all", Pod: "pod", Container: "container"},
+		{Namespace: "all", Pod: "deploy/api", FanOut: true},
+		{Pod: "nginx-*"},
+	}
+
+	for _, tc := range tests {
+		target := SshTarget{}
+		target.InitFromUsername(tc.Login())
+		if target != tc {
+			t.Errorf("Login %q of %+v selects %+v", tc.Login(), tc, target)
+		}
+	}
+}